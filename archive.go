@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// archive is the transcript every session leaves, unless turned off, in a
+// file named after the day and time it started and its project. One left
+// empty, by a session that printed nothing, is removed on Close. A nil
+// *archive keeps nothing.
+type archive struct {
+	*transcript
+	path string
+}
+
+// newArchive starts the archived transcript of a session starting now,
+// under dir, or archive/ under the state directory if dir is empty, with
+// secrets masked by redact if it is set.
+func newArchive(dir string, redact *redactor) (*archive, error) {
+	if dir == "" {
+		state, err := stateDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(state, "archive")
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	dir = filepath.Join(dir, now.Format("2006-01-02"))
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("%s-%s-%d.txt", now.Format("150405"), filepath.Base(projectRoot(wd)), os.Getpid())
+	path := filepath.Join(dir, name)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &archive{transcriptTo(redactTo(file, redact)), path}, nil
+}
+
+func (a *archive) Close() error {
+	if a == nil {
+		return nil
+	}
+	err := a.transcript.Close()
+	if info, serr := os.Stat(a.path); serr == nil && info.Size() == 0 {
+		_ = os.Remove(a.path)
+	}
+	return err
+}
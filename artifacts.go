@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/samuelstevens/claude-unfocused/internal/vt"
+)
+
+// artifactModes are the accepted values of artifacts, where relative
+// --record, --transcript, --trace, --log-output, --stderr-log and --audit
+// paths, and screen dumps, are put:
+//
+//	""       the working directory
+//	project  .claude-unfocused/ in the project's root
+//	state    projects/<the project root's path> under the state directory
+//
+// The project is the git repository holding the working directory, or the
+// working directory itself outside one.
+var artifactModes = map[string]bool{"": true, "project": true, "state": true}
+
+// projectRoot returns the root of the git repository holding dir, or dir
+// if there is none.
+func projectRoot(dir string) string {
+	for d := dir; ; {
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			return d
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return dir
+		}
+		d = parent
+	}
+}
+
+// artifactDir returns the directory for the artifacts of the project
+// holding the working directory in mode, creating it if needed, or "" for
+// the working directory.
+func artifactDir(mode string) (string, error) {
+	if mode == "" {
+		return "", nil
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	root := projectRoot(wd)
+	if mode == "state" {
+		state, err := stateDir()
+		if err != nil {
+			return "", err
+		}
+		vol := filepath.VolumeName(root)
+		mirrored := strings.TrimLeft(strings.TrimPrefix(root, vol), `/\`)
+		dir := filepath.Join(state, "projects", strings.Trim(vol, `:/\`), mirrored)
+		return dir, os.MkdirAll(dir, 0o700)
+	}
+	dir := filepath.Join(root, ".claude-unfocused")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	// Keep the artifacts out of the project's commits
+	ignore := filepath.Join(dir, ".gitignore")
+	if _, err := os.Stat(ignore); os.IsNotExist(err) {
+		_ = os.WriteFile(ignore, []byte("*\n"), 0o644)
+	}
+	return dir, nil
+}
+
+// artifactPath returns where to write the artifact path: unchanged if it is
+// absolute or dir is "", or else under dir.
+func artifactPath(dir, path string) string {
+	if dir == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// dumpScreen writes the text on screen to a file named for the time, in the
+// artifact directory mode gives, returning its path.
+func dumpScreen(screen *vt.Screen, mode string) (string, error) {
+	dir, err := artifactDir(mode)
+	if err != nil {
+		return "", err
+	}
+	path := artifactPath(dir, fmt.Sprintf("screen-%s.txt", time.Now().Format("20060102-150405.000")))
+	text := strings.TrimRight(strings.Join(screen.Lines(), "\n"), "\n") + "\n"
+	return path, os.WriteFile(path, []byte(text), 0o600)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/samuelstevens/claude-unfocused/internal/ansiparse"
+)
+
+// auditEntry is one line of an audit log: a line of input sent to the
+// child with Enter, numbered from 1. Hash is the SHA-256, in hex, of Prev,
+// Seq, Time and Line, each followed by a newline; Prev is the previous
+// entry's Hash, empty for the first. Changing, removing or reordering an
+// entry breaks every hash after it.
+type auditEntry struct {
+	Seq  int    `json:"seq"`
+	Time string `json:"time"`
+	Line string `json:"line"`
+	Prev string `json:"prev"`
+	Hash string `json:"hash"`
+}
+
+func (e auditEntry) sum() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%d\n%s\n%s\n", e.Prev, e.Seq, e.Time, e.Line)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// auditLog keeps a hash-chained log of the lines of input sent to the
+// child, as JSON lines. Lines are assembled from the keys typed: escape
+// sequences are dropped, backspace and ctrl-U edit the line, ctrl-C clears
+// it and Enter submits it. Newlines inside a bracketed paste stay in the
+// line. Opening an existing log appends to its chain. A nil *auditLog
+// discards everything.
+type auditLog struct {
+	mu      sync.Mutex
+	f       *os.File
+	redact  *redactor
+	parser  ansiparse.Parser
+	line    []byte
+	pasting bool
+	last    auditEntry
+}
+
+func newAuditLog(path string, redact *redactor) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	a := &auditLog{f: f, redact: redact}
+	// Carry on from the last entry
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		var e auditEntry
+		if json.Unmarshal(sc.Bytes(), &e) == nil {
+			a.last = e
+		}
+	}
+	if err := sc.Err(); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return a, nil
+}
+
+// input notes bytes written to the child.
+func (a *auditLog) input(b []byte) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.f == nil {
+		return
+	}
+	a.parser.Feed(b, func(seq ansiparse.Sequence) {
+		switch seq.Kind {
+		case ansiparse.Text:
+			a.line = append(a.line, seq.Raw...)
+		case ansiparse.Control:
+			switch c := seq.Raw[0]; {
+			case a.pasting && (c == '\r' || c == '\n' || c == '\t'):
+				a.line = append(a.line, c)
+			case c == '\r':
+				a.submit()
+			case c == 0x7f || c == '\b':
+				_, size := utf8.DecodeLastRune(a.line)
+				a.line = a.line[:len(a.line)-size]
+			case c == 0x15 || c == 0x03: // ctrl-U, ctrl-C
+				a.line = a.line[:0]
+			}
+		case ansiparse.CSI:
+			switch string(seq.Raw) {
+			case "\x1b[200~":
+				a.pasting = true
+			case "\x1b[201~":
+				a.pasting = false
+			}
+		}
+	})
+}
+
+// submit writes the line as an entry. The caller holds mu.
+func (a *auditLog) submit() {
+	line := bytes.ReplaceAll(a.redact.mask(a.line), []byte("\r"), []byte("\n"))
+	a.line = a.line[:0]
+	if len(bytes.TrimSpace(line)) == 0 {
+		return
+	}
+	e := auditEntry{
+		Seq:  a.last.Seq + 1,
+		Time: time.Now().UTC().Format(time.RFC3339Nano),
+		Line: string(line),
+		Prev: a.last.Hash,
+	}
+	e.Hash = e.sum()
+	b, _ := json.Marshal(e)
+	if _, err := a.f.Write(append(b, '\n')); err == nil {
+		a.last = e
+	}
+}
+
+func (a *auditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	err := a.f.Close()
+	a.f = nil
+	return err
+}
+
+const auditUsage = "usage: claude-unfocused audit <file>"
+
+// runAudit implements the audit subcommand, which verifies an audit log.
+func runAudit(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, auditUsage)
+		return 2
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "audit: %v\n", err)
+		return 1
+	}
+	defer func() { _ = f.Close() }()
+	n, err := verifyAudit(f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "audit: %s: %v\n", args[0], err)
+		return 1
+	}
+	fmt.Printf("%s: %d entries, chain intact\n", args[0], n)
+	return 0
+}
+
+// verifyAudit checks the hash chain of the audit log r, returning the
+// number of entries.
+func verifyAudit(r io.Reader) (int, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	var last auditEntry
+	n := 0
+	for sc.Scan() {
+		n++
+		var e auditEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return n, fmt.Errorf("line %d: %w", n, err)
+		}
+		switch {
+		case e.Prev != last.Hash || e.Seq != last.Seq+1:
+			return n, fmt.Errorf("line %d: entry %d doesn't follow entry %d", n, e.Seq, last.Seq)
+		case e.sum() != e.Hash:
+			return n, fmt.Errorf("line %d: entry %d was changed", n, e.Seq)
+		}
+		last = e
+	}
+	return n, sc.Err()
+}
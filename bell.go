@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// bellCooldown limits how often a bell may notify or run the bell command,
+// so a child that rings repeatedly does not flood the desktop.
+const bellCooldown = time.Second
+
+// bellModes are the accepted values of the bell setting: whether a bell is
+// passed to the terminal and whether it sends a desktop notification.
+var bellModes = map[string]struct{ pass, notify bool }{
+	"pass":   {pass: true},
+	"notify": {notify: true},
+	"both":   {pass: true, notify: true},
+	"none":   {},
+}
+
+// bellWatcher passes child output to w, spotting bells (BEL outside of an
+// OSC or other string sequence, where it is a terminator) on the way. It
+// tracks just enough of the escape syntax for that, so output is never held
+// back.
+type bellWatcher struct {
+	w       io.Writer
+	pass    bool
+	notify  bool
+	command string // run through the shell on each bell
+	name    string
+	state   byte // 0, or ESC, or ']' inside a string, or '\\' after ESC in one
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+func newBellWatcher(w io.Writer, cfg bellConfig, argv []string) *bellWatcher {
+	mode := bellModes[cfg.Mode]
+	return &bellWatcher{w: w, pass: mode.pass, notify: mode.notify, command: cfg.Command, name: commandName(argv)}
+}
+
+func (b *bellWatcher) Write(p []byte) (int, error) {
+	out := p[:0:0]
+	start := 0
+	for i, c := range p {
+		if !b.scan(c) {
+			continue
+		}
+		b.ring()
+		if !b.pass {
+			out = append(out, p[start:i]...)
+			start = i + 1
+		}
+	}
+	if start == 0 {
+		out = p
+	} else {
+		out = append(out, p[start:]...)
+	}
+	if _, err := b.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// scan advances the escape state by c and reports whether c rings the bell.
+func (b *bellWatcher) scan(c byte) bool {
+	switch {
+	case c == 0x18 || c == 0x1a: // CAN and SUB abort any sequence
+		b.state = 0
+	case b.state == ']':
+		switch c {
+		case 0x07:
+			b.state = 0
+		case 0x1b:
+			b.state = '\\'
+		}
+	case b.state == '\\':
+		b.state = 0
+		if c != '\\' {
+			// Not a string terminator: the ESC starts a new sequence
+			b.state = 0x1b
+			return b.scan(c)
+		}
+	case c == 0x1b:
+		b.state = 0x1b
+	case b.state == 0x1b:
+		b.state = 0
+		switch c {
+		case ']', 'P', 'X', '^', '_': // OSC, DCS, SOS, PM, APC
+			b.state = ']'
+		}
+		return c == 0x07
+	default:
+		return c == 0x07
+	}
+	return false
+}
+
+// ring notifies and runs the bell command, at most once per bellCooldown.
+func (b *bellWatcher) ring() {
+	if !b.notify && b.command == "" {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if time.Since(b.last) < bellCooldown {
+		return
+	}
+	b.last = time.Now()
+	go func() {
+		if b.notify {
+			if err := notify("claude-unfocused", b.name+" rang the bell"); err != nil {
+				notice("notification failed: " + err.Error())
+			}
+		}
+		if b.command != "" {
+			if err := shellCommand(b.command).Run(); err != nil {
+				notice(fmt.Sprintf("bell command failed: %v", err))
+			}
+		}
+	}()
+}
+
+// shellCommand runs command through the platform's shell.
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// charmap is a single-byte character set: the character each byte stands
+// for, utf8.RuneError where it stands for none, and the byte for each.
+type charmap struct {
+	runes [256]rune
+	bytes map[rune]byte
+}
+
+func newCharmap(high func(b byte) rune) *charmap {
+	m := &charmap{bytes: map[rune]byte{}}
+	for i := range 256 {
+		r := rune(i)
+		if i >= 0x80 {
+			r = high(byte(i))
+		}
+		m.runes[i] = r
+		if r != utf8.RuneError {
+			m.bytes[r] = byte(i)
+		}
+	}
+	return m
+}
+
+// cp1252High are the characters Windows-1252 has in place of the C1
+// controls that Latin-1 has at 0x80 to 0x9f.
+var cp1252High = [32]rune{
+	'€', utf8.RuneError, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', utf8.RuneError, 'Ž', utf8.RuneError,
+	utf8.RuneError, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', utf8.RuneError, 'ž', 'Ÿ',
+}
+
+// charsets are the accepted values of charset.terminal and charset.child,
+// by their canonical names; UTF-8 is nil, needing no table.
+var charsets = map[string]*charmap{
+	"utf-8":  nil,
+	"latin1": newCharmap(func(b byte) rune { return rune(b) }),
+	"cp1252": newCharmap(func(b byte) rune {
+		if b < 0xa0 {
+			return cp1252High[b-0x80]
+		}
+		return rune(b)
+	}),
+	"ascii": newCharmap(func(byte) rune { return utf8.RuneError }),
+}
+
+// charsetAliases are other names the charsets go by.
+var charsetAliases = map[string]string{
+	"utf8":         "utf-8",
+	"iso-8859-1":   "latin1",
+	"iso8859-1":    "latin1",
+	"latin-1":      "latin1",
+	"windows-1252": "cp1252",
+	"us-ascii":     "ascii",
+}
+
+// charsetName returns the canonical name of the charset called name, or ""
+// if it isn't one of the charsets.
+func charsetName(name string) string {
+	name = strings.ToLower(name)
+	if alias, ok := charsetAliases[name]; ok {
+		name = alias
+	}
+	if _, ok := charsets[name]; !ok {
+		return ""
+	}
+	return name
+}
+
+// transcoder converts text from one of the charsets to another, putting
+// replacement in place of what the other lacks. A UTF-8 character split
+// across calls is held until the rest arrives. Escape sequences, being
+// ASCII, come through whole.
+type transcoder struct {
+	from, to    *charmap
+	replacement string
+
+	mu      sync.Mutex
+	partial []byte // the start of a UTF-8 character
+}
+
+func newTranscoder(from, to, replacement string) *transcoder {
+	return &transcoder{from: charsets[from], to: charsets[to], replacement: replacement}
+}
+
+// convert returns b in the other charset.
+func (t *transcoder) convert(b []byte) []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]byte, 0, len(b))
+	put := func(r rune) {
+		switch {
+		case r < 0x80:
+			out = append(out, byte(r))
+		case t.to == nil && r != utf8.RuneError:
+			out = utf8.AppendRune(out, r)
+		case t.to == nil:
+			out = append(out, "�"...)
+		default:
+			if c, ok := t.to.bytes[r]; ok {
+				out = append(out, c)
+			} else {
+				out = append(out, t.replacement...)
+			}
+		}
+	}
+	if t.from != nil {
+		for _, c := range b {
+			put(t.from.runes[c])
+		}
+		return out
+	}
+	if len(t.partial) > 0 {
+		b = append(t.partial, b...)
+		t.partial = nil
+	}
+	for len(b) > 0 {
+		if b[0] < utf8.RuneSelf {
+			out = append(out, b[0])
+			b = b[1:]
+			continue
+		}
+		if !utf8.FullRune(b) {
+			t.partial = append([]byte(nil), b...)
+			break
+		}
+		r, n := utf8.DecodeRune(b)
+		put(r)
+		b = b[n:]
+	}
+	return out
+}
+
+// charsetWriter converts what is written to it on its way to w.
+type charsetWriter struct {
+	w io.Writer
+	t *transcoder
+}
+
+func (c charsetWriter) Write(b []byte) (int, error) {
+	if _, err := c.w.Write(c.t.convert(b)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+
+	"github.com/samuelstevens/claude-unfocused/internal/ansiparse"
+)
+
+// clipboardModes are the accepted values of the clipboard setting, which
+// decides what happens to OSC 52 clipboard sequences from the child:
+//
+//	allow   pass them to the terminal
+//	block   drop them
+//	log     pass them on and show a notice for each
+//	native  drop them and copy with the system clipboard tool instead
+var clipboardModes = map[string]bool{"allow": true, "block": true, "log": true, "native": true}
+
+// clipboardRule returns the output rule applying mode to OSC 52 sequences
+// from the command called name, or nil if they should just pass through.
+func clipboardRule(mode, name string) outputRule {
+	if mode == "allow" {
+		return nil
+	}
+	return func(seq ansiparse.Sequence) ([]byte, bool) {
+		data, ok := osc52(seq)
+		if !ok {
+			return nil, false
+		}
+		text, err := base64.StdEncoding.DecodeString(string(data))
+		query := string(data) == "?"
+		switch mode {
+		case "log":
+			msg := fmt.Sprintf("%s set the clipboard (%d bytes)", name, len(text))
+			if query {
+				msg = name + " asked to read the clipboard"
+			}
+			// Inline, so the notice lands after the output before it
+			return slices.Concat(seq.Raw, noticeBytes(msg)), true
+		case "native":
+			if err == nil && !query {
+				go func() {
+					if err := copyToClipboard(text); err != nil {
+						notice("clipboard copy failed: " + err.Error())
+					}
+				}()
+			}
+		}
+		return nil, true
+	}
+}
+
+// osc52 returns the payload of an OSC 52 clipboard sequence: base64 text to
+// copy, or "?" to query the clipboard.
+func osc52(seq ansiparse.Sequence) ([]byte, bool) {
+	if seq.Kind != ansiparse.OSC {
+		return nil, false
+	}
+	rest, ok := bytes.CutPrefix(seq.Data, []byte("52;"))
+	if !ok {
+		return nil, false
+	}
+	_, data, ok := bytes.Cut(rest, []byte(";")) // skip the selection
+	return data, ok
+}
+
+// setClipboard copies text for the wrapper itself: with the system
+// clipboard tool in native mode, or else by sending OSC 52 to the terminal.
+func setClipboard(mode string, text []byte) {
+	if mode == "native" {
+		go func() {
+			if err := copyToClipboard(text); err != nil {
+				notice("clipboard copy failed: " + err.Error())
+			}
+		}()
+		return
+	}
+	fmt.Fprintf(os.Stdout, "\x1b]52;c;%s\a", base64.StdEncoding.EncodeToString(text))
+}
+
+// copyToClipboard puts text on the system clipboard with the platform's
+// clipboard tool.
+func copyToClipboard(text []byte) error {
+	var cmd *exec.Cmd
+	switch {
+	case runtime.GOOS == "darwin":
+		cmd = exec.Command("pbcopy")
+	case runtime.GOOS == "windows":
+		cmd = exec.Command("clip")
+	case os.Getenv("WAYLAND_DISPLAY") != "":
+		cmd = exec.Command("wl-copy")
+	default:
+		cmd = exec.Command("xclip", "-selection", "clipboard")
+		if _, err := exec.LookPath("xclip"); err != nil {
+			cmd = exec.Command("xsel", "--clipboard", "--input")
+		}
+	}
+	cmd.Stdin = bytes.NewReader(text)
+	return cmd.Run()
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// colorModes are the accepted values of the color setting, which decides
+// what the child's environment says about color:
+//
+//	auto    leave it as it is
+//	always  ask for color, with FORCE_COLOR and CLICOLOR_FORCE
+//	never   ask for none, with NO_COLOR and FORCE_COLOR=0
+var colorModes = map[string]bool{"auto": true, "always": true, "never": true}
+
+// colorEnv returns the variables to set in the child's environment, and
+// those to remove, for mode.
+func colorEnv(mode string) (set, unset []string) {
+	switch mode {
+	case "always":
+		return []string{"FORCE_COLOR=1", "CLICOLOR_FORCE=1"}, []string{"NO_COLOR"}
+	case "never":
+		return []string{"NO_COLOR=1", "FORCE_COLOR=0"}, []string{"CLICOLOR_FORCE"}
+	}
+	return nil, nil
+}
+
+// stripColor is the SGR rewrite dropping colors, for the foreground,
+// background or underline, and keeping the rest.
+func stripColor(attr []byte) []byte {
+	head, _, _ := bytes.Cut(attr, []byte(";"))
+	head, _, _ = bytes.Cut(head, []byte(":"))
+	n, err := strconv.Atoi(string(head))
+	switch {
+	case err != nil:
+	case n >= 30 && n <= 49, n >= 90 && n <= 97, n >= 100 && n <= 107, n == 58, n == 59:
+		return nil
+	}
+	return attr
+}
+
+// colorDepths are the accepted values of downgrade_color, the palette
+// 24-bit colors are brought down to: the 256 colors of xterm's, or the 16
+// of the standard ones. Down to 16, colors from the 256 are too.
+var colorDepths = map[string]bool{"256": true, "16": true}
+
+// ansiColors are xterm's default values for the 16 standard colors.
+var ansiColors = [16][3]int{
+	{0x00, 0x00, 0x00}, {0xcd, 0x00, 0x00}, {0x00, 0xcd, 0x00}, {0xcd, 0xcd, 0x00},
+	{0x00, 0x00, 0xee}, {0xcd, 0x00, 0xcd}, {0x00, 0xcd, 0xcd}, {0xe5, 0xe5, 0xe5},
+	{0x7f, 0x7f, 0x7f}, {0xff, 0x00, 0x00}, {0x00, 0xff, 0x00}, {0xff, 0xff, 0x00},
+	{0x5c, 0x5c, 0xff}, {0xff, 0x00, 0xff}, {0x00, 0xff, 0xff}, {0xff, 0xff, 0xff},
+}
+
+// cubeLevels are the values each of red, green and blue takes in the 6x6x6
+// color cube making up colors 16 to 231 of the 256.
+var cubeLevels = [6]int{0x00, 0x5f, 0x87, 0xaf, 0xd7, 0xff}
+
+// colorDowngrade returns the SGR rewrite bringing the colors the child
+// sets, for the foreground, background or underline, down to depth, one of
+// the colorDepths.
+func colorDowngrade(depth string) sgrRewrite {
+	return func(attr []byte) []byte {
+		return downgradeColor(attr, depth == "16")
+	}
+}
+
+// downgradeColor returns the SGR attribute attr with a 24-bit color in it
+// replaced by the nearest of the 256, or of the 16 if to16, in which case
+// colors from the 256 are replaced too, the first 16 by their own SGRs.
+// Underlines, having no SGR of their own for the 16, get those as the first
+// 16 of the 256.
+func downgradeColor(attr []byte, to16 bool) []byte {
+	fields := bytes.FieldsFunc(attr, func(r rune) bool { return r == ';' || r == ':' })
+	if len(fields) < 3 {
+		return attr
+	}
+	target := string(fields[0])
+	if target != "38" && target != "48" && target != "58" {
+		return attr
+	}
+	ints := make([]int, len(fields))
+	for i, f := range fields {
+		n, err := strconv.Atoi(string(f))
+		if err != nil {
+			return attr
+		}
+		ints[i] = min(max(n, 0), 255)
+	}
+	var n int
+	switch {
+	case ints[1] == 2 && len(ints) >= 5:
+		// 38;2;r;g;b, or 38:2:colorspace:r:g:b
+		rgb := ints[len(ints)-3:]
+		if to16 {
+			n = nearestANSI(rgb[0], rgb[1], rgb[2])
+		} else {
+			n = nearest256(rgb[0], rgb[1], rgb[2])
+		}
+	case ints[1] == 5 && len(ints) == 3 && to16 && ints[2] < 16:
+		// One of the 16 already, in the form 16-color terminals may not know
+		if target == "58" {
+			return attr
+		}
+		n = ints[2]
+	case ints[1] == 5 && len(ints) == 3 && to16:
+		r, g, b := color256(ints[2])
+		n = nearestANSI(r, g, b)
+	default:
+		return attr
+	}
+	if !to16 || target == "58" {
+		return fmt.Appendf(nil, "%s;5;%d", target, n)
+	}
+	base := 30 // the foreground's 30 to 37, bright 90 to 97
+	if target == "48" {
+		base = 40
+	}
+	if n >= 8 {
+		base, n = base+60, n-8
+	}
+	return strconv.AppendInt(nil, int64(base+n), 10)
+}
+
+// nearest256 returns the one of the 256 colors, past the first 16 which
+// terminals may set as they please, nearest r, g and b: in the color cube
+// or on the gray ramp, whichever is closer.
+func nearest256(r, g, b int) int {
+	level := func(v int) int {
+		switch {
+		case v < 48:
+			return 0
+		case v < 114:
+			return 1
+		}
+		return (v - 35) / 40
+	}
+	qr, qg, qb := level(r), level(g), level(b)
+	cube := 16 + 36*qr + 6*qg + qb
+	cr, cg, cb := cubeLevels[qr], cubeLevels[qg], cubeLevels[qb]
+	if cr == r && cg == g && cb == b {
+		return cube
+	}
+	gray := min(max(((r+g+b)/3-3)/10, 0), 23)
+	gv := 8 + 10*gray
+	if distance(gv, gv, gv, r, g, b) < distance(cr, cg, cb, r, g, b) {
+		return 232 + gray
+	}
+	return cube
+}
+
+// nearestANSI returns the one of the 16 standard colors nearest r, g and b.
+func nearestANSI(r, g, b int) int {
+	best := 0
+	for i, c := range ansiColors {
+		if distance(c[0], c[1], c[2], r, g, b) < distance(ansiColors[best][0], ansiColors[best][1], ansiColors[best][2], r, g, b) {
+			best = i
+		}
+	}
+	return best
+}
+
+// color256 returns the red, green and blue of color n of the 256.
+func color256(n int) (r, g, b int) {
+	switch {
+	case n < 16:
+		c := ansiColors[n]
+		return c[0], c[1], c[2]
+	case n < 232:
+		n -= 16
+		return cubeLevels[n/36], cubeLevels[n/6%6], cubeLevels[n%6]
+	}
+	v := 8 + 10*(n-232)
+	return v, v, v
+}
+
+func distance(r1, g1, b1, r2, g2, b2 int) int {
+	dr, dg, db := r1-r2, g1-g2, b1-b2
+	return dr*dr + dg*dg + db*db
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestDowngradeColor(t *testing.T) {
+	tests := []struct {
+		attr string
+		to16 bool
+		want string
+	}{
+		{"1", true, "1"},
+		{"38;2;255;0;0", false, "38;5;196"},
+		{"38;2;255;0;0", true, "91"},
+		{"48:2::0:0:0", true, "40"},
+		{"38;5;196", false, "38;5;196"},
+		{"38;5;196", true, "91"},
+		{"38;5;0", true, "30"},
+		{"38;5;7", true, "37"},
+		{"38;5;8", true, "90"},
+		{"38;5;15", true, "97"},
+		{"48;5;1", true, "41"},
+		{"48;5;9", true, "101"},
+		{"38;5;3", false, "38;5;3"},
+		{"58;5;3", true, "58;5;3"},
+		{"58:5:3", true, "58:5:3"},
+	}
+	for _, tt := range tests {
+		if got := string(downgradeColor([]byte(tt.attr), tt.to16)); got != tt.want {
+			t.Errorf("downgradeColor(%q, %v) = %q, want %q", tt.attr, tt.to16, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+const completionUsage = "usage: claude-unfocused completion bash|zsh|fish"
+
+// subcommands are the words the wrapper handles itself in first position.
+var subcommands = []struct{ name, usage string }{
+	{"attach", "reattach to a background session"},
+	{"ls", "list background sessions"},
+	{"kill", "end a background session"},
+	{"replay", "play back a recording"},
+	{"audit", "verify an audit log"},
+	{"version", "print the wrapper's version"},
+	{"completion", "print a shell completion script"},
+}
+
+// compFlag is a wrapper flag as the completion scripts need it.
+type compFlag struct {
+	name, usage string
+	arg         string   // "", "file", "command" or "word"
+	words       []string // the accepted values when arg is "word"
+}
+
+// completionFlags describes the wrapper's flags, with what each one's value
+// completes to.
+func completionFlags(fs *pflag.FlagSet) []compFlag {
+	choices := map[string][]string{
+		"clipboard":         slices.Sorted(maps.Keys(clipboardModes)),
+		"downgrade-color":   {"16", "256"},
+		"graphics":          slices.Sorted(maps.Keys(graphicsModes)),
+		"title-mode":        slices.Sorted(maps.Keys(titleModes)),
+		"shell-integration": slices.Sorted(maps.Keys(shellIntegrationModes)),
+		"bell":              slices.Sorted(maps.Keys(bellModes)),
+		"stall-action":      slices.Sorted(maps.Keys(stallActions)),
+		"log-format":        slices.Sorted(maps.Keys(logFormats)),
+		"messages":          slices.Sorted(maps.Keys(messageModes)),
+		"webhook-format":    slices.Sorted(maps.Keys(webhookFormats)),
+		"artifacts":         {"project", "state"},
+		"tee-format":        slices.Sorted(maps.Keys(teeFormats)),
+		"charset":           slices.Sorted(maps.Keys(charsets)),
+		"child-charset":     slices.Sorted(maps.Keys(charsets)),
+		"enter":             slices.Sorted(maps.Keys(enterKeys)),
+	}
+	files := map[string]bool{"config": true, "record": true, "transcript": true, "trace": true, "log-file": true, "script": true, "record-encrypt": true, "audit": true, "log-output": true, "tee": true, "stderr-log": true, "archive-dir": true}
+	var flags []compFlag
+	fs.VisitAll(func(f *pflag.Flag) {
+		c := compFlag{name: f.Name, usage: f.Usage}
+		switch {
+		case f.Value.Type() == "bool" || f.NoOptDefVal != "":
+		case choices[f.Name] != nil:
+			c.arg, c.words = "word", choices[f.Name]
+		case f.Name == "claude":
+			c.arg = "command"
+		case files[f.Name]:
+			c.arg = "file"
+		default:
+			c.arg = "word"
+		}
+		flags = append(flags, c)
+	})
+	return flags
+}
+
+// runCompletion implements the completion subcommand. Words the wrapper
+// doesn't know are completed by claude's own completions, if the shell has
+// any loaded, and everything after "--" as a command of its own.
+func runCompletion(fs *pflag.FlagSet, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, completionUsage)
+		return 2
+	}
+	flags := completionFlags(fs)
+	switch args[0] {
+	case "bash":
+		bashCompletion(os.Stdout, flags)
+	case "zsh":
+		zshCompletion(os.Stdout, flags)
+	case "fish":
+		fishCompletion(os.Stdout, flags)
+	default:
+		fmt.Fprintln(os.Stderr, completionUsage)
+		return 2
+	}
+	return 0
+}
+
+func bashCompletion(w io.Writer, flags []compFlag) {
+	var names, cmds []string
+	for _, f := range flags {
+		names = append(names, "--"+f.name)
+	}
+	for _, c := range subcommands {
+		cmds = append(cmds, c.name)
+	}
+	fmt.Fprintf(w, `# bash completion for claude-unfocused
+_claude_unfocused() {
+    local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]} i
+    COMPREPLY=()
+    for ((i = 1; i < COMP_CWORD; i++)); do
+        if [[ ${COMP_WORDS[i]} == -- ]]; then
+            if ((i + 1 == COMP_CWORD)); then
+                COMPREPLY=($(compgen -c -- "$cur"))
+            else
+                COMPREPLY=($(compgen -f -- "$cur"))
+            fi
+            return
+        fi
+    done
+    case $prev in
+`)
+	for _, f := range flags {
+		switch f.arg {
+		case "file":
+			fmt.Fprintf(w, "        --%s) COMPREPLY=($(compgen -f -- \"$cur\")); return ;;\n", f.name)
+		case "command":
+			fmt.Fprintf(w, "        --%s) COMPREPLY=($(compgen -c -- \"$cur\")); return ;;\n", f.name)
+		case "word":
+			fmt.Fprintf(w, "        --%s) COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n", f.name, strings.Join(f.words, " "))
+		}
+	}
+	fmt.Fprintf(w, `    esac
+    if ((COMP_CWORD == 1)) && [[ $cur != -* ]]; then
+        COMPREPLY=($(compgen -W %q -- "$cur"))
+    fi
+    local spec
+    if spec=$(complete -p claude 2>/dev/null) && [[ $spec =~ -F\ ([^ ]+) ]]; then
+        local words=("${COMP_WORDS[@]}")
+        COMP_WORDS[0]=claude
+        "${BASH_REMATCH[1]}" claude "$cur" "$prev"
+        COMP_WORDS=("${words[@]}")
+    elif [[ $cur != -* ]] && ((${#COMPREPLY[@]} == 0)); then
+        COMPREPLY=($(compgen -f -- "$cur"))
+    fi
+    if [[ $cur == -* ]]; then
+        COMPREPLY+=($(compgen -W %q -- "$cur"))
+    fi
+}
+complete -F _claude_unfocused claude-unfocused
+`, strings.Join(cmds, " "), strings.Join(names, " "))
+}
+
+func zshCompletion(w io.Writer, flags []compFlag) {
+	fmt.Fprint(w, `#compdef claude-unfocused
+_claude_unfocused() {
+    local i
+    for ((i = 2; i < CURRENT; i++)); do
+        if [[ $words[i] == -- ]]; then
+            words=("${(@)words[i+1,-1]}")
+            ((CURRENT -= i))
+            _normal
+            return
+        fi
+    done
+    case $words[CURRENT-1] in
+`)
+	for _, f := range flags {
+		switch f.arg {
+		case "file":
+			fmt.Fprintf(w, "        --%s) _files; return ;;\n", f.name)
+		case "command":
+			fmt.Fprintf(w, "        --%s) _command_names -e; return ;;\n", f.name)
+		case "word":
+			if len(f.words) > 0 {
+				fmt.Fprintf(w, "        --%s) compadd -- %s; return ;;\n", f.name, strings.Join(f.words, " "))
+			} else {
+				fmt.Fprintf(w, "        --%s) return ;;\n", f.name)
+			}
+		}
+	}
+	fmt.Fprint(w, `    esac
+    local ret=1
+    if [[ $words[CURRENT] == -* ]]; then
+        local -a opts=(
+`)
+	for _, f := range flags {
+		fmt.Fprintf(w, "            %s\n", zshQuote("--"+f.name+":"+f.usage))
+	}
+	fmt.Fprint(w, `        )
+        _describe -t options 'claude-unfocused option' opts && ret=0
+    elif ((CURRENT == 2)); then
+        local -a cmds=(
+`)
+	for _, c := range subcommands {
+		fmt.Fprintf(w, "            %s\n", zshQuote(c.name+":"+c.usage))
+	}
+	fmt.Fprint(w, `        )
+        _describe -t commands 'claude-unfocused command' cmds && ret=0
+    fi
+    if (($+functions[_claude])); then
+        words[1]=claude
+        _claude && ret=0
+    elif ((ret)); then
+        _files && ret=0
+    fi
+    return ret
+}
+if [[ $zsh_eval_context[-1] == loadautofunc ]]; then
+    _claude_unfocused "$@"
+else
+    compdef _claude_unfocused claude-unfocused
+fi
+`)
+}
+
+func fishCompletion(w io.Writer, flags []compFlag) {
+	fmt.Fprint(w, `# fish completion for claude-unfocused
+complete -c claude-unfocused -w claude
+`)
+	for _, c := range subcommands {
+		fmt.Fprintf(w, "complete -c claude-unfocused -n __fish_use_subcommand -f -a %s -d %s\n", c.name, fishQuote(c.usage))
+	}
+	for _, f := range flags {
+		line := "complete -c claude-unfocused -l " + f.name
+		switch f.arg {
+		case "file":
+			line += " -r -F"
+		case "command":
+			line += " -x -a '(__fish_complete_command)'"
+		case "word":
+			line += " -x"
+			if len(f.words) > 0 {
+				line += " -a " + fishQuote(strings.Join(f.words, " "))
+			}
+		}
+		fmt.Fprintf(w, "%s -d %s\n", line, fishQuote(f.usage))
+	}
+}
+
+// zshQuote and fishQuote quote s as a single-quoted string for their shell.
+func zshQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
@@ -0,0 +1,710 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/BurntSushi/toml"
+	"github.com/samuelstevens/claude-unfocused/pkg/escfilter"
+	"github.com/samuelstevens/claude-unfocused/pkg/ptyproxy"
+)
+
+// config holds the wrapper's settings. Values come from defaults, then the
+// config file, then command-line flags.
+type config struct {
+	Claude           string            `toml:"claude"`
+	EscTimeout       time.Duration     `toml:"esc_timeout"`
+	QuitTimeout      time.Duration     `toml:"quit_timeout"`
+	SessionLimit     time.Duration     `toml:"max_session_time"`
+	SessionWarning   time.Duration     `toml:"session_time_warning"`
+	ResizePoll       time.Duration     `toml:"resize_poll"`
+	Args             []string          `toml:"args"`
+	Control          bool              `toml:"control"`
+	CacheReplies     bool              `toml:"cache_replies"`
+	MetricsAddr      string            `toml:"metrics_addr"`
+	Script           string            `toml:"script"`
+	Artifacts        string            `toml:"artifacts"`
+	StatusLine       bool              `toml:"status_line"`
+	PromptMarks      bool              `toml:"prompt_marks"`
+	Scrollback       int               `toml:"scrollback"`
+	WheelScroll      bool              `toml:"wheel_scroll"`
+	InterceptSuspend bool              `toml:"intercept_suspend"`
+	Passthrough      bool              `toml:"passthrough"`
+	Clipboard        string            `toml:"clipboard"`
+	Graphics         string            `toml:"graphics"`
+	Downgrade        bool              `toml:"downgrade"`
+	Color            string            `toml:"color"`
+	Term             string            `toml:"term"`
+	Colorterm        string            `toml:"colorterm"`
+	StripColor       bool              `toml:"strip_color"`
+	DowngradeColor   string            `toml:"downgrade_color"`
+	Filter           filterConfig      `toml:"filter"`
+	Keys             keysConfig        `toml:"keys"`
+	Keymap           map[string]string `toml:"keymap"`
+	Snippets         map[string]string `toml:"snippets"`
+	Notify           notifyConfig      `toml:"notify"`
+	Permission       permissionConfig  `toml:"permission"`
+	Expect           []expectRule      `toml:"expect"`
+	ExpectDryRun     bool              `toml:"expect_dry_run"`
+	Paste            pasteConfig       `toml:"paste"`
+	Buffers          bufferConfig      `toml:"buffers"`
+	Pipes            pipeConfig        `toml:"pipes"`
+	Redact           redactConfig      `toml:"redact"`
+	Hooks            hookConfig        `toml:"hooks"`
+	Webhook          webhookConfig     `toml:"webhook"`
+	Stall            stallConfig       `toml:"stall"`
+	Log              logConfig         `toml:"log"`
+	Bell             bellConfig        `toml:"bell"`
+	Title            titleConfig       `toml:"title"`
+	Signals          signalConfig      `toml:"signals"`
+	Charset          charsetConfig     `toml:"charset"`
+	Newline          newlineConfig     `toml:"newline"`
+	Usage            usageConfig       `toml:"usage"`
+	Archive          archiveConfig     `toml:"archive"`
+	Env              map[string]string `toml:"env"`
+	UnsetEnv         []string          `toml:"unset_env"`
+	Profiles         profileSet        `toml:"profiles"`
+}
+
+// profileSet holds the named profiles under [profiles], each any of the
+// config's settings, which --profile lays over the rest of the file.
+type profileSet map[string]toml.Primitive
+
+// filterConfig controls the input filter, and what of the child's output it
+// keeps from the terminal. ShellIntegration is one of the
+// shellIntegrationModes.
+type filterConfig struct {
+	Focus            bool   `toml:"focus"`
+	Mouse            bool   `toml:"mouse"`
+	ForceFocused     bool   `toml:"force_focused"`
+	StripFocusMode   bool   `toml:"strip_focus_mode"`
+	ShellIntegration string `toml:"shell_integration"`
+}
+
+// keysConfig holds the wrapper's key chords, in escfilter.ParseKeys
+// notation, where the word prefix stands for the Prefix key. An empty chord
+// disables the binding, as does an empty Prefix those using it; otherwise
+// the prefix typed twice sends it to the child.
+type keysConfig struct {
+	Prefix       string `toml:"prefix"`
+	ToggleFilter string `toml:"toggle_filter"`
+	ToggleStatus string `toml:"toggle_status"`
+	NewSession   string `toml:"new_session"`
+	NextSession  string `toml:"next_session"`
+	PrevSession  string `toml:"prev_session"`
+	Search       string `toml:"search"`
+	CopyMode     string `toml:"copy_mode"`
+	Suspend      string `toml:"suspend"`
+	Detach       string `toml:"detach"`
+	DumpScreen   string `toml:"dump_screen"`
+	Palette      string `toml:"palette"`
+	Help         string `toml:"help"`
+	// Quit and SuspendKey are the keys that quit and suspend at once,
+	// Ctrl-\ and Ctrl-Z by default
+	Quit       string `toml:"quit"`
+	SuspendKey string `toml:"suspend_key"`
+}
+
+// notifyConfig controls desktop notifications. A zero duration disables
+// the notification.
+type notifyConfig struct {
+	Idle time.Duration `toml:"idle"`
+}
+
+// permissionConfig alerts when the child asks for permission to use a
+// tool: with a desktop notification if Notify is set, and a bell to the
+// terminal if Bell is. Patterns are the regular expressions a prompt's line
+// matches, defaultPermissionPatterns if empty.
+type permissionConfig struct {
+	Notify   bool     `toml:"notify"`
+	Bell     bool     `toml:"bell"`
+	Patterns []string `toml:"patterns"`
+}
+
+// pasteConfig paces large input: with a positive Chunk, pastes reach the
+// child Chunk bytes at a time, Delay apart. With a positive Wrap, a read of
+// at least that many bytes is taken for an unbracketed paste.
+type pasteConfig struct {
+	Chunk int           `toml:"chunk"`
+	Delay time.Duration `toml:"delay"`
+	Wrap  int           `toml:"wrap"`
+}
+
+// bufferConfig sizes, in bytes, the reads of terminal input and of the
+// child's output.
+type bufferConfig struct {
+	Input  int `toml:"input"`
+	Output int `toml:"output"`
+	// Coalesce is how long output may wait to be written with what comes
+	// after it; zero writes it as it is read
+	Coalesce time.Duration `toml:"coalesce"`
+}
+
+// pipeConfig lists external filters, shell commands the child's input and
+// output pass through in order on their way.
+type pipeConfig struct {
+	Input  []string `toml:"input"`
+	Output []string `toml:"output"`
+}
+
+// redactConfig masks secrets in the recording, transcript and trace when
+// Enabled: those the built-in secretPatterns match, and those Patterns,
+// regular expressions, do.
+type redactConfig struct {
+	Enabled  bool     `toml:"enabled"`
+	Patterns []string `toml:"patterns"`
+}
+
+// hookConfig holds shell commands run at points in a session's life; see
+// lifecycle. An empty command is skipped.
+type hookConfig struct {
+	PreStart string `toml:"pre_start"`
+	PostExit string `toml:"post_exit"`
+	OnAttach string `toml:"on_attach"`
+	OnDetach string `toml:"on_detach"`
+}
+
+// webhookConfig sends the Events named, of the webhookEvents, to URL in
+// Format, one of the webhookFormats. The idle event is sent once the child
+// has been quiet for Idle after output. An empty URL disables it.
+type webhookConfig struct {
+	URL    string        `toml:"url"`
+	Format string        `toml:"format"`
+	Events []string      `toml:"events"`
+	Idle   time.Duration `toml:"idle"`
+}
+
+// stallConfig configures the watchdog for a hung child: Action, one of the
+// stallActions, is taken after Timeout without output or input. A zero
+// Timeout disables it.
+type stallConfig struct {
+	Timeout time.Duration `toml:"timeout"`
+	Action  string        `toml:"action"`
+}
+
+// logConfig controls the wrapper's log file. When Enabled, warnings go to
+// File as well as stderr, or to a file per session under the state
+// directory if File is empty. The file is rotated once it passes MaxSize
+// megabytes, keeping MaxFiles old ones, and logs older than MaxAge are
+// removed at startup. Zero disables each limit. Format is one of the
+// logFormats, and Messages one of the messageModes.
+type logConfig struct {
+	Enabled  bool          `toml:"enabled"`
+	Format   string        `toml:"format"`
+	Messages string        `toml:"messages"`
+	File     string        `toml:"file"`
+	MaxSize  int           `toml:"max_size"`
+	MaxFiles int           `toml:"max_files"`
+	MaxAge   time.Duration `toml:"max_age"`
+}
+
+// bellConfig controls what a bell from the child does. Mode is one of the
+// bellModes; Command, if set, is run through the shell on each bell.
+type bellConfig struct {
+	Mode    string `toml:"mode"`
+	Command string `toml:"command"`
+}
+
+// titleConfig controls the window title. Mode is one of the titleModes;
+// Template is the title the wrapper keeps in rewrite mode.
+type titleConfig struct {
+	Mode     string `toml:"mode"`
+	Template string `toml:"template"`
+}
+
+// charsetConfig names the character sets, among the charsets, of the
+// terminal and of the child, which the wrapper converts between when they
+// differ; Replacement, in ASCII, stands in for characters one lacks.
+type charsetConfig struct {
+	Terminal    string `toml:"terminal"`
+	Child       string `toml:"child"`
+	Replacement string `toml:"replacement"`
+}
+
+// newlineConfig adapts line ends for a transport the terminal's line
+// discipline isn't in play on: Output, one of the newlineModes, is how the
+// child's reach the terminal, and Enter, one of the enterKeys, what the
+// Enter key sends the child.
+type newlineConfig struct {
+	Output string `toml:"output"`
+	Enter  string `toml:"enter"`
+}
+
+// usageConfig controls usage tracking: when Enabled, the tokens claude
+// uses and their cost are shown on the status line and summed up on exit.
+// Prices, by the start of model names, add to the modelPrices.
+type usageConfig struct {
+	Enabled bool             `toml:"enabled"`
+	Prices  map[string]price `toml:"prices"`
+}
+
+// archiveConfig controls the transcript archive: when Enabled, each
+// session's transcript is kept under Dir, or the state directory's archive/
+// if Dir is empty.
+type archiveConfig struct {
+	Enabled bool   `toml:"enabled"`
+	Dir     string `toml:"dir"`
+}
+
+// signalConfig says what becomes of the signals the wrapper receives, named
+// as kill(1) names them: those in Forward are passed on to the child, as the
+// signal Translate maps them to if it has them, those in Ignore are dropped,
+// and those in Actions run the one of the signalActionNames they map to
+// instead. Others have their usual effect on the wrapper.
+type signalConfig struct {
+	Forward   []string          `toml:"forward"`
+	Translate map[string]string `toml:"translate"`
+	Ignore    []string          `toml:"ignore"`
+	Actions   map[string]string `toml:"actions"`
+}
+
+// signalActionNames are the wrapper actions a signal can run.
+var signalActionNames = map[string]escfilter.Action{
+	"dump-screen":   actionDumpScreen,
+	"rotate-logs":   actionRotateLogs,
+	"toggle-filter": actionToggleFilter,
+	"toggle-status": actionToggleStatus,
+}
+
+func defaultConfig() config {
+	return config{
+		Claude:           "claude",
+		EscTimeout:       escTimeout,
+		QuitTimeout:      quitTimeout,
+		SessionWarning:   sessionTimeWarning,
+		Clipboard:        "allow",
+		Graphics:         "allow",
+		Color:            "auto",
+		InterceptSuspend: true,
+		Filter: filterConfig{
+			Focus:            true,
+			ShellIntegration: "pass",
+		},
+		Keys: keysConfig{
+			Prefix:       "ctrl-]",
+			ToggleFilter: "prefix f",
+			ToggleStatus: "prefix s",
+			NextSession:  "prefix n",
+			PrevSession:  "prefix p",
+			Search:       "prefix /",
+			CopyMode:     "prefix [",
+			Suspend:      "prefix z",
+			Detach:       "prefix d",
+			DumpScreen:   "prefix h",
+			Palette:      "prefix :",
+			Help:         "prefix ?",
+			Quit:         "ctrl-\\",
+			SuspendKey:   "ctrl-z",
+		},
+		Paste: pasteConfig{
+			Delay: pasteDelay,
+		},
+		Buffers: bufferConfig{
+			Input:    inputBuffer,
+			Output:   outputBuffer,
+			Coalesce: coalesceDelay,
+		},
+		Webhook: webhookConfig{
+			Format: "generic",
+			Events: webhookEvents,
+			Idle:   time.Minute,
+		},
+		Stall: stallConfig{
+			Action: "notify",
+		},
+		Charset: charsetConfig{
+			Terminal:    "utf-8",
+			Child:       "utf-8",
+			Replacement: "?",
+		},
+		Newline: newlineConfig{
+			Enter: "cr",
+		},
+		Archive: archiveConfig{
+			Enabled: true,
+		},
+		Log: logConfig{
+			Format:   "text",
+			Messages: "terminal",
+			MaxSize:  10,
+			MaxFiles: 5,
+			MaxAge:   14 * 24 * time.Hour,
+		},
+		Bell: bellConfig{
+			Mode: "pass",
+		},
+		Title: titleConfig{
+			Mode:     "pass",
+			Template: "{status} - {dir}",
+		},
+		Signals: signalConfig{
+			Forward: forwardedSignals,
+		},
+	}
+}
+
+// configPaths returns candidate config file locations in lookup order,
+// following the XDG base directory spec.
+func configPaths() []string {
+	const name = "claude-unfocused/config.toml"
+	var paths []string
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		paths = append(paths, filepath.Join(dir, name))
+	} else if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".config", name))
+	}
+	dirs := os.Getenv("XDG_CONFIG_DIRS")
+	if dirs == "" {
+		dirs = "/etc/xdg"
+	}
+	for _, dir := range strings.Split(dirs, ":") {
+		if dir != "" {
+			paths = append(paths, filepath.Join(dir, name))
+		}
+	}
+	return paths
+}
+
+// findConfig returns the first existing config file, or "" if there is none.
+func findConfig() string {
+	for _, path := range configPaths() {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// loadConfig reads the config file at path over the defaults, and then the
+// profile of that name over the rest unless it is empty. An empty path
+// yields the defaults.
+func loadConfig(path, profile string) (config, error) {
+	cfg := defaultConfig()
+	if path == "" {
+		if profile != "" {
+			return cfg, fmt.Errorf("no config file to find profile %q in", profile)
+		}
+		return cfg, nil
+	}
+	md, err := toml.DecodeFile(path, &cfg)
+	if err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	// Every profile is checked, to catch mistakes in those not in use too
+	var chosen config
+	for name, prim := range cfg.Profiles {
+		p := defaultConfig()
+		if err := md.PrimitiveDecode(prim, &p); err != nil {
+			return cfg, fmt.Errorf("%s: profile %q: %w", path, name, err)
+		}
+		if len(p.Profiles) > 0 {
+			return cfg, fmt.Errorf("%s: profile %q: profiles can't be nested", path, name)
+		}
+		if name == profile {
+			chosen = p
+		}
+	}
+	if profile != "" {
+		prim, ok := cfg.Profiles[profile]
+		if !ok {
+			return cfg, fmt.Errorf("%s: no profile %q", path, profile)
+		}
+		_ = md.PrimitiveDecode(prim, &cfg)
+		// The profile's unset_env wins over variables the rest of the file
+		// sets
+		for _, key := range chosen.UnsetEnv {
+			delete(cfg.Env, key)
+		}
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return cfg, fmt.Errorf("%s: unknown key %q", path, undecoded[0].String())
+	}
+	if cfg.EscTimeout < 0 {
+		return cfg, errors.New(path + ": esc_timeout must not be negative")
+	}
+	if cfg.QuitTimeout < 0 {
+		return cfg, errors.New(path + ": quit_timeout must not be negative")
+	}
+	if cfg.SessionLimit < 0 {
+		return cfg, errors.New(path + ": max_session_time must not be negative")
+	}
+	if cfg.SessionWarning < 0 {
+		return cfg, errors.New(path + ": session_time_warning must not be negative")
+	}
+	if cfg.ResizePoll < 0 {
+		return cfg, errors.New(path + ": resize_poll must not be negative")
+	}
+	if cfg.Scrollback < 0 {
+		return cfg, errors.New(path + ": scrollback must not be negative")
+	}
+	if cfg.Notify.Idle < 0 {
+		return cfg, errors.New(path + ": notify.idle must not be negative")
+	}
+	if cfg.Paste.Chunk < 0 || cfg.Paste.Delay < 0 || cfg.Paste.Wrap < 0 {
+		return cfg, errors.New(path + ": paste settings must not be negative")
+	}
+	if cfg.Buffers.Input <= 0 || cfg.Buffers.Output <= 0 {
+		return cfg, errors.New(path + ": buffer sizes must be positive")
+	}
+	if cfg.Buffers.Coalesce < 0 {
+		return cfg, errors.New(path + ": buffers.coalesce must not be negative")
+	}
+	if cfg.Stall.Timeout < 0 {
+		return cfg, errors.New(path + ": stall.timeout must not be negative")
+	}
+	if !stallActions[cfg.Stall.Action] {
+		return cfg, fmt.Errorf("%s: unknown stall.action %q", path, cfg.Stall.Action)
+	}
+	if _, err := permissionPatterns(cfg.Permission.Patterns); err != nil {
+		return cfg, fmt.Errorf("%s: permission.patterns: %w", path, err)
+	}
+	if _, err := newRedactor(cfg.Redact.Patterns); err != nil {
+		return cfg, fmt.Errorf("%s: redact.patterns: %w", path, err)
+	}
+	if _, err := compileExpect(cfg.Expect); err != nil {
+		return cfg, fmt.Errorf("%s: expect: %w", path, err)
+	}
+	if !webhookFormats[cfg.Webhook.Format] {
+		return cfg, fmt.Errorf("%s: unknown webhook.format %q", path, cfg.Webhook.Format)
+	}
+	for _, event := range cfg.Webhook.Events {
+		if !slices.Contains(webhookEvents, event) {
+			return cfg, fmt.Errorf("%s: unknown webhook event %q", path, event)
+		}
+	}
+	if cfg.Webhook.Idle < 0 {
+		return cfg, errors.New(path + ": webhook.idle must not be negative")
+	}
+	if cfg.Log.MaxSize < 0 || cfg.Log.MaxFiles < 0 || cfg.Log.MaxAge < 0 {
+		return cfg, errors.New(path + ": log settings must not be negative")
+	}
+	if !logFormats[cfg.Log.Format] {
+		return cfg, fmt.Errorf("%s: unknown log.format %q", path, cfg.Log.Format)
+	}
+	if !messageModes[cfg.Log.Messages] {
+		return cfg, fmt.Errorf("%s: unknown log.messages %q", path, cfg.Log.Messages)
+	}
+	if !artifactModes[cfg.Artifacts] {
+		return cfg, fmt.Errorf("%s: unknown artifacts mode %q", path, cfg.Artifacts)
+	}
+	if !clipboardModes[cfg.Clipboard] {
+		return cfg, fmt.Errorf("%s: unknown clipboard mode %q", path, cfg.Clipboard)
+	}
+	if _, ok := bellModes[cfg.Bell.Mode]; !ok {
+		return cfg, fmt.Errorf("%s: unknown bell.mode %q", path, cfg.Bell.Mode)
+	}
+	for _, name := range []*string{&cfg.Charset.Terminal, &cfg.Charset.Child} {
+		canonical := charsetName(*name)
+		if canonical == "" {
+			return cfg, fmt.Errorf("%s: unknown charset %q", path, *name)
+		}
+		*name = canonical
+	}
+	if strings.ContainsFunc(cfg.Charset.Replacement, func(r rune) bool { return r >= utf8.RuneSelf }) {
+		return cfg, errors.New(path + ": charset.replacement must be ASCII")
+	}
+	for key := range cfg.Env {
+		if key == "" || strings.Contains(key, "=") {
+			return cfg, fmt.Errorf("%s: bad env variable name %q", path, key)
+		}
+	}
+	if !newlineModes[cfg.Newline.Output] {
+		return cfg, fmt.Errorf("%s: unknown newline.output %q", path, cfg.Newline.Output)
+	}
+	if _, ok := enterKeys[cfg.Newline.Enter]; !ok {
+		return cfg, fmt.Errorf("%s: unknown newline.enter %q", path, cfg.Newline.Enter)
+	}
+	if !colorModes[cfg.Color] {
+		return cfg, fmt.Errorf("%s: unknown color mode %q", path, cfg.Color)
+	}
+	if cfg.DowngradeColor != "" && !colorDepths[cfg.DowngradeColor] {
+		return cfg, fmt.Errorf("%s: downgrade_color must be 256 or 16, not %q", path, cfg.DowngradeColor)
+	}
+	if !graphicsModes[cfg.Graphics] {
+		return cfg, fmt.Errorf("%s: unknown graphics mode %q", path, cfg.Graphics)
+	}
+	if !shellIntegrationModes[cfg.Filter.ShellIntegration] {
+		return cfg, fmt.Errorf("%s: unknown filter.shell_integration %q", path, cfg.Filter.ShellIntegration)
+	}
+	if !titleModes[cfg.Title.Mode] {
+		return cfg, fmt.Errorf("%s: unknown title.mode %q", path, cfg.Title.Mode)
+	}
+	if _, err := cfg.hotkeys(); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	if _, err := cfg.signalMap(); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	if _, err := cfg.signalActions(); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// hotkeys returns the key bindings the input filter recognizes.
+func (cfg config) hotkeys() ([]escfilter.Hotkey, error) {
+	var hotkeys []escfilter.Hotkey
+	suspendKey := cfg.Keys.SuspendKey
+	if !cfg.InterceptSuspend {
+		suspendKey = ""
+	}
+	for _, b := range []struct {
+		chord  string
+		action escfilter.Action
+	}{
+		{cfg.Keys.Quit, actionQuit},
+		{suspendKey, actionSuspend},
+		{cfg.Keys.ToggleFilter, actionToggleFilter},
+		{cfg.Keys.ToggleStatus, actionToggleStatus},
+		{cfg.Keys.NewSession, actionNewSession},
+		{cfg.Keys.NextSession, actionNextSession},
+		{cfg.Keys.PrevSession, actionPrevSession},
+		{cfg.Keys.Search, actionSearch},
+		{cfg.Keys.CopyMode, actionCopyMode},
+		{cfg.Keys.Suspend, actionSuspend},
+		{cfg.Keys.Detach, actionDetach},
+		{cfg.Keys.DumpScreen, actionDumpScreen},
+		{cfg.Keys.Palette, actionPalette},
+		{cfg.Keys.Help, actionHelp},
+	} {
+		keys, err := escfilter.ParseKeys(cfg.Keys.expand(b.chord))
+		if err == nil {
+			err = escfilter.ValidKeys(keys)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(keys) > 0 {
+			hotkeys = append(hotkeys, escfilter.Hotkey{Keys: keys, Action: b.action})
+		}
+	}
+
+	// Remapped chords: an empty replacement swallows the chord
+	for _, chord := range slices.Sorted(maps.Keys(cfg.Keymap)) {
+		send, err := escfilter.ParseKeys(cfg.Keymap[chord])
+		if err != nil {
+			return nil, fmt.Errorf("keymap: %w", err)
+		}
+		if hotkeys, err = bindText(hotkeys, cfg.Keys.expand(chord), send); err != nil {
+			return nil, fmt.Errorf("keymap: %w", err)
+		}
+	}
+	// Snippets type their text as it is
+	for _, chord := range slices.Sorted(maps.Keys(cfg.Snippets)) {
+		var err error
+		if hotkeys, err = bindText(hotkeys, cfg.Keys.expand(chord), []byte(cfg.Snippets[chord])); err != nil {
+			return nil, fmt.Errorf("snippets: %w", err)
+		}
+	}
+	// The prefix twice types it, unless that is bound to something else
+	prefix, err := escfilter.ParseKeys(cfg.Keys.Prefix)
+	if err != nil {
+		return nil, fmt.Errorf("keys.prefix: %w", err)
+	}
+	if len(prefix) > 0 {
+		hotkeys, _ = bindText(hotkeys, cfg.Keys.expand("prefix prefix"), prefix)
+	}
+	return hotkeys, nil
+}
+
+// expand returns chord with the prefix key in place of the word prefix, or
+// "" if it uses the prefix and there is none.
+func (k keysConfig) expand(chord string) string {
+	keys := strings.Fields(chord)
+	for i, key := range keys {
+		if key != "prefix" {
+			continue
+		}
+		if k.Prefix == "" {
+			return ""
+		}
+		keys[i] = k.Prefix
+	}
+	return strings.Join(keys, " ")
+}
+
+// bound reports whether chord binds any keys.
+func (k keysConfig) bound(chord string) bool {
+	return k.expand(chord) != ""
+}
+
+// signalMap returns what becomes of the signals the wrapper receives.
+func (cfg config) signalMap() (ptyproxy.SignalMap, error) {
+	m := ptyproxy.SignalMap{}
+	for _, name := range cfg.Signals.Forward {
+		sig, err := parseSignal(name)
+		if err != nil {
+			return nil, fmt.Errorf("signals.forward: %w", err)
+		}
+		m[sig] = sig
+	}
+	for _, from := range slices.Sorted(maps.Keys(cfg.Signals.Translate)) {
+		sig, err := parseSignal(from)
+		if err != nil {
+			return nil, fmt.Errorf("signals.translate: %w", err)
+		}
+		to, err := parseSignal(cfg.Signals.Translate[from])
+		if err != nil {
+			return nil, fmt.Errorf("signals.translate: %w", err)
+		}
+		if _, ok := m[sig]; ok {
+			m[sig] = to
+		}
+	}
+	for _, name := range cfg.Signals.Ignore {
+		sig, err := parseSignal(name)
+		if err != nil {
+			return nil, fmt.Errorf("signals.ignore: %w", err)
+		}
+		m[sig] = nil
+	}
+	for name := range cfg.Signals.Actions {
+		if sig, err := parseSignal(name); err == nil {
+			m[sig] = nil // the action has it instead
+		}
+	}
+	return m, nil
+}
+
+// signalActions returns the wrapper actions signals run.
+func (cfg config) signalActions() (map[os.Signal]escfilter.Action, error) {
+	actions := map[os.Signal]escfilter.Action{}
+	for _, name := range slices.Sorted(maps.Keys(cfg.Signals.Actions)) {
+		sig, err := parseSignal(name)
+		if err != nil {
+			return nil, fmt.Errorf("signals.actions: %w", err)
+		}
+		action, ok := signalActionNames[cfg.Signals.Actions[name]]
+		if !ok {
+			return nil, fmt.Errorf("signals.actions: unknown action %q", cfg.Signals.Actions[name])
+		}
+		actions[sig] = action
+	}
+	return actions, nil
+}
+
+// bindText adds a hotkey typing send for chord, refusing chords that are
+// already bound.
+func bindText(hotkeys []escfilter.Hotkey, chord string, send []byte) ([]escfilter.Hotkey, error) {
+	keys, err := escfilter.ParseKeys(chord)
+	if err == nil {
+		err = escfilter.ValidKeys(keys)
+	}
+	if err != nil || len(keys) == 0 {
+		return hotkeys, err
+	}
+	for _, hk := range hotkeys {
+		if bytes.Equal(hk.Keys, keys) {
+			return hotkeys, fmt.Errorf("%q is already bound", chord)
+		}
+	}
+	return append(hotkeys, escfilter.Hotkey{Keys: keys, Send: append([]byte{}, send...)}), nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/samuelstevens/claude-unfocused/internal/vt"
+	"github.com/samuelstevens/claude-unfocused/pkg/escfilter"
+	"github.com/samuelstevens/claude-unfocused/pkg/ptyproxy"
+)
+
+// controlServer answers line-based commands on a per-session Unix socket,
+// letting scripts drive the wrapped session. Each request line gets one
+// response line, "ok" with optional data or "error" with a message:
+//
+//	send-keys <text>               type text into the child ("..." for Go escapes)
+//	resize <cols> <rows>           resize the child's terminal
+//	signal <name|number>           send a signal to the child
+//	status                         report the session as JSON
+//	screen                         report what is on screen as JSON
+//	toggle-filter [name] [on|off]  flip or set the focus (default) or mouse filter
+type controlServer struct {
+	ln      net.Listener
+	ptmx    ptyproxy.Session
+	filter  *escfilter.Filter
+	screen  *vt.Screen
+	argv    []string
+	started time.Time
+}
+
+// controlSocket returns the control socket path for the wrapper with the
+// given pid.
+func controlSocket(pid int) (string, error) {
+	dir, err := runtimeDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "control")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, strconv.Itoa(pid)+".sock"), nil
+}
+
+// startControl listens on this wrapper's control socket.
+func startControl(ptmx ptyproxy.Session, filter *escfilter.Filter, screen *vt.Screen, argv []string) (*controlServer, error) {
+	sock, err := controlSocket(os.Getpid())
+	if err != nil {
+		return nil, err
+	}
+	_ = os.Remove(sock) // left over from a previous process with our pid
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		return nil, err
+	}
+	c := &controlServer{ln: ln, ptmx: ptmx, filter: filter, screen: screen, argv: argv, started: time.Now()}
+	go c.accept()
+	return c, nil
+}
+
+func (c *controlServer) accept() {
+	for {
+		conn, err := c.ln.Accept()
+		if err != nil {
+			return
+		}
+		go c.handle(conn)
+	}
+}
+
+func (c *controlServer) handle(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(nil, maxFrame)
+	for scanner.Scan() {
+		reply, err := c.command(strings.TrimSuffix(scanner.Text(), "\r"))
+		if err != nil {
+			reply = "error " + err.Error()
+		} else if reply == "" {
+			reply = "ok"
+		} else {
+			reply = "ok " + reply
+		}
+		if _, err := fmt.Fprintln(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// command runs one request line and returns the data for an ok response.
+func (c *controlServer) command(line string) (string, error) {
+	name, rest, _ := strings.Cut(line, " ")
+	args := strings.Fields(rest)
+	switch name {
+	case "send-keys":
+		keys := rest
+		if strings.HasPrefix(keys, `"`) {
+			var err error
+			if keys, err = strconv.Unquote(keys); err != nil {
+				return "", fmt.Errorf("bad quoted string: %w", err)
+			}
+		}
+		_, err := c.ptmx.Write([]byte(keys))
+		return "", err
+
+	case "resize":
+		if len(args) != 2 {
+			return "", errors.New("usage: resize <cols> <rows>")
+		}
+		cols, err1 := strconv.Atoi(args[0])
+		rows, err2 := strconv.Atoi(args[1])
+		if err1 != nil || err2 != nil || cols <= 0 || rows <= 0 {
+			return "", errors.New("usage: resize <cols> <rows>")
+		}
+		return "", c.ptmx.Resize(cols, rows)
+
+	case "signal":
+		if len(args) != 1 {
+			return "", errors.New("usage: signal <name|number>")
+		}
+		sig, err := parseSignal(args[0])
+		if err != nil {
+			return "", err
+		}
+		return "", c.ptmx.Signal(sig)
+
+	case "status":
+		status, err := json.Marshal(map[string]any{
+			"pid":       os.Getpid(),
+			"child_pid": c.ptmx.Pid(),
+			"command":   c.argv,
+			"started":   c.started.Format(time.RFC3339),
+			"filter": map[string]bool{
+				"focus": c.filter.Focus(),
+				"mouse": c.filter.Mouse(),
+			},
+			"focus_reporting": c.filter.FocusReporting(),
+		})
+		return string(status), err
+
+	case "screen":
+		cols, rows := c.screen.Size()
+		x, y := c.screen.Cursor()
+		screen, err := json.Marshal(map[string]any{
+			"cols":       cols,
+			"rows":       rows,
+			"cursor":     []int{x, y},
+			"alt_screen": c.screen.AltScreen(),
+			"lines":      c.screen.Lines(),
+		})
+		return string(screen), err
+
+	case "toggle-filter":
+		return c.toggleFilter(args)
+	}
+	return "", fmt.Errorf("unknown command %q", name)
+}
+
+func (c *controlServer) toggleFilter(args []string) (string, error) {
+	name := "focus"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	var get func() bool
+	var set func(bool)
+	switch name {
+	case "focus":
+		get, set = c.filter.Focus, c.filter.SetFocus
+	case "mouse":
+		get, set = c.filter.Mouse, c.filter.SetMouse
+	default:
+		return "", fmt.Errorf("unknown filter %q", name)
+	}
+	on := !get()
+	if len(args) > 0 {
+		switch args[0] {
+		case "on":
+			on = true
+		case "off":
+			on = false
+		default:
+			return "", errors.New("usage: toggle-filter [focus|mouse] [on|off]")
+		}
+	}
+	set(on)
+	return fmt.Sprintf("%s=%s", name, onOff(on)), nil
+}
+
+// Close stops accepting commands and removes the socket.
+func (c *controlServer) Close() error {
+	return c.ln.Close()
+}
+
+// parseSignal accepts a signal name with or without the SIG prefix, or a
+// signal number.
+func parseSignal(s string) (os.Signal, error) {
+	if n, err := strconv.Atoi(s); err == nil && n > 0 {
+		return syscall.Signal(n), nil
+	}
+	if sig, ok := signalNames[strings.TrimPrefix(strings.ToUpper(s), "SIG")]; ok {
+		return sig, nil
+	}
+	return nil, fmt.Errorf("unknown signal %q", s)
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
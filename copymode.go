@@ -0,0 +1,199 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Copy mode moves a cursor over the scrollView's lines, vi-style, to select
+// text and copy it to the clipboard, like tmux's copy mode.
+
+// copy opens the view in copy mode, with the cursor where the child's is.
+func (v *scrollView) copy() {
+	v.start(viewCopy)
+}
+
+// copyKey handles a key in copy mode, reporting whether it was one; the
+// others are handled as when paging. The caller holds mu.
+func (v *scrollView) copyKey(key string) bool {
+	v.message = ""
+	page := v.page()
+	switch key {
+	case "h", "left", "backspace":
+		v.cx--
+	case "l", "right":
+		v.cx++
+	case "j", "down", "ctrl-n":
+		v.cy++
+	case "k", "up", "ctrl-p":
+		v.cy--
+	case "0", "home":
+		v.cx = 0
+	case "^":
+		line := v.line(v.cy)
+		v.cx = 0
+		for v.cx < len(line) && unicode.IsSpace(line[v.cx]) {
+			v.cx++
+		}
+	case "$", "end":
+		v.cx = len(v.line(v.cy))
+	case "w":
+		v.wordForward()
+	case "b":
+		v.wordBack()
+	case "g":
+		v.cy, v.cx = 0, 0
+	case "G":
+		v.cy, v.cx = len(v.lines)-1, 0
+	case "H":
+		v.cy = v.top
+	case "M":
+		v.cy = v.top + min(page, len(v.lines)-v.top)/2
+	case "L":
+		v.cy = v.top + page - 1
+	case "ctrl-u":
+		v.cy -= page / 2
+	case "ctrl-d":
+		v.cy += page / 2
+	case "ctrl-b", "pageup":
+		v.cy -= page
+	case "ctrl-f", "pagedown":
+		v.cy += page
+	case "wheelup", "wheeldown":
+		v.key(key)
+		v.cy = min(max(v.cy, v.top), v.top+page-1)
+	case "v", " ":
+		v.selecting = !v.selecting || v.lineSelect
+		v.lineSelect = false
+		v.ay, v.ax = v.cy, v.cx
+	case "V":
+		v.selecting = !v.selecting || !v.lineSelect
+		v.lineSelect = true
+		v.ay, v.ax = v.cy, v.cx
+	case "y", "enter":
+		v.copySelection()
+		return true
+	case "esc":
+		if !v.selecting {
+			return false // leave copy mode
+		}
+		v.selecting = false
+	default:
+		return false
+	}
+	v.clampCursor()
+	// Keep the cursor in view
+	if v.cy < v.top {
+		v.top = v.cy
+	} else if v.cy >= v.top+page {
+		v.top = v.cy - page + 1
+	}
+	return true
+}
+
+// line returns line y as characters.
+func (v *scrollView) line(y int) []rune {
+	if y < 0 || y >= len(v.lines) {
+		return nil
+	}
+	return []rune(v.lines[y])
+}
+
+// clampCursor keeps the cursor on a character of a line. The caller holds
+// mu.
+func (v *scrollView) clampCursor() {
+	v.cy = min(max(v.cy, 0), max(len(v.lines)-1, 0))
+	v.cx = min(max(v.cx, 0), max(len(v.line(v.cy))-1, 0))
+}
+
+// wordForward moves the cursor to the start of the next word: the next
+// run of characters other than spaces. The caller holds mu.
+func (v *scrollView) wordForward() {
+	line := v.line(v.cy)
+	x := v.cx
+	for x < len(line) && !unicode.IsSpace(line[x]) {
+		x++
+	}
+	for {
+		for x < len(line) && unicode.IsSpace(line[x]) {
+			x++
+		}
+		if x < len(line) || v.cy >= len(v.lines)-1 {
+			break
+		}
+		v.cy++
+		line, x = v.line(v.cy), 0
+	}
+	v.cx = x
+}
+
+// wordBack moves the cursor to the start of this word, or of the one before
+// if it is there already. The caller holds mu.
+func (v *scrollView) wordBack() {
+	line := v.line(v.cy)
+	x := min(v.cx, len(line)) - 1
+	for {
+		for x >= 0 && unicode.IsSpace(line[x]) {
+			x--
+		}
+		if x >= 0 || v.cy == 0 {
+			break
+		}
+		v.cy--
+		line = v.line(v.cy)
+		x = len(line) - 1
+	}
+	for x > 0 && !unicode.IsSpace(line[x-1]) {
+		x--
+	}
+	v.cx = max(x, 0)
+}
+
+// selected returns the characters of line y that are selected, from and
+// up to to; to past the end of the line selects its line break too. The
+// caller holds mu.
+func (v *scrollView) selected(y int) (from, to int) {
+	if !v.selecting {
+		return 0, 0
+	}
+	sy, sx, ey, ex := v.ay, v.ax, v.cy, v.cx
+	if sy > ey || sy == ey && sx > ex {
+		sy, sx, ey, ex = ey, ex, sy, sx
+	}
+	if y < sy || y > ey {
+		return 0, 0
+	}
+	from, to = 0, len(v.line(y))+1
+	if !v.lineSelect {
+		if y == sy {
+			from = sx
+		}
+		if y == ey {
+			to = ex + 1
+		}
+	}
+	return from, to
+}
+
+// copySelection copies the selection to the clipboard and closes the view.
+// The caller holds mu.
+func (v *scrollView) copySelection() {
+	if !v.selecting {
+		v.message = "nothing selected: v or V starts a selection"
+		return
+	}
+	var b strings.Builder
+	for y := range v.lines {
+		from, to := v.selected(y)
+		if from >= to {
+			continue
+		}
+		line := v.line(y)
+		b.WriteString(string(line[min(from, len(line)):min(to, len(line))]))
+		if to > len(line) && (v.lineSelect || y < max(v.ay, v.cy)) {
+			b.WriteByte('\n')
+		}
+	}
+	v.close()
+	v.yank([]byte(b.String()))
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// ageMagic starts every age-encrypted file.
+const ageMagic = "age-encryption.org/"
+
+// parseRecipients returns the age recipients in value: a public key
+// ("age1..."), or a file of them, one per line.
+func parseRecipients(value string) ([]age.Recipient, error) {
+	if strings.HasPrefix(value, "age1") {
+		r, err := age.ParseX25519Recipient(value)
+		if err != nil {
+			return nil, err
+		}
+		return []age.Recipient{r}, nil
+	}
+	f, err := os.Open(value)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	return age.ParseRecipients(f)
+}
+
+// parseIdentities returns the age identities in the file at path, as
+// written by age-keygen.
+func parseIdentities(path string) ([]age.Identity, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	return age.ParseIdentities(f)
+}
+
+// encryptTo returns a writer encrypting to recipients on its way to w.
+// Closing it finishes the encryption and closes w.
+func encryptTo(w io.WriteCloser, recipients []age.Recipient) (io.WriteCloser, error) {
+	enc, err := age.Encrypt(w, recipients...)
+	if err != nil {
+		_ = w.Close()
+		return nil, err
+	}
+	return encryptedFile{enc, w}, nil
+}
+
+type encryptedFile struct {
+	io.WriteCloser // the encryption
+	f              io.Closer
+}
+
+func (e encryptedFile) Close() error {
+	return errors.Join(e.WriteCloser.Close(), e.f.Close())
+}
+
+// decrypted returns r decrypted with identities if it is age-encrypted, or
+// r as it is if not.
+func decrypted(r *bufio.Reader, identities []age.Identity) (*bufio.Reader, error) {
+	magic, _ := r.Peek(len(ageMagic))
+	if !bytes.Equal(magic, []byte(ageMagic)) {
+		return r, nil
+	}
+	if len(identities) == 0 {
+		return nil, errors.New("the recording is encrypted; give its key with --identity")
+	}
+	dec, err := age.Decrypt(r, identities...)
+	if err != nil {
+		return nil, err
+	}
+	return bufio.NewReader(dec), nil
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/samuelstevens/claude-unfocused/pkg/ptyproxy"
+)
+
+// serveEnv carries the socket path to the background server process that
+// owns a detachable session's PTY.
+const serveEnv = "CLAUDE_UNFOCUSED_SERVE"
+
+// Frames exchanged between an attached client and the session server.
+// Each is a type byte, a big-endian uint32 length and the payload.
+const (
+	frameData   byte = iota // input to the child, or output from it
+	frameResize             // cols and rows as big-endian uint16s
+	frameSignal             // signal number as a big-endian uint32
+	frameKill               // kill the child
+	frameExit               // child exit code as a big-endian int32
+)
+
+const maxFrame = 1 << 20
+
+// errDetached is returned by Wait when the server dropped the client before
+// the child exited, e.g. because another client attached.
+var errDetached = errors.New("detached from session")
+
+func writeFrame(w io.Writer, kind byte, payload []byte) error {
+	buf := make([]byte, 5+len(payload))
+	buf[0] = kind
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(payload)))
+	copy(buf[5:], payload)
+	_, err := w.Write(buf)
+	return err
+}
+
+func readFrame(r *bufio.Reader) (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(header[1:])
+	if n > maxFrame {
+		return 0, nil, fmt.Errorf("frame too large: %d bytes", n)
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+// runtimeDir returns the directory holding session sockets, creating it if
+// needed. It refuses one that isn't private to the user: under the shared
+// temporary directory, anyone could have made it first.
+func runtimeDir() (string, error) {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir != "" {
+		dir = filepath.Join(dir, "claude-unfocused")
+	} else {
+		dir = filepath.Join(os.TempDir(), fmt.Sprintf("claude-unfocused-%d", os.Getuid()))
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	if err := checkPrivateDir(dir); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// sessionSocket returns the socket path for the named session.
+func sessionSocket(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid session name %q", name)
+	}
+	dir, err := runtimeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+".sock"), nil
+}
+
+// sessionAlive reports whether a server is listening on sock.
+func sessionAlive(sock string) bool {
+	conn, err := net.DialTimeout("unix", sock, time.Second)
+	if err != nil {
+		return false
+	}
+	_ = conn.Close()
+	return true
+}
+
+// listSessions returns the names of running sessions, removing sockets left
+// behind by servers that died.
+func listSessions() ([]string, error) {
+	dir, err := runtimeDir()
+	if err != nil {
+		return nil, err
+	}
+	socks, err := filepath.Glob(filepath.Join(dir, "*.sock"))
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, sock := range socks {
+		if !sessionAlive(sock) {
+			_ = os.Remove(sock)
+			_ = os.Remove(infoPath(sock))
+			continue
+		}
+		names = append(names, strings.TrimSuffix(filepath.Base(sock), ".sock"))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// defaultSessionName names a session after the working directory, adding a
+// numeric suffix if that name is taken.
+func defaultSessionName() string {
+	base := "session"
+	if wd, err := os.Getwd(); err == nil && filepath.Base(wd) != string(filepath.Separator) {
+		base = strings.TrimLeft(filepath.Base(wd), ".")
+	}
+	name := base
+	for i := 2; ; i++ {
+		sock, err := sessionSocket(name)
+		if err != nil || !sessionAlive(sock) {
+			return name
+		}
+		name = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// startDetached launches a session server running the same command line in
+// the background and attaches to it.
+func startDetached(name string) (ptyproxy.Session, error) {
+	sock, err := sessionSocket(name)
+	if err != nil {
+		return nil, err
+	}
+	if sessionAlive(sock) {
+		return nil, fmt.Errorf("session %q already exists", name)
+	}
+	_ = os.Remove(sock)
+
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	logPath := strings.TrimSuffix(sock, ".sock") + ".log"
+	logFile, err := os.Create(logPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = logFile.Close() }()
+
+	server := exec.Command(exe, slices.Concat(flagEnvArgs, os.Args[1:])...)
+	server.Env = append(os.Environ(), serveEnv+"="+sock)
+	server.Stderr = logFile
+	detachProcess(server)
+	if err := server.Start(); err != nil {
+		return nil, err
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- server.Wait() }()
+
+	// Wait for the server to start listening
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("unix", sock)
+		if err == nil {
+			return newRemoteSession(conn), nil
+		}
+		select {
+		case err := <-exited:
+			return nil, fmt.Errorf("session server exited (%v); see %s", err, logPath)
+		case <-time.After(20 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("session %q did not start: %w", name, err)
+		}
+	}
+}
+
+// onlySession returns the name of the only running session, for attaching
+// without naming one.
+func onlySession() (string, error) {
+	names, err := listSessions()
+	if err != nil {
+		return "", err
+	}
+	switch len(names) {
+	case 0:
+		return "", errors.New("no running sessions")
+	case 1:
+		return names[0], nil
+	default:
+		return "", fmt.Errorf("several sessions are running, name one of: %s", strings.Join(names, ", "))
+	}
+}
+
+// attachSession connects to a running session.
+func attachSession(name string) (ptyproxy.Session, error) {
+	sock, err := sessionSocket(name)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, fmt.Errorf("session %q: %w", name, err)
+	}
+	return newRemoteSession(conn), nil
+}
+
+// serve runs the background side of a detachable session: it owns the PTY
+// and relays it to whichever client is attached, returning the child's exit
+// code once it exits.
+func serve(sock string, cmd *exec.Cmd, bufSize int, life *lifecycle, hook *webhook) int {
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "listen: %v\n", err)
+		return 1
+	}
+	defer func() { _ = ln.Close() }()
+
+	ptmx, err := ptyproxy.Start(cmd)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to start PTY: %v\n", err)
+		return 1
+	}
+	defer func() { _ = ptmx.Close() }()
+	dir := cmd.Dir
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+	info := sessionInfo{
+		Name:    strings.TrimSuffix(filepath.Base(sock), ".sock"),
+		PID:     ptmx.Pid(),
+		Server:  os.Getpid(),
+		Dir:     dir,
+		Command: cmd.Args,
+		Started: time.Now(),
+	}
+	if err := writeSessionInfo(sock, info); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
+	defer func() { _ = os.Remove(infoPath(sock)) }()
+
+	s := &server{ptmx: ptmx, bufSize: bufSize}
+	go s.accept(ln)
+	relayed := make(chan struct{})
+	go func() {
+		s.relayOutput()
+		close(relayed)
+	}()
+
+	code, _ := ptmx.Wait()
+	// Let the child's last output reach the client before saying goodbye
+	select {
+	case <-relayed:
+	case <-time.After(time.Second):
+	}
+	s.exit(code)
+	life.exited(ptmx.Pid(), code)
+	hook.exited(commandName(cmd.Args), code)
+	return code
+}
+
+// server relays a session's PTY to the attached client. Only one client is
+// attached at a time; a new one replaces the old.
+type server struct {
+	ptmx    ptyproxy.Session
+	bufSize int // for reading the child's output
+	mu      sync.Mutex
+	client  net.Conn
+}
+
+func (s *server) accept(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+// handle applies a client's frames to the PTY until it disconnects. A
+// connection becomes the attached client once it sends its first data or
+// resize, so liveness probes that connect and hang up, and kill's signals,
+// do not displace anyone.
+func (s *server) handle(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	attached := false
+	for {
+		kind, payload, err := readFrame(r)
+		if err != nil {
+			break
+		}
+		attaching := !attached && (kind == frameData || kind == frameResize)
+		if attaching {
+			s.mu.Lock()
+			if s.client != nil {
+				_ = s.client.Close()
+			}
+			s.client = conn
+			s.mu.Unlock()
+		}
+		switch kind {
+		case frameData:
+			_, _ = s.ptmx.Write(payload)
+		case frameResize:
+			if len(payload) != 4 {
+				continue
+			}
+			cols := binary.BigEndian.Uint16(payload)
+			rows := binary.BigEndian.Uint16(payload[2:])
+			_ = s.ptmx.Resize(int(cols), int(rows))
+			if attaching {
+				// A new client has a blank screen: make the child repaint
+				ptyproxy.Refresh(s.ptmx)
+			}
+		case frameSignal:
+			if len(payload) == 4 {
+				_ = s.ptmx.Signal(syscall.Signal(binary.BigEndian.Uint32(payload)))
+			}
+		case frameKill:
+			_ = s.ptmx.Kill()
+		}
+		attached = attached || attaching
+	}
+	s.mu.Lock()
+	if s.client == conn {
+		s.client = nil
+	}
+	s.mu.Unlock()
+	_ = conn.Close()
+}
+
+// relayOutput sends the child's output to the attached client, discarding
+// it while none is attached.
+func (s *server) relayOutput() {
+	buf := make([]byte, s.bufSize)
+	for {
+		n, err := s.ptmx.Read(buf)
+		if n > 0 {
+			s.mu.Lock()
+			if s.client != nil && writeFrame(s.client, frameData, buf[:n]) != nil {
+				_ = s.client.Close()
+				s.client = nil
+			}
+			s.mu.Unlock()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// exit tells the attached client the child's exit code and disconnects it.
+func (s *server) exit(code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == nil {
+		return
+	}
+	_ = writeFrame(s.client, frameExit, binary.BigEndian.AppendUint32(nil, uint32(int32(code))))
+	_ = s.client.Close()
+	s.client = nil
+}
+
+// remoteSession is a ptyproxy.Session whose PTY lives in a session server.
+type remoteSession struct {
+	conn net.Conn
+	r    *bufio.Reader
+	wmu  sync.Mutex
+	buf  []byte // output not yet returned by Read
+	once sync.Once
+	done chan struct{}
+	code int
+	err  error
+}
+
+func newRemoteSession(conn net.Conn) *remoteSession {
+	return &remoteSession{conn: conn, r: bufio.NewReader(conn), done: make(chan struct{})}
+}
+
+func (s *remoteSession) Read(b []byte) (int, error) {
+	for len(s.buf) == 0 {
+		kind, payload, err := readFrame(s.r)
+		if err != nil {
+			s.finish(0, errDetached)
+			return 0, io.EOF
+		}
+		switch kind {
+		case frameData:
+			s.buf = payload
+		case frameExit:
+			code := 1
+			if len(payload) == 4 {
+				code = int(int32(binary.BigEndian.Uint32(payload)))
+			}
+			s.finish(code, nil)
+			return 0, io.EOF
+		}
+	}
+	n := copy(b, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+func (s *remoteSession) finish(code int, err error) {
+	s.once.Do(func() {
+		s.code, s.err = code, err
+		close(s.done)
+	})
+}
+
+func (s *remoteSession) send(kind byte, payload []byte) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	return writeFrame(s.conn, kind, payload)
+}
+
+func (s *remoteSession) Write(b []byte) (int, error) {
+	if err := s.send(frameData, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (s *remoteSession) Resize(cols, rows int) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(cols))
+	return s.send(frameResize, binary.BigEndian.AppendUint16(payload, uint16(rows)))
+}
+
+// Pid is unknown: the child belongs to the session server.
+func (s *remoteSession) Pid() int {
+	return 0
+}
+
+func (s *remoteSession) Signal(sig os.Signal) error {
+	num, ok := sig.(syscall.Signal)
+	if !ok {
+		return fmt.Errorf("cannot forward signal %v", sig)
+	}
+	return s.send(frameSignal, binary.BigEndian.AppendUint32(nil, uint32(num)))
+}
+
+func (s *remoteSession) Kill() error {
+	return s.send(frameKill, nil)
+}
+
+func (s *remoteSession) Wait() (int, error) {
+	<-s.done
+	return s.code, s.err
+}
+
+func (s *remoteSession) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// claudeDirs lists the places claude's installers commonly put it that may
+// be missing from PATH, such as when the wrapper is started from a desktop
+// launcher or a non-login shell.
+func claudeDirs() []string {
+	home, _ := os.UserHomeDir()
+	var dirs []string
+	if home != "" {
+		dirs = append(dirs,
+			filepath.Join(home, ".claude", "local"), // claude's own local install
+			filepath.Join(home, ".local", "bin"),
+			filepath.Join(home, ".npm-global", "bin"),
+			filepath.Join(home, ".volta", "bin"),
+			filepath.Join(home, ".bun", "bin"),
+		)
+	}
+	if prefix := os.Getenv("NPM_CONFIG_PREFIX"); prefix != "" {
+		dirs = append(dirs, filepath.Join(prefix, "bin"))
+	}
+	if bin := os.Getenv("NVM_BIN"); bin != "" {
+		dirs = append(dirs, bin)
+	} else if home != "" {
+		// The newest node nvm installed, as globs sort by version well enough
+		found, _ := filepath.Glob(filepath.Join(home, ".nvm", "versions", "node", "*", "bin"))
+		if len(found) > 0 {
+			dirs = append(dirs, found[len(found)-1])
+		}
+	}
+	if runtime.GOOS == "windows" {
+		if appData := os.Getenv("APPDATA"); appData != "" {
+			dirs = append(dirs, filepath.Join(appData, "npm"))
+		}
+	} else {
+		dirs = append(dirs, "/usr/local/bin", "/opt/homebrew/bin")
+	}
+	return dirs
+}
+
+// findClaude locates the claude binary when no path was configured,
+// looking on PATH and then in claudeDirs. The error lists everywhere it
+// looked.
+func findClaude() (string, error) {
+	if path, err := exec.LookPath("claude"); err == nil {
+		return path, nil
+	}
+	searched := []string{"PATH"}
+	var problems []string
+	for _, dir := range claudeDirs() {
+		candidate := filepath.Join(dir, "claude")
+		path, err := exec.LookPath(candidate)
+		if err == nil {
+			return path, nil
+		}
+		searched = append(searched, dir)
+		if info, statErr := os.Stat(candidate); statErr == nil && !info.IsDir() {
+			problems = append(problems, fmt.Sprintf("%s exists but is not executable", candidate))
+		}
+	}
+	var msg strings.Builder
+	msg.WriteString("claude not found; searched:\n")
+	for _, dir := range searched {
+		fmt.Fprintf(&msg, "  %s\n", dir)
+	}
+	for _, p := range problems {
+		fmt.Fprintf(&msg, "%s\n", p)
+	}
+	msg.WriteString("install it with `npm install -g @anthropic-ai/claude-code`, or point --claude (or claude in the config file) at it")
+	return "", errors.New(msg.String())
+}
+
+// checkCommand reports a clear error if name, given explicitly, cannot be
+// run.
+func checkCommand(name string) error {
+	if _, err := exec.LookPath(name); err != nil {
+		if errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%s: not found", name)
+		}
+		if errors.Is(err, os.ErrPermission) {
+			return fmt.Errorf("%s: not executable", name)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+
+	"github.com/samuelstevens/claude-unfocused/internal/ansiparse"
+	"github.com/samuelstevens/claude-unfocused/internal/terminfo"
+)
+
+// syncMode is the DEC private mode for synchronized output, which has the
+// terminal hold repaints until the child has drawn a whole frame.
+const syncMode = 2026
+
+// sgrRewrite rewrites one attribute of an SGR sequence, as sgrAttrs splits
+// them, returning what to set instead or nil to drop it.
+type sgrRewrite func(attr []byte) []byte
+
+// syncRule returns the output rule dropping synchronized output for a
+// terminal whose terminfo entry e doesn't list Sync, or nil if it does.
+func syncRule(e *terminfo.Entry) outputRule {
+	if e.Has("Sync") {
+		return nil
+	}
+	return func(seq ansiparse.Sequence) ([]byte, bool) {
+		return stripModes(seq, syncMode)
+	}
+}
+
+// attrDowngrade returns the SGR rewrite keeping from a terminal what its
+// terminfo entry e doesn't list: styled underlines (curly, dotted and so
+// on) without Smulx, which become plain ones, underline colors without
+// Setulc, italics without sitm and strikethrough without smxx. It returns
+// nil if e has them all.
+func attrDowngrade(e *terminfo.Entry) sgrRewrite {
+	styled, colored, italic, strike := e.Has("Smulx"), e.Has("Setulc"), e.Has("sitm"), e.Has("smxx")
+	if styled && colored && italic && strike {
+		return nil
+	}
+	return func(attr []byte) []byte {
+		head, _, _ := bytes.Cut(attr, []byte(";"))
+		head, sub, styledUnderline := bytes.Cut(head, []byte(":"))
+		switch string(head) {
+		case "4":
+			if styledUnderline && !styled {
+				if string(sub) == "0" {
+					return []byte("24")
+				}
+				return []byte("4")
+			}
+		case "58", "59":
+			if !colored {
+				return nil
+			}
+		case "3", "23":
+			if !italic {
+				return nil
+			}
+		case "9", "29":
+			if !strike {
+				return nil
+			}
+		}
+		return attr
+	}
+}
+
+// sgrRule returns the output rule passing each attribute of SGR sequences
+// through rewrites in turn.
+func sgrRule(rewrites ...sgrRewrite) outputRule {
+	return func(seq ansiparse.Sequence) ([]byte, bool) {
+		return rewriteSGR(seq, func(attr []byte) []byte {
+			for _, rw := range rewrites {
+				if attr = rw(attr); attr == nil {
+					break
+				}
+			}
+			return attr
+		})
+	}
+}
+
+// rewriteSGR passes each attribute an SGR sequence sets through fn. It
+// returns the rewritten
+// sequence, with nothing left if every attribute was dropped, and whether
+// fn changed anything.
+func rewriteSGR(seq ansiparse.Sequence, fn sgrRewrite) ([]byte, bool) {
+	if seq.Kind != ansiparse.CSI || seq.Final != 'm' || seq.Private() != 0 || len(seq.Intermediates) > 0 || len(seq.Params) == 0 {
+		return nil, false
+	}
+	attrs := sgrAttrs(seq.Params)
+	kept := make([][]byte, 0, len(attrs))
+	changed := false
+	for _, attr := range attrs {
+		b := fn(attr)
+		changed = changed || !bytes.Equal(b, attr)
+		if b != nil {
+			kept = append(kept, b)
+		}
+	}
+	if !changed {
+		return nil, false
+	}
+	if len(kept) == 0 {
+		return nil, true // an empty SGR would reset the attributes
+	}
+	return append(append([]byte("\x1b["), bytes.Join(kept, []byte(";"))...), 'm'), true
+}
+
+// sgrAttrs splits SGR parameters into attributes: single parameters, with
+// any colon-separated arguments, or a color given as 38;5;n or 38;2;r;g;b
+// (or 48 or 58 for the background and underline) with its arguments.
+func sgrAttrs(params []byte) [][]byte {
+	fields := bytes.Split(params, []byte(";"))
+	var attrs [][]byte
+	for i := 0; i < len(fields); {
+		n := 1
+		switch string(fields[i]) {
+		case "38", "48", "58":
+			if i+1 < len(fields) {
+				switch string(fields[i+1]) {
+				case "5":
+					n = 3
+				case "2":
+					n = 5
+				}
+			}
+		}
+		n = min(n, len(fields)-i)
+		attrs = append(attrs, bytes.Join(fields[i:i+n], []byte(";")))
+		i += n
+	}
+	return attrs
+}
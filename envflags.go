@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// flagEnvPrefix starts the environment variables that set the wrapper's
+// flags, as CLAUDE_UNFOCUSED_ESC_TIMEOUT sets --esc-timeout. None of the
+// flags is named so as to take the variables the wrapper sets itself, for
+// hooks and the background server.
+const flagEnvPrefix = "CLAUDE_UNFOCUSED_"
+
+// noEnvFlags are the flags that only make sense on the command line.
+var noEnvFlags = map[string]bool{"version": true}
+
+// flagEnv returns the environment variable that sets the flag name.
+func flagEnv(name string) string {
+	return flagEnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// flagEnvArgs are the flags applyFlagEnv set, as arguments for the wrapper
+// to pass on when it runs itself again, inside tmux or as a session server:
+// the variables are taken out of the environment as they are read, so that
+// neither claude nor a wrapper run again applies them a second time.
+var flagEnvArgs []string
+
+// applyFlagEnv sets each flag not given on the command line from its
+// environment variable, if that is set and not empty, so that the
+// environment overrides the config file and the command line overrides
+// the environment. A list flag, such as --env, gets one value this way.
+// Each variable is unset once read, and the flags it set recorded in
+// flagEnvArgs.
+func applyFlagEnv(fs *pflag.FlagSet) error {
+	var err error
+	fs.VisitAll(func(f *pflag.Flag) {
+		if noEnvFlags[f.Name] {
+			return
+		}
+		name := flagEnv(f.Name)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		_ = os.Unsetenv(name)
+		if err != nil || value == "" || f.Changed {
+			return
+		}
+		if serr := fs.Set(f.Name, value); serr != nil {
+			err = fmt.Errorf("bad %s: %w", name, serr)
+			return
+		}
+		flagEnvArgs = append(flagEnvArgs, "--"+f.Name+"="+value)
+	})
+	return err
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/samuelstevens/claude-unfocused/pkg/escfilter"
+)
+
+// eventLog logs what happens in a session as JSON objects, one per line,
+// each with a timestamp, the event's name in msg, and the session's ID and
+// name. A nil *eventLog discards everything.
+type eventLog struct {
+	l *slog.Logger
+}
+
+func newEventLog(w io.Writer, id, name string) *eventLog {
+	return &eventLog{l: slog.New(slog.NewJSONHandler(w, nil)).With("session_id", id, "session", name)}
+}
+
+// newSessionID returns a random ID telling this run's events apart from
+// other sessions'.
+func newSessionID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (e *eventLog) start(argv []string, pid int) {
+	if e == nil {
+		return
+	}
+	e.l.Info("start", "argv", argv, "pid", pid)
+}
+
+func (e *eventLog) resize(cols, rows int) {
+	if e == nil {
+		return
+	}
+	e.l.Info("resize", "cols", cols, "rows", rows)
+}
+
+// signal logs a signal passed on to the child.
+func (e *eventLog) signal(sig os.Signal) {
+	if e == nil {
+		return
+	}
+	e.l.Info("signal", "signal", sig.String())
+}
+
+// filterEvent logs an input filter decision, other than to forward a key as
+// typed. Pastes are logged by size, not content.
+func (e *eventLog) filterEvent(ev escfilter.Event) {
+	if e == nil || ev.Action == "forward" {
+		return
+	}
+	if ev.Action == "paste" {
+		e.l.Info("filter", "action", ev.Action, "kind", ev.Kind, "size", len(ev.Raw))
+		return
+	}
+	e.l.Info("filter", "action", ev.Action, "kind", ev.Kind, "bytes", string(ev.Raw))
+}
+
+func (e *eventLog) exit(code int, err error) {
+	if e == nil {
+		return
+	}
+	if err != nil {
+		e.l.Info("exit", "code", code, "error", err.Error())
+		return
+	}
+	e.l.Info("exit", "code", code)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// expectEvery is how often a rule may fire when it doesn't say.
+const expectEvery = 5 * time.Second
+
+// expectRule answers a line of the child's output matching Pattern by
+// typing Send, after Delay. It fires at most once per Every, and no more
+// than Max times if Max is positive. With DryRun it only logs what it
+// would have sent.
+type expectRule struct {
+	Pattern string        `toml:"pattern"`
+	Send    string        `toml:"send"`
+	Delay   time.Duration `toml:"delay"`
+	Every   time.Duration `toml:"every"`
+	Max     int           `toml:"max"`
+	DryRun  bool          `toml:"dry_run"`
+}
+
+// expecter runs the expect rules against the child's output, matched as
+// plainLines. A nil *expecter does nothing.
+type expecter struct {
+	rules  []expectRule
+	res    []*regexp.Regexp
+	dryRun bool         // log only, for every rule
+	send   func([]byte) // types into the child
+
+	mu    sync.Mutex
+	lines plainLines
+	last  []time.Time // when each rule last fired
+	fired []int
+}
+
+// compileExpect compiles the rules' patterns.
+func compileExpect(rules []expectRule) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, len(rules))
+	for i, r := range rules {
+		if r.Delay < 0 || r.Every < 0 || r.Max < 0 {
+			return nil, fmt.Errorf("rule %d: settings must not be negative", i+1)
+		}
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		res[i] = re
+	}
+	return res, nil
+}
+
+func newExpecter(rules []expectRule, dryRun bool, send func([]byte)) *expecter {
+	res, _ := compileExpect(rules) // validated by loadConfig
+	return &expecter{
+		rules:  rules,
+		res:    res,
+		dryRun: dryRun,
+		send:   send,
+		last:   make([]time.Time, len(rules)),
+		fired:  make([]int, len(rules)),
+	}
+}
+
+// Write matches child output, so an expecter can sit in an io.MultiWriter.
+func (e *expecter) Write(b []byte) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	e.lines.feed(b, func(line []byte) {
+		for i, re := range e.res {
+			r := e.rules[i]
+			every := r.Every
+			if every == 0 {
+				every = expectEvery
+			}
+			if r.Max > 0 && e.fired[i] >= r.Max || now.Sub(e.last[i]) < every || !re.Match(line) {
+				continue
+			}
+			e.last[i] = now
+			e.fired[i]++
+			e.fire(r, line)
+		}
+	})
+	return len(b), nil
+}
+
+// fire answers for rule r, which matched line. The caller holds mu.
+func (e *expecter) fire(r expectRule, line []byte) {
+	if e.dryRun || r.DryRun {
+		log.Printf("expect: %q matched %q; would send %s", r.Pattern, line, strconv.Quote(r.Send))
+		notice("expect would send " + strconv.Quote(r.Send))
+		return
+	}
+	send := []byte(r.Send)
+	time.AfterFunc(r.Delay, func() { e.send(send) })
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"strconv"
+
+	"github.com/samuelstevens/claude-unfocused/internal/ansiparse"
+)
+
+// focusModeRule keeps the child's DECSET/DECRST 1004 from the terminal, so
+// that the terminal never starts sending focus reports and the input filter
+// is only a backstop. The input filter still sees the child's output as it
+// was, so it knows whether the child asked for them. Other modes set in the
+// same sequence are passed on.
+func focusModeRule() outputRule {
+	return func(seq ansiparse.Sequence) ([]byte, bool) {
+		return stripModes(seq, 1004)
+	}
+}
+
+// stripModes returns seq without the DEC private modes n it sets or resets,
+// with nothing left if those were all, and whether it had any of them.
+func stripModes(seq ansiparse.Sequence, n ...int) ([]byte, bool) {
+	if seq.Kind != ansiparse.CSI || seq.Private() != '?' || len(seq.Intermediates) > 0 ||
+		(seq.Final != 'h' && seq.Final != 'l') {
+		return nil, false
+	}
+	params := bytes.Split(seq.Params[1:], []byte(";"))
+	kept := params[:0]
+	for _, p := range params {
+		if mode, err := strconv.Atoi(string(p)); err != nil || !slices.Contains(n, mode) {
+			kept = append(kept, p)
+		}
+	}
+	if len(kept) == len(params) {
+		return nil, false
+	}
+	if len(kept) == 0 {
+		return nil, true
+	}
+	return append(append([]byte("\x1b[?"), bytes.Join(kept, []byte(";"))...), seq.Final), true
+}
@@ -3,9 +3,16 @@ module github.com/samuelstevens/claude-unfocused
 go 1.25.1
 
 require (
+	filippo.io/age v1.3.2
+	github.com/BurntSushi/toml v1.6.0
 	github.com/creack/pty v1.1.24
 	github.com/spf13/pflag v1.0.10
-	golang.org/x/term v0.38.0
+	go.starlark.net v0.0.0-20260908191801-89a6a09411d5
+	golang.org/x/sys v0.47.0
+	golang.org/x/term v0.45.0
 )
 
-require golang.org/x/sys v0.39.0 // indirect
+require (
+	filippo.io/hpke v0.4.0 // indirect
+	golang.org/x/crypto v0.55.0 // indirect
+)
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/samuelstevens/claude-unfocused/internal/ansiparse"
+)
+
+// graphicsModes are the accepted values of the graphics setting, which
+// decides what happens to inline images from the child, sent as sixel, with
+// iTerm2's OSC 1337 File or with the Kitty graphics protocol:
+//
+//	allow  pass them to the terminal, untouched
+//	block  drop them, and keep sixel out of the device attributes the
+//	       child is told of
+var graphicsModes = map[string]bool{"allow": true, "block": true}
+
+// graphicsRule returns the output rule applying mode to inline images, or
+// nil if they should just pass through.
+func graphicsRule(mode string) outputRule {
+	if mode == "allow" {
+		return nil
+	}
+	return func(seq ansiparse.Sequence) ([]byte, bool) {
+		return nil, isGraphics(seq)
+	}
+}
+
+// isGraphics reports whether seq is, or is part of, an inline image: sixel,
+// an iTerm2 file transfer, whole or in parts, or a Kitty graphics command.
+func isGraphics(seq ansiparse.Sequence) bool {
+	switch seq.Kind {
+	case ansiparse.DCS:
+		return seq.Final == 'q' && len(seq.Intermediates) == 0
+	case ansiparse.OSC:
+		rest, ok := bytes.CutPrefix(seq.Data, []byte("1337;"))
+		if !ok {
+			return false
+		}
+		for _, cmd := range []string{"File=", "MultipartFile=", "FilePart=", "FileEnd"} {
+			if bytes.HasPrefix(rest, []byte(cmd)) {
+				return true
+			}
+		}
+	case ansiparse.APC:
+		return bytes.HasPrefix(seq.Data, []byte("G"))
+	}
+	return false
+}
+
+// graphicsReplies returns the input filter's hook fixing up the terminal's
+// replies about its graphics for the child: with images blocked, sixel is
+// taken out of the device attributes, and sizes in rows or pixels are cut
+// down to the rows the child has, which rows reports with the terminal's.
+func graphicsReplies(mode string, rows func() (term, child int)) func(name string, raw []byte) []byte {
+	return func(name string, raw []byte) []byte {
+		var seq ansiparse.Sequence
+		var parser ansiparse.Parser
+		parser.Feed(raw, func(s ansiparse.Sequence) { seq = s }) // raw is one whole sequence
+		ints := seq.Ints()
+		term, child := rows()
+		scale := func(n int) string { return strconv.Itoa(n * child / term) }
+		switch {
+		case name == "DA1" && mode == "block":
+			attrs := bytes.Split(seq.Params[1:], []byte(";"))
+			attrs = slices.DeleteFunc(attrs, func(a []byte) bool { return string(a) == "4" })
+			return fmt.Appendf(nil, "\x1b[?%sc", bytes.Join(attrs, []byte(";")))
+		case term <= 0 || child >= term:
+		case name == "XTSMGRAPHICS 2" && len(ints) == 4 && ints[1] == 0:
+			return fmt.Appendf(nil, "\x1b[?2;0;%d;%sS", ints[2], scale(ints[3]))
+		case (name == "XTWINOPS 14" || name == "XTWINOPS 18") && len(ints) == 3:
+			return fmt.Appendf(nil, "\x1b[%d;%s;%dt", ints[0], scale(ints[1]), ints[2])
+		}
+		return raw
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/samuelstevens/claude-unfocused/internal/vt"
+	"github.com/samuelstevens/claude-unfocused/pkg/ptyproxy"
+)
+
+// helpLines lists the keys cfg binds: the wrapper's, the remapped ones and
+// the snippets.
+func helpLines(cfg config) []string {
+	var lines []string
+	row := func(keys, what string) {
+		lines = append(lines, fmt.Sprintf("  %-16s %s", keys, what))
+	}
+	entries := paletteEntries(cfg)
+	lines = append(lines, "wrapper keys")
+	for _, e := range entries {
+		if e.text == nil && e.keys != "" {
+			row(e.keys, e.name)
+		}
+	}
+	if cfg.Keys.bound(cfg.Keys.Palette) {
+		row(cfg.Keys.expand(cfg.Keys.Palette), "command palette")
+	}
+	if cfg.InterceptSuspend && cfg.Keys.SuspendKey != "" {
+		row(cfg.Keys.SuspendKey, "suspend")
+	}
+	if cfg.Keys.Prefix != "" {
+		row(cfg.Keys.expand("prefix prefix"), "send "+cfg.Keys.Prefix)
+	}
+	if len(cfg.Keymap) > 0 {
+		lines = append(lines, "", "remapped keys")
+		for _, chord := range slices.Sorted(maps.Keys(cfg.Keymap)) {
+			what := "sends " + cfg.Keymap[chord]
+			if cfg.Keymap[chord] == "" {
+				what = "swallowed"
+			}
+			row(cfg.Keys.expand(chord), what)
+		}
+	}
+	if len(cfg.Snippets) > 0 {
+		lines = append(lines, "", "snippets")
+		for _, e := range entries {
+			if e.text != nil {
+				row(e.keys, strings.TrimPrefix(e.name, "type: "))
+			}
+		}
+	}
+	return lines
+}
+
+// keyHelp shows the key bindings in a box over the child's screen, until a
+// key other than those scrolling it is pressed. The child's output is held
+// back meanwhile, and the screen is repainted from the virtual terminal
+// afterwards. A nil *keyHelp does nothing.
+type keyHelp struct {
+	screen  *vt.Screen
+	gate    *outputGate
+	w       io.Writer // the terminal
+	lines   []string
+	session ptyproxy.Session
+	capture func(func([]byte) bool)
+
+	mu         sync.Mutex
+	open       bool
+	keys       keyReader
+	top        int // first line shown
+	cols, rows int
+}
+
+// show opens the help. It does nothing while it is open.
+func (h *keyHelp) show() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.open {
+		return
+	}
+	h.cols, h.rows = h.screen.Size()
+	if cols, rows, err := ptyproxy.TermSize(); err == nil && cols > 0 && rows > 0 {
+		h.cols, h.rows = cols, rows
+	}
+	h.keys, h.top = keyReader{}, 0
+	h.open = true
+	h.gate.hold()
+	h.capture(h.input)
+	h.draw()
+}
+
+// close repaints the child's screen. The caller holds mu.
+func (h *keyHelp) close() {
+	h.open = false
+	h.capture(nil)
+	h.gate.release()
+	_, _ = h.w.Write(h.screen.Redraw())
+	ptyproxy.Refresh(h.session)
+}
+
+// resize redraws the help for a resized terminal.
+func (h *keyHelp) resize(cols, rows int) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.open {
+		return
+	}
+	h.cols, h.rows = cols, rows
+	_, _ = h.w.Write(h.screen.Redraw())
+	h.draw()
+}
+
+// input scrolls the help, or closes it on any other key, taking all input.
+func (h *keyHelp) input(b []byte) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.keys.feed(b, func(key string) {
+		if !h.open {
+			return
+		}
+		page := h.page()
+		switch key {
+		case "up", "k", "ctrl-p":
+			h.top--
+		case "down", "j", "ctrl-n":
+			h.top++
+		case "pageup", "ctrl-b":
+			h.top -= page
+		case "pagedown", " ", "ctrl-f":
+			h.top += page
+		default:
+			h.close()
+			return
+		}
+		h.top = min(max(h.top, 0), max(len(h.lines)-page, 0))
+	})
+	if h.open {
+		h.draw()
+	}
+	return true
+}
+
+// page is how many lines the box shows.
+func (h *keyHelp) page() int {
+	return max(min(len(h.lines), h.rows-2), 1)
+}
+
+// draw paints the box, centered. The caller holds mu.
+func (h *keyHelp) draw() {
+	width := min(h.cols-2, 72)
+	if width < 8 || h.rows < 3 {
+		return
+	}
+	page := h.page()
+	top := max((h.rows-page-2)/2, 0) + 1
+	left := (h.cols-width)/2 + 1
+	inner := width - 4
+
+	var b bytes.Buffer
+	line := func(y int, text string) {
+		fmt.Fprintf(&b, "\x1b[%d;%dH\x1b[0m%s", y, left, text)
+	}
+	title := " keys, any other to close "
+	if len(h.lines) > page {
+		title = " keys, j k to scroll, any other to close "
+	}
+	title = fitWidth(title, width-4)
+	line(top, "┌─"+title+strings.Repeat("─", max(width-3-len(title), 0))+"┐")
+	for i := range page {
+		text := ""
+		if n := h.top + i; n < len(h.lines) {
+			text = h.lines[n]
+		}
+		line(top+1+i, "│ "+padRight(text, inner)+" │")
+	}
+	line(top+1+page, "└"+strings.Repeat("─", width-2)+"┘")
+	fmt.Fprintf(&b, "\x1b[0m\x1b[%d;%dH", top, left)
+	_, _ = h.w.Write(b.Bytes())
+}
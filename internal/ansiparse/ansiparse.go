@@ -0,0 +1,347 @@
+// Package ansiparse is a streaming parser for terminal byte streams.
+//
+// It follows the DEC/ECMA-48 state machine closely enough to classify
+// escape, CSI, OSC, DCS, SOS, PM and APC sequences, but differs from a real
+// terminal in two ways that matter for proxying: 8-bit C1 controls are not
+// recognized (the stream is assumed to be UTF-8), and a C0 control or DEL
+// inside an escape or CSI sequence, or a DCS header, aborts the sequence
+// instead of being executed in place, so that emitting every Sequence's Raw
+// bytes in order reproduces the input exactly. The payload of a string
+// (OSC, DCS, SOS, PM or APC) keeps C0 controls as data: only CAN and SUB
+// abort it there, BEL ends an OSC and ESC starts its terminator.
+package ansiparse
+
+import "strconv"
+
+const (
+	bel = 0x07
+	can = 0x18
+	sub = 0x1a
+	esc = 0x1b
+	del = 0x7f
+)
+
+// Kind classifies a parsed unit of terminal data.
+type Kind uint8
+
+const (
+	Text    Kind = iota // run of printable bytes, including UTF-8
+	Control             // single C0 control byte or DEL
+	Esc                 // ESC, intermediates, final
+	CSI                 // ESC [ params intermediates final
+	OSC                 // ESC ] data, terminated by BEL or ST
+	DCS                 // ESC P params intermediates final data ST
+	SOS                 // ESC X data ST
+	PM                  // ESC ^ data ST
+	APC                 // ESC _ data ST
+	Invalid             // aborted or malformed sequence
+)
+
+var kindNames = [...]string{"Text", "Control", "Esc", "CSI", "OSC", "DCS", "SOS", "PM", "APC", "Invalid"}
+
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return "Kind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Sequence is one parsed unit. Its slices alias the parser's buffers (or the
+// input passed to Feed) and are only valid until the emit callback returns.
+type Sequence struct {
+	Kind          Kind
+	Raw           []byte // exact bytes as they appeared in the stream
+	Params        []byte // CSI/DCS parameter bytes, including any private marker
+	Intermediates []byte
+	Final         byte
+	Data          []byte // OSC/DCS/SOS/PM/APC payload, without terminator
+}
+
+// Private returns the private marker ('<', '=', '>' or '?') that starts
+// Params, or 0 if there is none.
+func (s Sequence) Private() byte {
+	if len(s.Params) > 0 && s.Params[0] >= '<' && s.Params[0] <= '?' {
+		return s.Params[0]
+	}
+	return 0
+}
+
+// Ints parses Params, after any private marker, as semicolon-separated
+// decimal numbers. Empty or malformed fields are returned as 0.
+func (s Sequence) Ints() []int {
+	params := s.Params
+	if s.Private() != 0 {
+		params = params[1:]
+	}
+	if len(params) == 0 {
+		return nil
+	}
+	var ints []int
+	n := 0
+	for _, c := range params {
+		switch {
+		case c == ';':
+			ints = append(ints, n)
+			n = 0
+		case c >= '0' && c <= '9':
+			n = n*10 + int(c-'0')
+		}
+	}
+	return append(ints, n)
+}
+
+type state uint8
+
+const (
+	ground state = iota
+	escape
+	escInter
+	csiParam
+	csiInter
+	csiIgnore
+	dcsParam
+	dcsInter
+	dcsData
+	dcsIgnore
+	str    // OSC, SOS, PM or APC payload
+	strEsc // ESC seen inside a string, expecting '\'
+)
+
+// Parser splits a byte stream into Sequences. Incomplete sequences are held
+// until later input completes them. The zero value is ready to use.
+type Parser struct {
+	state  state
+	prev   state // string state that saw the ESC now in strEsc
+	kind   Kind
+	raw    []byte
+	params []byte
+	inter  []byte
+	data   []byte
+	final  byte
+	one    [1]byte
+}
+
+// Feed parses b, calling emit for each complete Sequence in stream order.
+func (p *Parser) Feed(b []byte, emit func(Sequence)) {
+	text := -1 // start of a pending text run in b
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		if p.state == ground && isText(c) {
+			if text < 0 {
+				text = i
+			}
+			continue
+		}
+		if text >= 0 {
+			emit(Sequence{Kind: Text, Raw: b[text:i]})
+			text = -1
+		}
+		if !p.step(c, emit) {
+			i-- // sequence aborted; reprocess c in the new state
+		}
+	}
+	if text >= 0 {
+		emit(Sequence{Kind: Text, Raw: b[text:]})
+	}
+}
+
+// Pending returns the bytes of an incomplete sequence held by the parser.
+// The slice is only valid until the next call to Feed or Reset.
+func (p *Parser) Pending() []byte {
+	return p.raw
+}
+
+// Reset discards any incomplete sequence and returns to the ground state.
+func (p *Parser) Reset() {
+	p.state = ground
+	p.raw = p.raw[:0]
+	p.params = p.params[:0]
+	p.inter = p.inter[:0]
+	p.data = p.data[:0]
+	p.final = 0
+}
+
+// step advances the state machine by one byte outside a text run. It
+// returns false if c was not consumed and must be processed again.
+func (p *Parser) step(c byte, emit func(Sequence)) bool {
+	if p.state == ground {
+		if c == esc {
+			p.raw = append(p.raw, c)
+			p.state = escape
+			return true
+		}
+		p.one[0] = c
+		emit(Sequence{Kind: Control, Raw: p.one[:]})
+		return true
+	}
+
+	// CAN and SUB cancel any sequence; ESC interrupts all but strings
+	if c == can || c == sub {
+		p.abort(emit)
+		return false
+	}
+	if c == esc {
+		switch p.state {
+		case str, dcsData, dcsIgnore:
+			p.prev, p.state = p.state, strEsc
+			p.raw = append(p.raw, c)
+			return true
+		case strEsc:
+		default:
+			p.abort(emit)
+			return false
+		}
+	}
+
+	switch p.state {
+	case escape:
+		switch {
+		case c == '[':
+			p.state = csiParam
+		case c == ']':
+			p.kind, p.state = OSC, str
+		case c == 'P':
+			p.state = dcsParam
+		case c == 'X':
+			p.kind, p.state = SOS, str
+		case c == '^':
+			p.kind, p.state = PM, str
+		case c == '_':
+			p.kind, p.state = APC, str
+		case c >= 0x20 && c <= 0x2f:
+			p.inter = append(p.inter, c)
+			p.state = escInter
+		case c >= 0x30 && c <= 0x7e:
+			p.final = c
+			p.complete(Esc, c, emit)
+			return true
+		default:
+			p.abort(emit)
+			return false
+		}
+
+	case escInter:
+		switch {
+		case c >= 0x20 && c <= 0x2f:
+			p.inter = append(p.inter, c)
+		case c >= 0x30 && c <= 0x7e:
+			p.final = c
+			p.complete(Esc, c, emit)
+			return true
+		default:
+			p.abort(emit)
+			return false
+		}
+
+	case csiParam, dcsParam:
+		switch {
+		case c >= 0x30 && c <= 0x3f:
+			p.params = append(p.params, c)
+		case c >= 0x20 && c <= 0x2f:
+			p.inter = append(p.inter, c)
+			p.state++ // csiInter or dcsInter
+		case c >= 0x40 && c <= 0x7e:
+			return p.finalByte(c, emit)
+		default:
+			p.abort(emit)
+			return false
+		}
+
+	case csiInter, dcsInter:
+		switch {
+		case c >= 0x20 && c <= 0x2f:
+			p.inter = append(p.inter, c)
+		case c >= 0x30 && c <= 0x3f:
+			if p.state == csiInter {
+				p.state = csiIgnore
+			} else {
+				p.state = dcsIgnore
+			}
+		case c >= 0x40 && c <= 0x7e:
+			return p.finalByte(c, emit)
+		default:
+			p.abort(emit)
+			return false
+		}
+
+	case csiIgnore:
+		switch {
+		case c >= 0x20 && c <= 0x3f:
+		case c >= 0x40 && c <= 0x7e:
+			p.complete(Invalid, c, emit)
+			return true
+		default:
+			p.abort(emit)
+			return false
+		}
+
+	case dcsData:
+		p.data = append(p.data, c)
+
+	case dcsIgnore:
+
+	case str:
+		if c == bel && p.kind == OSC {
+			p.complete(OSC, c, emit)
+			return true
+		}
+		p.data = append(p.data, c)
+
+	case strEsc:
+		if c != '\\' {
+			// Unterminated string: give it up and restart at its ESC
+			p.raw = p.raw[:len(p.raw)-1]
+			p.abort(emit)
+			p.raw = append(p.raw, esc)
+			p.state = escape
+			return false
+		}
+		kind := p.kind
+		if p.prev == dcsIgnore {
+			kind = Invalid
+		}
+		p.complete(kind, c, emit)
+		return true
+	}
+
+	p.raw = append(p.raw, c)
+	return true
+}
+
+// finalByte handles the final byte of a CSI or DCS header.
+func (p *Parser) finalByte(c byte, emit func(Sequence)) bool {
+	p.final = c
+	if p.state == csiParam || p.state == csiInter {
+		p.complete(CSI, c, emit)
+		return true
+	}
+	p.kind = DCS
+	p.state = dcsData
+	p.raw = append(p.raw, c)
+	return true
+}
+
+// complete appends the terminating byte c and emits the sequence.
+func (p *Parser) complete(kind Kind, c byte, emit func(Sequence)) {
+	p.raw = append(p.raw, c)
+	emit(Sequence{
+		Kind:          kind,
+		Raw:           p.raw,
+		Params:        p.params,
+		Intermediates: p.inter,
+		Final:         p.final,
+		Data:          p.data,
+	})
+	p.Reset()
+}
+
+// abort emits the incomplete sequence as Invalid and returns to ground.
+func (p *Parser) abort(emit func(Sequence)) {
+	emit(Sequence{Kind: Invalid, Raw: p.raw})
+	p.Reset()
+}
+
+// isText reports whether c is printed as-is in the ground state.
+func isText(c byte) bool {
+	return c >= 0x20 && c != del
+}
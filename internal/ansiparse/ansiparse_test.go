@@ -0,0 +1,87 @@
+package ansiparse_test
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/samuelstevens/claude-unfocused/internal/ansiparse"
+)
+
+// parse feeds input to a new parser in one write, returning each sequence
+// as its kind and raw bytes, and the bytes still pending.
+func parse(input string) ([]string, string) {
+	var p ansiparse.Parser
+	var got []string
+	p.Feed([]byte(input), func(seq ansiparse.Sequence) {
+		got = append(got, fmt.Sprintf("%v %q", seq.Kind, seq.Raw))
+	})
+	return got, string(p.Pending())
+}
+
+func TestFeed(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		pending string
+	}{
+		{"text", "hello", []string{`Text "hello"`}, ""},
+		{"control", "a\rb", []string{`Text "a"`, `Control "\r"`, `Text "b"`}, ""},
+		{"CSI", "\x1b[1;31m", []string{`CSI "\x1b[1;31m"`}, ""},
+		{"OSC BEL", "\x1b]0;title\a", []string{`OSC "\x1b]0;title\a"`}, ""},
+		{"OSC ST", "\x1b]0;title\x1b\\", []string{`OSC "\x1b]0;title\x1b\\"`}, ""},
+		{"DCS", "\x1bP1$r0m\x1b\\", []string{`DCS "\x1bP1$r0m\x1b\\"`}, ""},
+		{"unfinished", "\x1b]0;tit", nil, "\x1b]0;tit"},
+
+		// A C0 control aborts an escape or CSI sequence, and is then
+		// handled on its own
+		{"C0 in CSI", "\x1b[1\n2m", []string{`Invalid "\x1b[1"`, `Control "\n"`, `Text "2m"`}, ""},
+		{"C0 in escape", "\x1b(\rB", []string{`Invalid "\x1b("`, `Control "\r"`, `Text "B"`}, ""},
+		{"DEL in CSI", "\x1b[1\x7fm", []string{`Invalid "\x1b[1"`, `Control "\x7f"`, `Text "m"`}, ""},
+		{"C0 in DCS header", "\x1bP1\t$r", []string{`Invalid "\x1bP1"`, `Control "\t"`, `Text "$r"`}, ""},
+
+		// In a string's payload it is data, and only CAN and SUB abort
+		{"C0 in OSC", "\x1b]52;c;YQ\r\nbw\a", []string{`OSC "\x1b]52;c;YQ\r\nbw\a"`}, ""},
+		{"C0 in DCS data", "\x1bPq#0\n-\x1b\\", []string{`DCS "\x1bPq#0\n-\x1b\\"`}, ""},
+		{"C0 in APC", "\x1b_G\x01\x02\x1b\\", []string{`APC "\x1b_G\x01\x02\x1b\\"`}, ""},
+		{"BEL in APC", "\x1b_a\ab\x1b\\", []string{`APC "\x1b_a\ab\x1b\\"`}, ""},
+		{"CAN in OSC", "\x1b]0;ti\x18tle", []string{`Invalid "\x1b]0;ti"`, `Control "\x18"`, `Text "tle"`}, ""},
+		{"SUB in DCS data", "\x1bPqab\x1ax", []string{`Invalid "\x1bPqab"`, `Control "\x1a"`, `Text "x"`}, ""},
+		{"ESC in OSC", "\x1b]0;ti\x1b[m", []string{`Invalid "\x1b]0;ti"`, `CSI "\x1b[m"`}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, pending := parse(tt.input)
+			if !slices.Equal(got, tt.want) || pending != tt.pending {
+				t.Errorf("parse(%q) = %q, pending %q, want %q, pending %q", tt.input, got, pending, tt.want, tt.pending)
+			}
+		})
+	}
+}
+
+// TestFeedSplit checks that a stream parses the same fed a byte at a time,
+// and that the sequences' raw bytes add up to the stream.
+func TestFeedSplit(t *testing.T) {
+	const input = "a\x1b[1;31mb\x1b]0;t\r\n\a\x1b[1\nc\x1bPq\x01\x1b\\\x1b]x\x18d\x1b"
+	var p ansiparse.Parser
+	var raw strings.Builder
+	var kinds []ansiparse.Kind
+	for i := range len(input) {
+		p.Feed([]byte(input[i:i+1]), func(seq ansiparse.Sequence) {
+			raw.Write(seq.Raw)
+			if seq.Kind != ansiparse.Text {
+				kinds = append(kinds, seq.Kind)
+			}
+		})
+	}
+	raw.Write(p.Pending())
+	if raw.String() != input {
+		t.Errorf("raw bytes add up to %q, want %q", raw.String(), input)
+	}
+	want := []ansiparse.Kind{ansiparse.CSI, ansiparse.OSC, ansiparse.Invalid, ansiparse.Control, ansiparse.DCS, ansiparse.Invalid, ansiparse.Control}
+	if !slices.Equal(kinds, want) {
+		t.Errorf("kinds %v, want %v", kinds, want)
+	}
+}
@@ -0,0 +1,125 @@
+package ansiparse
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Query names the terminal query s makes, such as "DA1" for primary device
+// attributes or "OSC 11" for the background color, or returns "" if s is not
+// a query. A query and the reply it draws share a name.
+func (s Sequence) Query() string {
+	switch s.Kind {
+	case CSI:
+		ints := s.Ints()
+		zero := len(ints) == 0 || len(ints) == 1 && ints[0] == 0
+		switch {
+		case len(s.Intermediates) == 1 && s.Intermediates[0] == '$' && s.Final == 'p':
+			return "DECRQM"
+		case len(s.Intermediates) > 0:
+			return ""
+		case s.Final == 'c' && zero && s.Private() == 0:
+			return "DA1"
+		case s.Final == 'c' && zero && s.Private() == '>':
+			return "DA2"
+		case s.Final == 'c' && zero && s.Private() == '=':
+			return "DA3"
+		case s.Final == 'q' && zero && s.Private() == '>':
+			return "XTVERSION"
+		case s.Final == 'u' && s.Private() == '?':
+			return "KITTY"
+		case s.Final == 'S' && s.Private() == '?' && len(ints) >= 2 && ints[0] >= 1 && ints[0] <= 3:
+			// XTSMGRAPHICS, reading or setting the number of color
+			// registers or the sixel or ReGIS geometry, is answered with
+			// the values in effect
+			return fmt.Sprintf("XTSMGRAPHICS %d", ints[0])
+		case s.Final == 't' && s.Private() == 0 && len(ints) == 1 && (ints[0] == 14 || ints[0] == 16 || ints[0] == 18):
+			// XTWINOPS text area size in pixels, cell size, text area size
+			return fmt.Sprintf("XTWINOPS %d", ints[0])
+		case s.Final == 'n' && len(ints) == 1 && ints[0] == 5 && s.Private() == 0:
+			return "DSR"
+		case s.Final == 'n' && len(ints) == 1 && ints[0] == 6:
+			return "CPR"
+		}
+	case OSC:
+		if name, value, ok := oscColor(s.Data); ok && string(value) == "?" {
+			return name
+		}
+	case DCS:
+		if string(s.Intermediates) == "$" && s.Final == 'q' {
+			return "DECRQSS"
+		}
+	}
+	return ""
+}
+
+// Reply names the reply to a terminal query that s is, as Query names the
+// query, or returns "" if s is not one. Some replies look like keys (a
+// cursor position report is shift-F3 with modifiers, say), so only take s
+// for a reply while one is expected.
+func (s Sequence) Reply() string {
+	switch s.Kind {
+	case CSI:
+		switch {
+		case string(s.Intermediates) == "$" && s.Final == 'y':
+			return "DECRQM"
+		case len(s.Intermediates) > 0:
+			return ""
+		case s.Final == 'c' && s.Private() == '?':
+			return "DA1"
+		case s.Final == 'c' && s.Private() == '>':
+			return "DA2"
+		case s.Final == 'u' && s.Private() == '?':
+			return "KITTY"
+		case s.Final == 'S' && s.Private() == '?' && len(s.Ints()) >= 2 && s.Ints()[0] >= 1 && s.Ints()[0] <= 3:
+			return fmt.Sprintf("XTSMGRAPHICS %d", s.Ints()[0])
+		case s.Final == 't' && s.Private() == 0 && len(s.Ints()) == 3:
+			// Each XTWINOPS size reports as the query's number less 10
+			switch n := s.Ints()[0]; n {
+			case 4, 6, 8:
+				return fmt.Sprintf("XTWINOPS %d", n+10)
+			}
+		case s.Final == 'n' && s.Private() == 0 && len(s.Ints()) == 1 && s.Ints()[0] != 5 && s.Ints()[0] != 6:
+			return "DSR"
+		case s.Final == 'R' && len(s.Ints()) == 2:
+			return "CPR"
+		}
+	case OSC:
+		if name, value, ok := oscColor(s.Data); ok && string(value) != "?" {
+			return name
+		}
+	case DCS:
+		switch {
+		case string(s.Intermediates) == "!" && s.Final == '|':
+			return "DA3"
+		case s.Private() == '>' && s.Final == '|':
+			return "XTVERSION"
+		case string(s.Intermediates) == "$" && s.Final == 'r':
+			return "DECRQSS"
+		}
+	}
+	return ""
+}
+
+// oscColor splits the payload of an OSC 4 (palette) or OSC 10-19 (dynamic
+// color) sequence into the color's name and its value, "?" in a query.
+func oscColor(data []byte) (name string, value []byte, ok bool) {
+	code, rest, ok := bytes.Cut(data, []byte(";"))
+	if !ok {
+		return "", nil, false
+	}
+	switch c := string(code); {
+	case c == "4":
+		index, value, ok := bytes.Cut(rest, []byte(";"))
+		if !ok || bytes.Contains(value, []byte(";")) {
+			return "", nil, false
+		}
+		return "OSC 4;" + string(index), value, true
+	case len(c) == 2 && c[0] == '1' && c[1] >= '0' && c[1] <= '9':
+		if bytes.Contains(rest, []byte(";")) {
+			return "", nil, false
+		}
+		return "OSC " + c, rest, true
+	}
+	return "", nil, false
+}
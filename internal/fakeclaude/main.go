@@ -0,0 +1,79 @@
+//go:build !windows
+
+// Command fakeclaude stands in for claude in the wrapper's tests. It turns
+// on focus reporting and bracketed paste as claude does, then reports, one
+// line each, what it is sent and what happens to it:
+//
+//	ready 80x24          started, in a terminal of that size
+//	input "x\x1b[A"      read these bytes
+//	size 100x30          was resized
+//	signal interrupt     got a signal
+//
+// A ? read on its own makes it query the terminal's device attributes, and
+// a q exit. SIGTERM and SIGHUP make it exit as well, with 128 plus the signal's
+// number.
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/term"
+)
+
+func main() {
+	state, err := term.MakeRaw(0)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fakeclaude:", err)
+		os.Exit(1)
+	}
+	exit := func(code int) {
+		fmt.Print("\x1b[?2004l\x1b[?1004l")
+		_ = term.Restore(0, state)
+		os.Exit(code)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGWINCH, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for sig := range sigs {
+			if sig == syscall.SIGWINCH {
+				report("size")
+				continue
+			}
+			fmt.Printf("signal %v\r\n", sig)
+			if sig != syscall.SIGINT {
+				exit(128 + int(sig.(syscall.Signal)))
+			}
+		}
+	}()
+
+	fmt.Print("\x1b[?1004h\x1b[?2004h")
+	report("ready")
+	buf := make([]byte, 4096)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			exit(0)
+		}
+		fmt.Printf("input %q\r\n", buf[:n])
+		switch string(buf[:n]) {
+		case "?":
+			fmt.Print("\x1b[c")
+		case "q":
+			exit(0)
+		}
+	}
+}
+
+// report prints what, followed by the terminal's size.
+func report(what string) {
+	cols, rows, err := term.GetSize(1)
+	if err != nil {
+		fmt.Printf("%s unknown size: %v\r\n", what, err)
+		return
+	}
+	fmt.Printf("%s %dx%d\r\n", what, cols, rows)
+}
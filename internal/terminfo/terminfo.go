@@ -0,0 +1,232 @@
+// Package terminfo reads compiled terminfo entries, the terminal
+// descriptions ncurses keeps, so the wrapper can tell which of the
+// sequences the child writes the terminal understands.
+//
+// It reads both the legacy and the 32-bit formats, with the extended
+// capabilities (Sync, Smulx, Setulc and the like) that newer features are
+// described by. Of the standard capabilities, only the few the wrapper asks
+// about are kept.
+package terminfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Entry is a terminal's capabilities, by their short terminfo names:
+// numbers and strings with their values, and the booleans that are set.
+type Entry struct {
+	Names   []string
+	Bools   map[string]bool
+	Numbers map[string]int
+	Strings map[string]string
+}
+
+// Has reports whether e has the capability, of any type.
+func (e *Entry) Has(name string) bool {
+	_, num := e.Numbers[name]
+	_, str := e.Strings[name]
+	return e.Bools[name] || num || str
+}
+
+// The standard capabilities kept, by their place in the entry.
+var (
+	stdNumbers = map[int]string{0: "cols", 2: "lines", 13: "colors"}
+	stdStrings = map[int]string{311: "sitm", 321: "ritm"}
+)
+
+// Load finds and reads the entry for term, looking where ncurses does:
+// $TERMINFO, ~/.terminfo, $TERMINFO_DIRS and the system directories.
+func Load(term string) (*Entry, error) {
+	if term == "" || strings.ContainsAny(term, "/\\") || term[0] == '.' {
+		return nil, fmt.Errorf("bad terminal name %q", term)
+	}
+	var dirs []string
+	if dir := os.Getenv("TERMINFO"); dir != "" {
+		dirs = append(dirs, dir)
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, filepath.Join(home, ".terminfo"))
+	}
+	system := []string{"/etc/terminfo", "/lib/terminfo", "/usr/share/terminfo", "/usr/lib/terminfo", "/usr/local/share/terminfo"}
+	if list := os.Getenv("TERMINFO_DIRS"); list != "" {
+		for _, dir := range filepath.SplitList(list) {
+			if dir == "" {
+				dirs = append(dirs, system...) // an empty entry means the default
+			} else {
+				dirs = append(dirs, dir)
+			}
+		}
+	} else {
+		dirs = append(dirs, system...)
+	}
+	for _, dir := range dirs {
+		// Entries are filed under their first letter, or on macOS its
+		// code in hex
+		for _, sub := range []string{term[:1], fmt.Sprintf("%02x", term[0])} {
+			b, err := os.ReadFile(filepath.Join(dir, sub, term))
+			if err == nil {
+				return Parse(b)
+			}
+		}
+	}
+	return nil, fmt.Errorf("no terminfo entry for %q", term)
+}
+
+// Magic numbers starting an entry, whose numbers are 16 or 32 bits.
+const (
+	magic16 = 0o432
+	magic32 = 0o1036
+)
+
+var errShort = errors.New("terminfo entry is truncated")
+
+// Parse reads a compiled entry.
+func Parse(b []byte) (*Entry, error) {
+	r := &reader{b: b}
+	h := r.shorts(6)
+	if r.err != nil {
+		return nil, r.err
+	}
+	numSize := 2
+	switch h[0] {
+	case magic16:
+	case magic32:
+		numSize = 4
+	default:
+		return nil, errors.New("not a terminfo entry")
+	}
+	e := &Entry{Bools: map[string]bool{}, Numbers: map[string]int{}, Strings: map[string]string{}}
+	names := r.bytes(h[1])
+	e.Names = strings.Split(string(bytes.TrimRight(names, "\x00")), "|")
+	r.bytes(h[2]) // the standard booleans
+	r.align()
+	for i, n := range r.numbers(h[3], numSize) {
+		if name, ok := stdNumbers[i]; ok && n >= 0 {
+			e.Numbers[name] = n
+		}
+	}
+	offsets := r.shorts(h[4])
+	table := r.bytes(h[5])
+	for i, off := range offsets {
+		if name, ok := stdStrings[i]; ok {
+			if s, ok := cString(table, off); ok {
+				e.Strings[name] = s
+			}
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	r.align()
+	if r.off >= len(b) {
+		return e, nil // no extended capabilities
+	}
+	// The extended capabilities come with their names, kept after their
+	// string values in one table
+	x := r.shorts(5)
+	if r.err != nil {
+		return nil, r.err
+	}
+	bools := r.bytes(x[0])
+	r.align()
+	nums := r.numbers(x[1], numSize)
+	values := r.shorts(x[2])
+	nameOffs := r.shorts(x[0] + x[1] + x[2])
+	table = r.bytes(x[4])
+	if r.err != nil {
+		return nil, r.err
+	}
+	start := 0 // of the names
+	for _, off := range values {
+		if s, ok := cString(table, off); ok {
+			start = max(start, off+len(s)+1)
+		}
+	}
+	name := func(i int) string {
+		if i >= len(nameOffs) || nameOffs[i] < 0 {
+			return ""
+		}
+		s, _ := cString(table, start+nameOffs[i])
+		return s
+	}
+	for i, v := range bools {
+		if n := name(i); n != "" && v == 1 {
+			e.Bools[n] = true
+		}
+	}
+	for i, v := range nums {
+		if n := name(len(bools) + i); n != "" && v >= 0 {
+			e.Numbers[n] = v
+		}
+	}
+	for i, off := range values {
+		if n := name(len(bools) + len(nums) + i); n != "" {
+			if s, ok := cString(table, off); ok {
+				e.Strings[n] = s
+			}
+		}
+	}
+	return e, nil
+}
+
+// cString returns the NUL-terminated string at off in table, if off is a
+// real offset rather than an absent or cancelled capability.
+func cString(table []byte, off int) (string, bool) {
+	if off < 0 || off >= len(table) {
+		return "", false
+	}
+	s, _, ok := bytes.Cut(table[off:], []byte{0})
+	return string(s), ok
+}
+
+// reader reads an entry's little-endian fields in order, remembering the
+// first read past the end.
+type reader struct {
+	b   []byte
+	off int
+	err error
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || r.off+n > len(r.b) {
+		r.err = errShort
+		return nil
+	}
+	b := r.b[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *reader) shorts(n int) []int {
+	b := r.bytes(2 * n)
+	ints := make([]int, len(b)/2)
+	for i := range ints {
+		ints[i] = int(int16(binary.LittleEndian.Uint16(b[2*i:])))
+	}
+	return ints
+}
+
+func (r *reader) numbers(n, size int) []int {
+	if size == 2 {
+		return r.shorts(n)
+	}
+	b := r.bytes(4 * n)
+	ints := make([]int, len(b)/4)
+	for i := range ints {
+		ints[i] = int(int32(binary.LittleEndian.Uint32(b[4*i:])))
+	}
+	return ints
+}
+
+// align skips the padding byte that keeps what follows at an even offset.
+func (r *reader) align() {
+	if r.off%2 == 1 && r.off < len(r.b) {
+		r.off++
+	}
+}
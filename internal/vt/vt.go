@@ -0,0 +1,589 @@
+// Package vt keeps an in-memory model of a terminal screen, fed with the
+// same output the real terminal receives, so the wrapper can tell what is on
+// screen without scraping the raw byte stream.
+//
+// It models text and cursor movement only: enough of VT100 and xterm for
+// full-screen programs (cursor addressing, erasing, insert/delete, scroll
+// regions, the alternate screen) but no colors or other attributes.
+package vt
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/samuelstevens/claude-unfocused/internal/ansiparse"
+)
+
+// Screen is a terminal screen. It is safe for concurrent use.
+type Screen struct {
+	mu     sync.Mutex
+	parser ansiparse.Parser
+	cols   int
+	rows   int
+	main   [][]rune
+	alt    [][]rune
+	grid   [][]rune // main or alt
+	inAlt  bool     // grid is alt
+	x, y   int
+	wrap   bool // the last write filled the line; the next one wraps
+	top    int  // scroll region, inclusive
+	bottom int
+	saved  cursor
+	nowrap bool         // autowrap (DECAWM) is off
+	modes  map[int]bool // other DEC private modes the program has set or reset
+	tail   []byte
+
+	history    []string // lines scrolled off the top, oldest first
+	maxHistory int
+}
+
+type cursor struct{ x, y int }
+
+// New returns a blank screen of the given size.
+func New(cols, rows int) *Screen {
+	s := &Screen{}
+	s.reset(max(cols, 1), max(rows, 1))
+	return s
+}
+
+func (s *Screen) reset(cols, rows int) {
+	s.cols, s.rows = cols, rows
+	s.main, s.alt = blank(cols, rows), blank(cols, rows)
+	s.grid, s.inAlt = s.main, false
+	s.x, s.y, s.wrap = 0, 0, false
+	s.top, s.bottom = 0, rows-1
+	s.saved = cursor{}
+	s.nowrap = false
+	s.modes = nil
+}
+
+func blank(cols, rows int) [][]rune {
+	grid := make([][]rune, rows)
+	for i := range grid {
+		grid[i] = blankLine(cols)
+	}
+	return grid
+}
+
+func blankLine(cols int) []rune {
+	line := make([]rune, cols)
+	for i := range line {
+		line[i] = ' '
+	}
+	return line
+}
+
+// Write updates the screen with terminal output, so a Screen can sit in an
+// io.MultiWriter.
+func (s *Screen) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.parser.Feed(b, s.apply)
+	return len(b), nil
+}
+
+// Resize changes the screen size, keeping what fits.
+func (s *Screen) Resize(cols, rows int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cols, rows = max(cols, 1), max(rows, 1)
+	resize := func(grid [][]rune) [][]rune {
+		// Keep the bottom of the screen, where the cursor usually is
+		if len(grid) > rows {
+			grid = grid[len(grid)-rows:]
+		}
+		out := blank(cols, rows)
+		for i, line := range grid {
+			copy(out[i], line)
+		}
+		return out
+	}
+	shift := max(s.rows-rows, 0)
+	s.main, s.alt = resize(s.main), resize(s.alt)
+	s.grid = s.main
+	if s.inAlt {
+		s.grid = s.alt
+	}
+	s.cols, s.rows = cols, rows
+	s.x, s.y = min(s.x, cols-1), min(max(s.y-shift, 0), rows-1)
+	s.wrap = false
+	s.top, s.bottom = 0, rows-1
+}
+
+// Lines returns the screen's text, one string per row with trailing blanks
+// removed.
+func (s *Screen) Lines() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lines := make([]string, s.rows)
+	for i, line := range s.grid {
+		lines[i] = lineString(line)
+	}
+	return lines
+}
+
+func lineString(line []rune) string {
+	var b strings.Builder
+	for _, r := range line {
+		if r != 0 { // right half of a wide character
+			b.WriteRune(r)
+		}
+	}
+	return strings.TrimRight(b.String(), " ")
+}
+
+// SetHistory keeps up to n of the lines that scroll off the top of the
+// screen, for History; zero keeps none.
+func (s *Screen) SetHistory(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxHistory = max(n, 0)
+	s.history = s.keptHistory()
+}
+
+// History returns the lines that have scrolled off the top of the screen,
+// oldest first, with trailing blanks removed.
+func (s *Screen) History() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.keptHistory())
+}
+
+// keptHistory returns the last maxHistory lines of history. The caller
+// holds mu.
+func (s *Screen) keptHistory() []string {
+	return s.history[max(len(s.history)-s.maxHistory, 0):]
+}
+
+// String returns the screen's text with trailing blank lines removed.
+func (s *Screen) String() string {
+	lines := s.Lines()
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Cursor returns the cursor position, counting from zero.
+func (s *Screen) Cursor() (x, y int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.x, s.y
+}
+
+// Size returns the screen size.
+func (s *Screen) Size() (cols, rows int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cols, s.rows
+}
+
+// AltScreen reports whether the alternate screen is showing.
+func (s *Screen) AltScreen() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inAlt
+}
+
+// redrawModes are the DEC private modes Redraw restores, with their
+// power-on values: those changing how the terminal reports input, and
+// cursor visibility.
+var redrawModes = []struct {
+	mode int
+	on   bool
+}{
+	{1, false},    // application cursor keys
+	{25, true},    // cursor visible
+	{1000, false}, // mouse reporting
+	{1002, false},
+	{1003, false},
+	{1006, false},
+	{1004, false}, // focus reporting
+	{2004, false}, // bracketed paste
+}
+
+// Redraw returns output that repaints the screen on a terminal showing
+// something else: the right buffer, the text, the cursor and the input
+// modes the program has set. Colors and other attributes are not kept.
+func (s *Screen) Redraw() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var b strings.Builder
+	if s.inAlt {
+		b.WriteString("\x1b[?1049h")
+	} else {
+		b.WriteString("\x1b[?1049l")
+	}
+	b.WriteString("\x1b[0m\x1b[r\x1b[H\x1b[2J")
+	if s.nowrap {
+		b.WriteString("\x1b[?7l")
+	} else {
+		b.WriteString("\x1b[?7h")
+	}
+	for i, line := range s.grid {
+		fmt.Fprintf(&b, "\x1b[%d;1H", i+1)
+		end := len(line)
+		for end > 0 && line[end-1] == ' ' {
+			end--
+		}
+		for _, r := range line[:end] {
+			if r != 0 {
+				b.WriteRune(r)
+			}
+		}
+	}
+	for _, m := range redrawModes {
+		on, ok := s.modes[m.mode]
+		if !ok {
+			on = m.on
+		}
+		final := 'l'
+		if on {
+			final = 'h'
+		}
+		fmt.Fprintf(&b, "\x1b[?%d%c", m.mode, final)
+	}
+	if s.top != 0 || s.bottom != s.rows-1 {
+		fmt.Fprintf(&b, "\x1b[%d;%dr", s.top+1, s.bottom+1)
+	}
+	fmt.Fprintf(&b, "\x1b[%d;%dH", s.y+1, s.x+1)
+	return []byte(b.String())
+}
+
+func (s *Screen) apply(seq ansiparse.Sequence) {
+	switch seq.Kind {
+	case ansiparse.Text:
+		s.text(seq.Raw)
+	case ansiparse.Control:
+		s.control(seq.Raw[0])
+	case ansiparse.Esc:
+		s.esc(seq)
+	case ansiparse.CSI:
+		s.csi(seq)
+	}
+}
+
+func (s *Screen) text(b []byte) {
+	if len(s.tail) > 0 {
+		// Finish a character split across writes
+		b = append(s.tail, b...)
+		s.tail = nil
+	}
+	for len(b) > 0 {
+		r, n := utf8.DecodeRune(b)
+		if r == utf8.RuneError && n == 1 && !utf8.FullRune(b) {
+			s.tail = append([]byte(nil), b...)
+			return
+		}
+		b = b[n:]
+		if unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf) {
+			continue // combining and format characters take no cell
+		}
+		s.put(r)
+	}
+}
+
+// put writes r at the cursor, wrapping first if the last write filled the
+// line. Wide characters take two cells; the second holds 0.
+func (s *Screen) put(r rune) {
+	width := 1
+	if IsWide(r) {
+		width = 2
+	}
+	if s.wrap || s.x+width > s.cols {
+		if s.nowrap {
+			s.x = max(s.cols-width, 0)
+		} else {
+			s.x = 0
+			s.lineFeed()
+		}
+		s.wrap = false
+	}
+	line := s.grid[s.y]
+	line[s.x] = r
+	if width == 2 && s.x+1 < s.cols {
+		line[s.x+1] = 0
+	}
+	s.x += width
+	if s.x >= s.cols {
+		s.x = s.cols - 1
+		s.wrap = true
+	}
+}
+
+func (s *Screen) control(c byte) {
+	switch c {
+	case '\r':
+		s.x, s.wrap = 0, false
+	case '\n', '\v', '\f':
+		s.lineFeed()
+	case '\b':
+		if s.x > 0 {
+			s.x--
+		}
+		s.wrap = false
+	case '\t':
+		s.x = min((s.x/8+1)*8, s.cols-1)
+	}
+}
+
+// lineFeed moves the cursor down, scrolling the region at its bottom.
+func (s *Screen) lineFeed() {
+	s.wrap = false
+	if s.y == s.bottom {
+		s.scrollUp(1)
+	} else if s.y < s.rows-1 {
+		s.y++
+	}
+}
+
+// reverseLineFeed moves the cursor up, scrolling the region at its top.
+func (s *Screen) reverseLineFeed() {
+	s.wrap = false
+	if s.y == s.top {
+		s.scrollDown(1)
+	} else if s.y > 0 {
+		s.y--
+	}
+}
+
+// scrollUp moves the scroll region's lines up by n, blanking the bottom.
+// Lines leaving the top of the screen go to the history.
+func (s *Screen) scrollUp(n int) {
+	if s.top == 0 && s.maxHistory > 0 {
+		for _, line := range s.grid[:min(n, s.bottom+1)] {
+			s.history = append(s.history, lineString(line))
+		}
+		// Trim in batches rather than on every line
+		if len(s.history) >= 2*s.maxHistory {
+			s.history = slices.Clone(s.keptHistory())
+		}
+	}
+	s.deleteLines(s.top, n)
+}
+
+// scrollDown moves the scroll region's lines down by n, blanking the top.
+func (s *Screen) scrollDown(n int) {
+	s.insertLines(s.top, n)
+}
+
+// insertLines inserts n blank lines at row y, pushing the rest of the scroll
+// region down.
+func (s *Screen) insertLines(y, n int) {
+	if y < s.top || y > s.bottom {
+		return
+	}
+	n = min(n, s.bottom-y+1)
+	region := s.grid[y : s.bottom+1]
+	copy(region[n:], region[:len(region)-n])
+	for i := range n {
+		region[i] = blankLine(s.cols)
+	}
+}
+
+// deleteLines deletes n lines at row y, pulling the rest of the scroll
+// region up.
+func (s *Screen) deleteLines(y, n int) {
+	if y < s.top || y > s.bottom {
+		return
+	}
+	n = min(n, s.bottom-y+1)
+	region := s.grid[y : s.bottom+1]
+	copy(region, region[n:])
+	for i := len(region) - n; i < len(region); i++ {
+		region[i] = blankLine(s.cols)
+	}
+}
+
+func (s *Screen) esc(seq ansiparse.Sequence) {
+	if len(seq.Intermediates) > 0 {
+		return
+	}
+	switch seq.Final {
+	case '7': // DECSC
+		s.saved = cursor{s.x, s.y}
+	case '8': // DECRC
+		s.restoreCursor()
+	case 'D': // IND
+		s.lineFeed()
+	case 'E': // NEL
+		s.x = 0
+		s.lineFeed()
+	case 'M': // RI
+		s.reverseLineFeed()
+	case 'c': // RIS
+		s.reset(s.cols, s.rows)
+	}
+}
+
+func (s *Screen) csi(seq ansiparse.Sequence) {
+	if len(seq.Intermediates) > 0 {
+		return
+	}
+	args := seq.Ints()
+	// arg returns parameter i, or def if it is missing or zero
+	arg := func(i, def int) int {
+		if i < len(args) && args[i] > 0 {
+			return args[i]
+		}
+		return def
+	}
+	if seq.Private() == '?' {
+		if seq.Final == 'h' || seq.Final == 'l' {
+			for _, mode := range args {
+				s.decMode(mode, seq.Final == 'h')
+			}
+		}
+		return
+	}
+	if seq.Private() != 0 {
+		return
+	}
+	s.wrap = false
+	switch seq.Final {
+	case 'A': // CUU
+		s.y = max(s.y-arg(0, 1), 0)
+	case 'B', 'e': // CUD, VPR
+		s.y = min(s.y+arg(0, 1), s.rows-1)
+	case 'C', 'a': // CUF, HPR
+		s.x = min(s.x+arg(0, 1), s.cols-1)
+	case 'D': // CUB
+		s.x = max(s.x-arg(0, 1), 0)
+	case 'E': // CNL
+		s.x, s.y = 0, min(s.y+arg(0, 1), s.rows-1)
+	case 'F': // CPL
+		s.x, s.y = 0, max(s.y-arg(0, 1), 0)
+	case 'G', '`': // CHA, HPA
+		s.x = min(arg(0, 1), s.cols) - 1
+	case 'H', 'f': // CUP, HVP
+		s.y, s.x = min(arg(0, 1), s.rows)-1, min(arg(1, 1), s.cols)-1
+	case 'd': // VPA
+		s.y = min(arg(0, 1), s.rows) - 1
+	case 'J': // ED
+		s.eraseDisplay(arg(0, 0))
+	case 'K': // EL
+		s.eraseLine(arg(0, 0))
+	case '@': // ICH
+		line := s.grid[s.y][s.x:]
+		n := min(arg(0, 1), len(line))
+		copy(line[n:], line)
+		fill(line[:n])
+	case 'P': // DCH
+		line := s.grid[s.y][s.x:]
+		n := min(arg(0, 1), len(line))
+		copy(line, line[n:])
+		fill(line[len(line)-n:])
+	case 'X': // ECH
+		line := s.grid[s.y][s.x:]
+		fill(line[:min(arg(0, 1), len(line))])
+	case 'L': // IL
+		s.insertLines(s.y, arg(0, 1))
+		s.x = 0
+	case 'M': // DL
+		s.deleteLines(s.y, arg(0, 1))
+		s.x = 0
+	case 'S': // SU
+		s.scrollUp(arg(0, 1))
+	case 'T': // SD
+		s.scrollDown(arg(0, 1))
+	case 'r': // DECSTBM
+		top, bottom := arg(0, 1)-1, min(arg(1, s.rows), s.rows)-1
+		if top < bottom {
+			s.top, s.bottom = top, bottom
+			s.x, s.y = 0, 0
+		}
+	case 's': // SCOSC
+		s.saved = cursor{s.x, s.y}
+	case 'u': // SCORC
+		s.restoreCursor()
+	}
+}
+
+// restoreCursor moves the cursor back where it was saved, within the
+// screen's current size.
+func (s *Screen) restoreCursor() {
+	s.x, s.y = min(s.saved.x, s.cols-1), min(s.saved.y, s.rows-1)
+	s.wrap = false
+}
+
+func (s *Screen) decMode(mode int, set bool) {
+	switch mode {
+	case 7: // DECAWM
+		s.nowrap = !set
+	case 47, 1047, 1049: // alternate screen
+		if mode == 1049 && set {
+			s.saved = cursor{s.x, s.y}
+		}
+		if set && !s.inAlt {
+			s.alt = blank(s.cols, s.rows)
+			s.grid, s.inAlt = s.alt, true
+		} else if !set {
+			s.grid, s.inAlt = s.main, false
+		}
+		if mode == 1049 && !set {
+			s.restoreCursor()
+		}
+		s.wrap = false
+	default:
+		if s.modes == nil {
+			s.modes = make(map[int]bool)
+		}
+		s.modes[mode] = set
+	}
+}
+
+func (s *Screen) eraseDisplay(mode int) {
+	switch mode {
+	case 0: // cursor to end
+		fill(s.grid[s.y][s.x:])
+		for _, line := range s.grid[s.y+1:] {
+			fill(line)
+		}
+	case 1: // start to cursor
+		for _, line := range s.grid[:s.y] {
+			fill(line)
+		}
+		fill(s.grid[s.y][:s.x+1])
+	case 2, 3: // everything
+		for _, line := range s.grid {
+			fill(line)
+		}
+	}
+}
+
+func (s *Screen) eraseLine(mode int) {
+	line := s.grid[s.y]
+	switch mode {
+	case 0:
+		fill(line[s.x:])
+	case 1:
+		fill(line[:s.x+1])
+	case 2:
+		fill(line)
+	}
+}
+
+func fill(cells []rune) {
+	for i := range cells {
+		cells[i] = ' '
+	}
+}
+
+// IsWide reports whether r takes two cells: East Asian wide characters and
+// emoji, by their main Unicode blocks.
+func IsWide(r rune) bool {
+	return r >= 0x1100 && (r <= 0x115f || // Hangul Jamo
+		r >= 0x2e80 && r <= 0xa4cf && r != 0x303f || // CJK ... Yi
+		r >= 0xac00 && r <= 0xd7a3 || // Hangul syllables
+		r >= 0xf900 && r <= 0xfaff || // CJK compatibility ideographs
+		r >= 0xfe30 && r <= 0xfe4f || // CJK compatibility forms
+		r >= 0xff00 && r <= 0xff60 || // fullwidth forms
+		r >= 0xffe0 && r <= 0xffe6 ||
+		r >= 0x1f300 && r <= 0x1f64f || // emoji
+		r >= 0x1f900 && r <= 0x1f9ff ||
+		r >= 0x20000 && r <= 0x3fffd)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// lifecycle runs the [hooks] commands through the shell as a session
+// starts, exits, and is attached to or detached from. Each command waits
+// for the last and gets the session's details in its environment:
+//
+//	CLAUDE_UNFOCUSED_HOOK          which hook is running
+//	CLAUDE_UNFOCUSED_SESSION_NAME  the background session's name, if any
+//	CLAUDE_UNFOCUSED_COMMAND       the child's command line, shell-quoted
+//	CLAUDE_UNFOCUSED_WORKDIR       the working directory
+//	CLAUDE_UNFOCUSED_CHILD_PID     the child's process ID, once it has one
+//	CLAUDE_UNFOCUSED_EXIT_CODE     the child's exit code, in post_exit
+//
+// A nil *lifecycle runs nothing.
+type lifecycle struct {
+	hooks hookConfig
+	env   []string
+}
+
+// newLifecycle returns the hooks for the session running argv, or nil if
+// none are set.
+func newLifecycle(hooks hookConfig, session string, argv []string) *lifecycle {
+	if hooks == (hookConfig{}) {
+		return nil
+	}
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		quoted[i] = shellQuote(arg)
+	}
+	dir, _ := os.Getwd()
+	return &lifecycle{hooks: hooks, env: []string{
+		"CLAUDE_UNFOCUSED_SESSION_NAME=" + session,
+		"CLAUDE_UNFOCUSED_COMMAND=" + strings.Join(quoted, " "),
+		"CLAUDE_UNFOCUSED_WORKDIR=" + dir,
+	}}
+}
+
+// run runs the hook name, if it is set, with the child's pid (if known) and
+// any more KEY=VAL pairs in extra. Its output goes to stderr.
+func (l *lifecycle) run(name string, pid int, extra ...string) error {
+	if l == nil {
+		return nil
+	}
+	command := map[string]string{
+		"pre_start": l.hooks.PreStart,
+		"post_exit": l.hooks.PostExit,
+		"on_attach": l.hooks.OnAttach,
+		"on_detach": l.hooks.OnDetach,
+	}[name]
+	if command == "" {
+		return nil
+	}
+	cmd := shellCommand(command)
+	cmd.Env = append(os.Environ(), l.env...)
+	cmd.Env = append(cmd.Env, "CLAUDE_UNFOCUSED_HOOK="+name)
+	if pid > 0 {
+		cmd.Env = append(cmd.Env, "CLAUDE_UNFOCUSED_CHILD_PID="+strconv.Itoa(pid))
+	}
+	cmd.Env = append(cmd.Env, extra...)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s hook: %w", name, err)
+	}
+	return nil
+}
+
+// exited runs post_exit for a child that exited with code, warning if it
+// fails.
+func (l *lifecycle) exited(pid, code int) {
+	if err := l.run("post_exit", pid, "CLAUDE_UNFOCUSED_EXIT_CODE="+strconv.Itoa(code)); err != nil {
+		log.Printf("warning: %v", err)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stateDir returns the directory for the wrapper's logs, following the XDG
+// base directory spec.
+func stateDir() (string, error) {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	dir = filepath.Join(dir, "claude-unfocused")
+	return dir, os.MkdirAll(dir, 0o700)
+}
+
+// logFormats are the accepted values of log.format: text logs warnings as
+// lines of text; json logs them as JSON objects, along with the session's
+// events.
+var logFormats = map[string]bool{"text": true, "json": true}
+
+// startLog sets up the wrapper's logging. Warnings go to messages, and when
+// cfg.Enabled to a log file as well: cfg.File if set, or else one for this
+// session under stateDir, named after name and the wrapper's PID, where logs
+// older than cfg.MaxAge are removed first. In the json format the session's
+// events are logged too, to the log file alone, which the format turns on so
+// they stay out of the terminal. startLog returns the event log, nil unless
+// the format is json, and the log file, nil unless one was opened.
+func startLog(cfg logConfig, name string) (*eventLog, *rotatingFile, error) {
+	var f *rotatingFile
+	if cfg.Enabled || cfg.Format == "json" {
+		path := cfg.File
+		if path == "" {
+			dir, err := stateDir()
+			if err != nil {
+				return nil, nil, err
+			}
+			if cfg.MaxAge > 0 {
+				pruneLogs(dir, cfg.MaxAge)
+			}
+			path = filepath.Join(dir, fmt.Sprintf("%s-%d.log", strings.TrimLeft(name, "."), os.Getpid()))
+		}
+		var err error
+		f, err = openRotating(path, int64(cfg.MaxSize)<<20, cfg.MaxFiles)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	messages.mode = cfg.Messages
+	var w io.Writer = messages
+	if f != nil {
+		w = io.MultiWriter(messages, f)
+	}
+	if cfg.Format != "json" {
+		log.SetOutput(w)
+		return nil, f, nil
+	}
+	id := newSessionID()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(w, nil)).With("session_id", id, "session", name))
+	return newEventLog(f, id, name), f, nil
+}
+
+// pruneLogs removes logs, rotated ones included, last written before maxAge
+// ago.
+func pruneLogs(dir string, maxAge time.Duration) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if !strings.Contains(e.Name(), ".log") {
+			continue
+		}
+		if info, err := e.Info(); err == nil && time.Since(info.ModTime()) > maxAge {
+			_ = os.Remove(filepath.Join(dir, e.Name()))
+		}
+	}
+}
+
+// rotatingFile is a log file that is rotated once it would grow past
+// maxSize: path becomes path.1, path.1 becomes path.2 and so on, keeping
+// keep old files. A maxSize of zero never rotates. A nil *rotatingFile does
+// nothing.
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	keep    int
+	f       *os.File
+	size    int64
+}
+
+func openRotating(path string, maxSize int64, keep int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, keep: keep}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return 0, os.ErrClosed
+	}
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(b)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(b)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate moves the current file aside and starts a new one.
+func (r *rotatingFile) rotate() error {
+	_ = r.f.Close()
+	r.f = nil
+	_ = os.Remove(fmt.Sprintf("%s.%d", r.path, r.keep))
+	for i := r.keep - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	if r.keep > 0 {
+		_ = os.Rename(r.path, r.path+".1")
+	} else {
+		_ = os.Remove(r.path)
+	}
+	return r.open()
+}
+
+// rotateNow rotates the file whatever its size, for the rotate-logs signal
+// action.
+func (r *rotatingFile) rotateNow() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return os.ErrClosed
+	}
+	return r.rotate()
+}
+
+func (r *rotatingFile) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
@@ -1,233 +1,1460 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"maps"
 	"os"
 	"os/exec"
-	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
-	"syscall"
 	"time"
+	"unicode/utf8"
 
-	"github.com/creack/pty"
+	"filippo.io/age"
+	"github.com/samuelstevens/claude-unfocused/internal/terminfo"
+	"github.com/samuelstevens/claude-unfocused/internal/vt"
+	"github.com/samuelstevens/claude-unfocused/pkg/escfilter"
+	"github.com/samuelstevens/claude-unfocused/pkg/ptyproxy"
 	"github.com/spf13/pflag"
 	"golang.org/x/term"
 )
 
 const (
-	esc           = 0x1b
-	ctrlZ         = 0x1a
-	ctrlBackslash = 0x1c
 	escTimeout    = 50 * time.Millisecond
+	quitTimeout   = 3 * time.Second
+	pasteDelay    = 10 * time.Millisecond
+	inputBuffer   = 4 << 10
+	outputBuffer  = 64 << 10
+	coalesceDelay = 500 * time.Microsecond
+	// scrollbackLines is how many lines scrolled off the screen are kept
+	// for searching by --scrollback without a value
+	scrollbackLines = 10000
 )
 
-type controlSignal int
-
+// Hotkey actions handled by the wrapper.
 const (
-	sigNone controlSignal = iota
-	sigSuspend
-	sigQuit
+	actionSuspend escfilter.Action = iota + 1
+	actionQuit
+	actionToggleFilter
+	actionToggleStatus
+	actionNewSession
+	actionNextSession
+	actionPrevSession
+	actionSearch
+	actionCopyMode
+	actionWheelUp
+	actionWheelDown
+	actionDumpScreen
+	actionRotateLogs
+	actionDetach
+	actionPalette
+	actionHelp
+	actionTimeLimit
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// run starts the wrapped command and proxies it until it exits, returning
+// the command's exit code.
+func run() int {
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		return runReplay(os.Args[2:])
+	}
+	if len(os.Args) > 1 && os.Args[1] == "ls" {
+		return runList(os.Args[2:])
+	}
+	if len(os.Args) > 1 && os.Args[1] == "kill" {
+		return runKill(os.Args[2:])
+	}
+	if len(os.Args) > 1 && os.Args[1] == "audit" {
+		return runAudit(os.Args[2:])
+	}
+	if len(os.Args) > 1 && os.Args[1] == "version" {
+		fmt.Print(versionString())
+		return 0
+	}
+
+	rawArgs := os.Args[1:]
+	attach := len(rawArgs) > 0 && rawArgs[0] == "attach"
+	if attach {
+		rawArgs = rawArgs[1:]
+	}
+
 	// Use custom FlagSet to avoid automatic --help handling (let it pass through to claude)
 	fs := pflag.NewFlagSet("claude-unfocused", pflag.ContinueOnError)
 	fs.SetOutput(io.Discard)
 	fs.ParseErrorsWhitelist.UnknownFlags = true
+	configFile := fs.String("config", "", "path to config file")
+	profile := fs.String("profile", "", "lay the named profile under [profiles] in the config file over the rest")
 	target := fs.String("claude", "claude", "path to claude binary")
-	_ = fs.Parse(os.Args[1:])
-
-	// Collect args to pass through (pflag drops unknown flags, so reconstruct manually)
-	args := passthroughArgs(os.Args[1:])
-
-	cmd := exec.Command(*target, args...)
+	filterMouse := fs.Bool("filter-mouse", false, "swallow mouse reports from input")
+	forceFocused := fs.Bool("force-focused", false, "make the child always believe it has focus")
+	stripFocusMode := fs.Bool("strip-focus-mode", false, "keep the child from turning on focus reporting in the terminal")
+	shellIntegration := fs.String("shell-integration", "pass", "what to do with OSC 133 and OSC 7 from shells claude runs: pass, strip or rewrite")
+	recordFile := fs.String("record", "", "record the session to an asciicast file")
+	transcriptFile := fs.String("transcript", "", "write the session's output as plain text to a file")
+	noFilter := fs.Bool("no-filter", false, "pass input straight through, with no focus filter, key bindings or ESC timeout, to rule the wrapper out")
+	noDefaults := fs.Bool("no-defaults", false, "don't prepend the config file's args to claude's arguments")
+	noArchive := fs.Bool("no-archive", false, "don't archive the session's transcript")
+	archiveDir := fs.String("archive-dir", "", "archive session transcripts here, by day (default: archive/ in the state directory)")
+	teeFile := fs.String("tee", "", "copy the session's output, live, to a file or named pipe")
+	teeFormat := fs.String("tee-format", "raw", "what --tee writes: raw output or plain text")
+	recordInput := fs.Bool("record-input", false, "include input in the recording")
+	redact := fs.Bool("redact", false, "mask secrets (API keys, tokens) in the recording, transcript and trace")
+	recordEncrypt := fs.String("record-encrypt", "", "encrypt the recording to this age public key, or the keys in this file")
+	detach := fs.Bool("detach", false, "run the child in a background session that can be reattached")
+	sessionName := fs.String("session", "", "name of the background session")
+	control := fs.Bool("control", false, "open a control socket for scripting the session")
+	outputLogFile := fs.String("log-output", "", "write the child's raw output to a file, with timestamps, for reconstructing the session")
+	stderrLog := fs.String("stderr-log", "", "send the child's stderr to a file instead of the terminal")
+	auditFile := fs.String("audit", "", "append each line of input sent to the child to a hash-chained log")
+	traceFile := fs.String("trace", "", "log escape sequences and filter decisions to a file")
+	clipboard := fs.String("clipboard", "allow", "what to do with OSC 52 clipboard writes: allow, block, log or native")
+	downgrade := fs.Bool("downgrade", false, "drop or rewrite what the terminal's terminfo entry doesn't list, such as synchronized output and styled underlines")
+	termFlag := fs.String("term", "", "set TERM for claude, instead of the terminal's")
+	colortermFlag := fs.String("colorterm", "", "set COLORTERM for claude (with --term, it is otherwise removed)")
+	charsetFlag := fs.String("charset", "utf-8", "the terminal's character set, converted to and from claude's: utf-8, latin1, cp1252 or ascii")
+	childCharset := fs.String("child-charset", "utf-8", "claude's character set, if not UTF-8")
+	crlf := fs.Bool("crlf", false, "end each line claude writes with CR LF, for a transport that doesn't")
+	lf := fs.Bool("lf", false, "end each line claude writes with a bare LF, for a transport that adds the CR")
+	enter := fs.String("enter", "cr", "what the Enter key sends claude: cr, lf or crlf")
+	colorFlag := fs.String("color", "auto", "tell claude to use color or not, through its environment: auto, always or never")
+	stripColorFlag := fs.Bool("strip-color", false, "remove colors from claude's output, keeping bold, underline and the like")
+	downgradeColorFlag := fs.String("downgrade-color", "", "bring 24-bit colors down to the nearest of 256, or 16")
+	fs.Lookup("downgrade-color").NoOptDefVal = "256"
+	graphics := fs.String("graphics", "allow", "what to do with inline images (sixel, OSC 1337 File, Kitty graphics): allow or block")
+	titleMode := fs.String("title-mode", "pass", "what to do with titles the child sets: pass, block or rewrite")
+	title := fs.String("title", "", "keep the window title set from this template ({dir}, {session}, {status})")
+	bell := fs.String("bell", "pass", "what a bell from the child does: pass, notify, both or none")
+	onBell := fs.String("on-bell", "", "shell command to run when the child rings the bell")
+	setEnv := fs.StringArray("env", nil, "set KEY=VAL in the child's environment (repeatable)")
+	unsetEnv := fs.StringArray("unset-env", nil, "remove KEY from the child's environment (repeatable)")
+	restartOnCrash := fs.Int("restart-on-crash", 0, "restart the child up to N times in a row if it crashes")
+	fs.Lookup("restart-on-crash").NoOptDefVal = "5"
+	restartContinue := fs.Bool("restart-continue", false, "pass --continue to claude when restarting it")
+	notifyIdle := fs.Duration("notify-idle", 0, "notify when the child has been quiet this long after output (0 to disable)")
+	escTimeoutFlag := fs.Duration("esc-timeout", escTimeout, "how long to wait after ESC before forwarding it as a keypress (0 to forward at once)")
+	maxSessionTime := fs.Duration("max-session-time", 0, "end the session gracefully after this long, as the quit key does (0 for no limit)")
+	sessionTimeWarningFlag := fs.Duration("session-time-warning", sessionTimeWarning, "warn this long before --max-session-time runs out (0 not to)")
+	quitTimeoutFlag := fs.Duration("quit-timeout", quitTimeout, "how long the quit key waits after SIGTERM before killing the child (0 to kill at once)")
+	resizePoll := fs.Duration("resize-poll", 0, "also check the terminal size this often, where SIGWINCH doesn't arrive (0 to rely on it)")
+	pasteChunk := fs.Int("paste-chunk", 0, "send pastes to the child this many bytes at a time (0 to send them whole)")
+	pasteDelayFlag := fs.Duration("paste-delay", pasteDelay, "pause between paste chunks")
+	pasteWrap := fs.Int("paste-wrap", 0, "bracket input bursts of at least N bytes as a paste, for terminals that don't")
+	fs.Lookup("paste-wrap").NoOptDefVal = "256"
+	inputBufferFlag := fs.Int("input-buffer", inputBuffer, "read terminal input this many bytes at a time")
+	outputBufferFlag := fs.Int("output-buffer", outputBuffer, "read claude's output this many bytes at a time")
+	coalesce := fs.Duration("coalesce", coalesceDelay, "hold output back this long to write it with what follows (0 to write at once)")
+	openDirs := fs.StringArray("open", nil, "also start a session in DIR, switched to with ctrl-] n (repeatable)")
+	outputPipes := fs.StringArray("output-pipe", nil, "run claude's output through a shell command on its way to the terminal (repeatable)")
+	statusLineFlag := fs.Bool("status-line", false, "show a status line on the bottom row")
+	promptMarks := fs.Bool("prompt-marks", false, "mark claude's turns with OSC 133 for terminals that jump between prompts")
+	quitKey := fs.String("quit-key", `ctrl-\`, "key that quits claude (\"\" to pass it through)")
+	suspendKey := fs.String("suspend-key", "ctrl-z", "key that suspends the wrapper (\"\" to pass it through)")
+	noInterceptSuspend := fs.Bool("no-intercept-suspend", false, "pass ctrl-z to claude instead of suspending; ctrl-] z still suspends")
+	scrollbackFlag := fs.Int("scrollback", 0, "keep the last N lines that scrolled off claude's screen for searching")
+	fs.Lookup("scrollback").NoOptDefVal = strconv.Itoa(scrollbackLines)
+	wheelScroll := fs.Bool("wheel-scroll", false, "scroll the wrapper's scrollback with the mouse wheel while claude is on the alternate screen")
+	stallTimeout := fs.Duration("stall-timeout", 0, "act when the child has had no output or input this long (0 to disable)")
+	stallAction := fs.String("stall-action", "notify", "what to do about a stalled child: notify, log, kill or restart")
+	sshHost := fs.String("ssh", "", "run claude on this host over ssh, filtering input locally")
+	dockerContainer := fs.String("docker", "", "run claude in this running docker container")
+	podmanContainer := fs.String("podman", "", "run claude in this running podman container")
+	useTmux := fs.Bool("tmux", false, "run in a tmux session named by --session instead of a --detach session")
+	logFile := fs.String("log-file", "", "also write warnings to this file (rotated by the [log] settings)")
+	logFormat := fs.String("log-format", "text", "how to log: text, or json to log the session's events too")
+	messagesFlag := fs.String("messages", "terminal", "where warnings go: terminal, log (only the log file, turned on for them) or none")
+	usageFlag := fs.Bool("usage", false, "track the tokens and cost of claude's replies, on the status line and on exit")
+	quiet := fs.Bool("quiet", false, "show no warnings, only fatal errors (same as --messages none)")
+	cacheReplies := fs.Bool("cache-replies", false, "answer repeated terminal queries (device attributes, colors) from the terminal's first reply")
+	metricsAddr := fs.String("metrics-addr", "", "serve Prometheus metrics on this address, such as localhost:9090")
+	preStart := fs.String("pre-start", "", "shell command to run before the child starts; the session isn't started if it fails")
+	postExit := fs.String("post-exit", "", "shell command to run after the child exits")
+	onAttach := fs.String("on-attach", "", "shell command to run on attaching to a background session")
+	onDetach := fs.String("on-detach", "", "shell command to run on detaching from a background session")
+	permissionNotify := fs.Bool("permission-notify", false, "send a desktop notification when the child asks for permission to use a tool")
+	permissionBell := fs.Bool("permission-bell", false, "ring the terminal's bell when the child asks for permission to use a tool")
+	expectDryRun := fs.Bool("expect-dry-run", false, "only log what the [[expect]] rules would send")
+	webhookURL := fs.String("webhook", "", "post session events (waiting for input, exit, crash) as JSON to this URL")
+	webhookFormat := fs.String("webhook-format", "generic", "shape of the webhook's JSON: generic, slack or discord")
+	artifacts := fs.String("artifacts", "", "where relative --record, --transcript, --trace and --audit paths go: project or state")
+	scriptFile := fs.String("script", "", "run the hooks in this Starlark file")
+	pprofAddr := fs.String("pprof-addr", "", "serve net/http/pprof on this address, such as localhost:6060")
+	showVersion := fs.Bool("version", false, "print the wrapper's version and exit")
+	if len(rawArgs) > 0 && rawArgs[0] == "completion" {
+		return runCompletion(fs, rawArgs[1:])
+	}
+	_ = fs.Parse(rawArgs)
+	if *showVersion {
+		fmt.Print(versionString())
+		return 0
+	}
+	if err := applyFlagEnv(fs); err != nil {
+		log.Fatalf("%v", err)
+	}
 
-	ptmx, err := pty.Start(cmd)
+	// Layer settings: defaults, then config file, then flags
+	path := *configFile
+	if path == "" {
+		path = findConfig()
+	}
+	cfg, err := loadConfig(path, *profile)
 	if err != nil {
-		log.Fatalf("failed to start PTY: %v", err)
+		log.Fatalf("failed to load config: %v", err)
+	}
+	if fs.Changed("log-file") {
+		cfg.Log.Enabled, cfg.Log.File = true, *logFile
+	}
+	if fs.Changed("log-format") {
+		if !logFormats[*logFormat] {
+			log.Fatalf("unknown --log-format %q", *logFormat)
+		}
+		cfg.Log.Format = *logFormat
+	}
+	if fs.Changed("messages") {
+		if !messageModes[*messagesFlag] {
+			log.Fatalf("unknown --messages %q", *messagesFlag)
+		}
+		cfg.Log.Messages = *messagesFlag
+	}
+	if *quiet {
+		cfg.Log.Messages = "none"
+	}
+	if cfg.Log.Messages == "log" {
+		cfg.Log.Enabled = true
+	}
+	events, logs, err := startLog(cfg.Log, statusName(*sessionName))
+	if err != nil {
+		log.Fatalf("failed to open log file: %v", err)
+	}
+	if logs != nil {
+		defer func() { _ = logs.Close() }()
+	}
+	if *pprofAddr != "" {
+		ln, err := servePprof(*pprofAddr)
+		if err != nil {
+			log.Printf("warning: could not serve pprof: %v", err)
+		} else {
+			defer func() { _ = ln.Close() }()
+		}
+	}
+	if fs.Changed("claude") {
+		cfg.Claude = *target
+	}
+	if fs.Changed("filter-mouse") {
+		cfg.Filter.Mouse = *filterMouse
+	}
+	if fs.Changed("force-focused") {
+		cfg.Filter.ForceFocused = *forceFocused
+	}
+	if fs.Changed("strip-focus-mode") {
+		cfg.Filter.StripFocusMode = *stripFocusMode
+	}
+	if fs.Changed("downgrade") {
+		cfg.Downgrade = *downgrade
+	}
+	if fs.Changed("term") {
+		cfg.Term = *termFlag
+	}
+	if fs.Changed("colorterm") {
+		cfg.Colorterm = *colortermFlag
+	}
+	if fs.Changed("charset") {
+		if cfg.Charset.Terminal = charsetName(*charsetFlag); cfg.Charset.Terminal == "" {
+			log.Fatalf("unknown --charset %q", *charsetFlag)
+		}
+	}
+	if fs.Changed("child-charset") {
+		if cfg.Charset.Child = charsetName(*childCharset); cfg.Charset.Child == "" {
+			log.Fatalf("unknown --child-charset %q", *childCharset)
+		}
+	}
+	if *crlf && *lf {
+		log.Fatalf("--crlf can't be combined with --lf")
+	}
+	if fs.Changed("crlf") || fs.Changed("lf") {
+		switch {
+		case *crlf:
+			cfg.Newline.Output = "crlf"
+		case *lf:
+			cfg.Newline.Output = "lf"
+		default:
+			cfg.Newline.Output = ""
+		}
+	}
+	if fs.Changed("enter") {
+		if _, ok := enterKeys[*enter]; !ok {
+			log.Fatalf("unknown --enter %q", *enter)
+		}
+		cfg.Newline.Enter = *enter
+	}
+	if fs.Changed("color") {
+		if !colorModes[*colorFlag] {
+			log.Fatalf("unknown --color mode %q", *colorFlag)
+		}
+		cfg.Color = *colorFlag
+	}
+	if fs.Changed("strip-color") {
+		cfg.StripColor = *stripColorFlag
+	}
+	if fs.Changed("downgrade-color") {
+		if *downgradeColorFlag != "" && !colorDepths[*downgradeColorFlag] {
+			log.Fatalf("--downgrade-color must be 256 or 16, not %q", *downgradeColorFlag)
+		}
+		cfg.DowngradeColor = *downgradeColorFlag
+	}
+	if fs.Changed("graphics") {
+		if !graphicsModes[*graphics] {
+			log.Fatalf("unknown --graphics mode %q", *graphics)
+		}
+		cfg.Graphics = *graphics
+	}
+	if fs.Changed("shell-integration") {
+		if !shellIntegrationModes[*shellIntegration] {
+			log.Fatalf("unknown --shell-integration %q", *shellIntegration)
+		}
+		cfg.Filter.ShellIntegration = *shellIntegration
+	}
+	if fs.Changed("control") {
+		cfg.Control = *control
+	}
+	if fs.Changed("cache-replies") {
+		cfg.CacheReplies = *cacheReplies
+	}
+	if fs.Changed("metrics-addr") {
+		cfg.MetricsAddr = *metricsAddr
+	}
+	if fs.Changed("pre-start") {
+		cfg.Hooks.PreStart = *preStart
+	}
+	if fs.Changed("post-exit") {
+		cfg.Hooks.PostExit = *postExit
+	}
+	if fs.Changed("on-attach") {
+		cfg.Hooks.OnAttach = *onAttach
+	}
+	if fs.Changed("on-detach") {
+		cfg.Hooks.OnDetach = *onDetach
+	}
+	if fs.Changed("permission-notify") {
+		cfg.Permission.Notify = *permissionNotify
+	}
+	if fs.Changed("permission-bell") {
+		cfg.Permission.Bell = *permissionBell
+	}
+	if fs.Changed("expect-dry-run") {
+		cfg.ExpectDryRun = *expectDryRun
+	}
+	if fs.Changed("webhook") {
+		cfg.Webhook.URL = *webhookURL
+	}
+	if fs.Changed("webhook-format") {
+		if !webhookFormats[*webhookFormat] {
+			log.Fatalf("unknown --webhook-format %q", *webhookFormat)
+		}
+		cfg.Webhook.Format = *webhookFormat
+	}
+	if fs.Changed("redact") {
+		cfg.Redact.Enabled = *redact
+	}
+	if fs.Changed("artifacts") {
+		if !artifactModes[*artifacts] {
+			log.Fatalf("unknown --artifacts mode %q", *artifacts)
+		}
+		cfg.Artifacts = *artifacts
+	}
+	if fs.Changed("script") {
+		cfg.Script = *scriptFile
+	}
+	if fs.Changed("status-line") {
+		cfg.StatusLine = *statusLineFlag
+	}
+	if fs.Changed("prompt-marks") {
+		cfg.PromptMarks = *promptMarks
+	}
+	if fs.Changed("output-pipe") {
+		cfg.Pipes.Output = *outputPipes
+	}
+	if fs.Changed("scrollback") {
+		cfg.Scrollback = nonNegative("scrollback", *scrollbackFlag)
+	}
+	if fs.Changed("wheel-scroll") {
+		cfg.WheelScroll = *wheelScroll
+	}
+	if fs.Changed("no-intercept-suspend") {
+		cfg.InterceptSuspend = !*noInterceptSuspend
+	}
+	if fs.Changed("quit-key") {
+		cfg.Keys.Quit = *quitKey
+	}
+	if fs.Changed("suspend-key") {
+		cfg.Keys.SuspendKey = *suspendKey
+	}
+	if _, err := cfg.hotkeys(); err != nil {
+		log.Fatalf("bad key binding: %v", err)
+	}
+	if fs.Changed("clipboard") {
+		if !clipboardModes[*clipboard] {
+			log.Fatalf("unknown --clipboard mode %q", *clipboard)
+		}
+		cfg.Clipboard = *clipboard
+	}
+	if fs.Changed("title") {
+		cfg.Title.Template = *title
+		cfg.Title.Mode = "rewrite"
+	}
+	if fs.Changed("title-mode") {
+		if !titleModes[*titleMode] {
+			log.Fatalf("unknown --title-mode %q", *titleMode)
+		}
+		cfg.Title.Mode = *titleMode
+	}
+	if fs.Changed("bell") {
+		if _, ok := bellModes[*bell]; !ok {
+			log.Fatalf("unknown --bell mode %q", *bell)
+		}
+		cfg.Bell.Mode = *bell
+	}
+	if fs.Changed("on-bell") {
+		cfg.Bell.Command = *onBell
+	}
+	if fs.Changed("esc-timeout") {
+		cfg.EscTimeout = nonNegative("esc-timeout", *escTimeoutFlag)
+	}
+	if fs.Changed("quit-timeout") {
+		cfg.QuitTimeout = nonNegative("quit-timeout", *quitTimeoutFlag)
+	}
+	if fs.Changed("no-filter") {
+		cfg.Passthrough = *noFilter
+	}
+	if *noDefaults {
+		cfg.Args = nil
+	}
+	if *noArchive {
+		cfg.Archive.Enabled = false
+	}
+	if fs.Changed("archive-dir") {
+		cfg.Archive.Dir = *archiveDir
+	}
+	if fs.Changed("usage") {
+		cfg.Usage.Enabled = *usageFlag
+	}
+	if fs.Changed("max-session-time") {
+		cfg.SessionLimit = nonNegative("max-session-time", *maxSessionTime)
+	}
+	if fs.Changed("session-time-warning") {
+		cfg.SessionWarning = nonNegative("session-time-warning", *sessionTimeWarningFlag)
+	}
+	if fs.Changed("resize-poll") {
+		cfg.ResizePoll = nonNegative("resize-poll", *resizePoll)
+	}
+	if fs.Changed("paste-chunk") {
+		cfg.Paste.Chunk = nonNegative("paste-chunk", *pasteChunk)
+	}
+	if fs.Changed("paste-delay") {
+		cfg.Paste.Delay = nonNegative("paste-delay", *pasteDelayFlag)
+	}
+	if fs.Changed("paste-wrap") {
+		cfg.Paste.Wrap = nonNegative("paste-wrap", *pasteWrap)
+	}
+	if fs.Changed("input-buffer") {
+		cfg.Buffers.Input = *inputBufferFlag
+	}
+	if fs.Changed("output-buffer") {
+		cfg.Buffers.Output = *outputBufferFlag
+	}
+	if cfg.Buffers.Input <= 0 || cfg.Buffers.Output <= 0 {
+		log.Fatalf("buffer sizes must be positive")
+	}
+	if fs.Changed("coalesce") {
+		cfg.Buffers.Coalesce = nonNegative("coalesce", *coalesce)
+	}
+	if fs.Changed("stall-timeout") {
+		cfg.Stall.Timeout = nonNegative("stall-timeout", *stallTimeout)
+	}
+	if fs.Changed("stall-action") {
+		if !stallActions[*stallAction] {
+			log.Fatalf("unknown --stall-action %q", *stallAction)
+		}
+		cfg.Stall.Action = *stallAction
+	}
+	if fs.Changed("notify-idle") {
+		cfg.Notify.Idle = nonNegative("notify-idle", *notifyIdle)
 	}
-	defer func() { _ = ptmx.Close() }()
 
-	// Handle window resizing
-	if err := pty.InheritSize(os.Stdin, ptmx); err != nil {
-		log.Printf("warning: could not inherit size: %v", err)
+	if *useTmux {
+		if attach || *detach {
+			log.Fatalf("--tmux can't be combined with attach or --detach")
+		}
+		return runTmux(*sessionName, withoutFlag(slices.Concat(flagEnvArgs, rawArgs), "tmux"))
 	}
-	resizeCh := make(chan os.Signal, 1)
-	signal.Notify(resizeCh, syscall.SIGWINCH)
-	go func() {
-		for range resizeCh {
-			_ = pty.InheritSize(os.Stdin, ptmx)
+
+	var hooks *script
+	if cfg.Script != "" {
+		if hooks, err = loadScript(cfg.Script); err != nil {
+			log.Fatalf("failed to load script: %v", err)
 		}
-	}()
+	}
 
-	// Raw mode
-	oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
-	if err != nil {
-		log.Fatalf("failed to set raw mode: %v", err)
-	}
-	defer func() { _ = term.Restore(int(os.Stdin.Fd()), oldState) }()
-
-	// Forward signals to child
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
-	go func() {
-		for sig := range sigCh {
-			if cmd.Process != nil {
-				_ = cmd.Process.Signal(sig)
-			}
-		}
-	}()
-
-	// Wait for child in background
-	done := make(chan struct{})
-	go func() {
-		_ = cmd.Wait()
-		close(done)
-	}()
-
-	// Copy child output to stdout
-	go func() {
-		_, _ = io.Copy(os.Stdout, ptmx)
-	}()
-
-	// Control signal channel from input processor
-	ctrlCh := make(chan controlSignal, 1)
-
-	// Process input: filter focus events, detect control chars, handle ESC timeout
-	go func() {
-		var pending []byte
-		buf := make([]byte, 1024)
-
-		// Use a pipe to make stdin reads interruptible by timeout
-		stdinData := make(chan []byte)
-		go func() {
-			for {
-				n, err := os.Stdin.Read(buf)
-				if err != nil {
-					close(stdinData)
-					return
-				}
-				if n > 0 {
-					data := make([]byte, n)
-					copy(data, buf[:n])
-					stdinData <- data
-				}
+	var (
+		ptmx    ptyproxy.Session
+		argv    []string
+		session = *sessionName // name of the background session, if any
+		stats   *metrics
+		hook    *webhook // set once the session's name is known
+	)
+	startWebhook := func(name string) {
+		if cfg.Webhook.URL != "" {
+			hook = newWebhook(cfg.Webhook, name)
+		}
+	}
+	defer func() { hook.Close() }()
+	if cfg.MetricsAddr != "" {
+		stats = newMetrics(statusName(session))
+	}
+	if attach {
+		switch names := passthroughArgs(fs, rawArgs); len(names) {
+		case 0:
+		case 1:
+			session = names[0]
+		default:
+			log.Fatalf("usage: claude-unfocused attach [name]")
+		}
+		if session == "" {
+			session, err = onlySession()
+		}
+		if err == nil {
+			ptmx, err = attachSession(session)
+		}
+		startWebhook(session)
+		if err != nil {
+			log.Fatalf("failed to attach: %v", err)
+		}
+	} else {
+		remotes := 0
+		for _, r := range []string{*sshHost, *dockerContainer, *podmanContainer} {
+			if r != "" {
+				remotes++
 			}
-		}()
-
-		var timerCh <-chan time.Time
-		for {
-			select {
-			case <-done:
-				return
-			case <-timerCh:
-				if len(pending) > 0 {
-					_, _ = ptmx.Write(pending)
-					pending = nil
+		}
+		if remotes > 1 {
+			log.Fatalf("only one of --ssh, --docker and --podman can be given")
+		}
+		argv = commandLine(fs, rawArgs, cfg, remotes > 0)
+		if remotes > 0 && cfg.Usage.Enabled {
+			log.Printf("warning: not tracking usage: claude's session logs are on the remote side")
+			cfg.Usage.Enabled = false
+		}
+		sock := os.Getenv(serveEnv)
+		_ = os.Unsetenv(serveEnv) // meant for us, not the child
+		set, unset := colorEnv(cfg.Color)
+		termSet, termUnset := termEnv(cfg.Term, cfg.Colorterm)
+		set, unset = append(set, termSet...), append(unset, termUnset...)
+		for _, key := range slices.Sorted(maps.Keys(cfg.Env)) {
+			set = append(set, key+"="+cfg.Env[key])
+		}
+		unset = append(unset, cfg.UnsetEnv...)
+		env, err := childEnv(append(set, *setEnv...), append(unset, *unsetEnv...))
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		var stderr *os.File
+		if *stderrLog != "" && (sock != "" || !*detach) {
+			// Appended to, so a crash's trace outlives restarts and the
+			// next run. With --detach it's the session server's, which
+			// runs the child
+			dir, err := artifactDir(cfg.Artifacts)
+			if err != nil {
+				log.Fatalf("failed to make the artifact directory: %v", err)
+			}
+			stderr, err = os.OpenFile(artifactPath(dir, *stderrLog), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+			if err != nil {
+				log.Fatalf("failed to open the stderr log: %v", err)
+			}
+		}
+		command := func(argv []string) *exec.Cmd {
+			cmd := exec.Command(argv[0], argv[1:]...)
+			cmd.Env = env
+			if stderr != nil {
+				cmd.Stderr = stderr
+			}
+			return cmd
+		}
+		_, wrapped := wrappedCommand(fs, rawArgs)
+		direct := !*detach && sock == "" &&
+			(!isTerminal(os.Stdin) || !isTerminal(os.Stdout) || !wrapped && printMode(argv[1:]))
+		switch {
+		case *sshHost != "":
+			argv = sshCommand(*sshHost, argv, !direct)
+		case *dockerContainer != "":
+			argv = containerCommand("docker", *dockerContainer, argv, !direct)
+		case *podmanContainer != "":
+			argv = containerCommand("podman", *podmanContainer, argv, !direct)
+		}
+		cmd := command(argv)
+		if sock != "" {
+			name := strings.TrimSuffix(filepath.Base(sock), ".sock")
+			startWebhook(name)
+			return serve(sock, cmd, cfg.Buffers.Output, newLifecycle(cfg.Hooks, name, argv), hook)
+		}
+		switch {
+		case !*detach:
+			session = ""
+		case session == "":
+			session = defaultSessionName()
+		}
+		startWebhook(session)
+		life := newLifecycle(cfg.Hooks, session, argv)
+		if err := life.run("pre_start", 0); err != nil {
+			log.Fatalf("%v", err)
+		}
+		if direct {
+			// Piped, redirected or printing a single answer: nothing to
+			// filter, and the PTY would only get in the way of the output
+			signals, _ := cfg.signalMap() // validated by loadConfig
+			code, err := ptyproxy.Exec(cmd, signals)
+			if err != nil {
+				log.Printf("%v", err)
+			}
+			life.exited(0, code)
+			hook.exited(commandName(argv), code)
+			return code
+		}
+		if *detach {
+			ptmx, err = startDetached(session)
+		} else {
+			restarts := *restartOnCrash
+			if cfg.Stall.Timeout > 0 && cfg.Stall.Action == "restart" && restarts == 0 {
+				restarts = 5
+			}
+			// launch starts the command in dir, or the working directory
+			launch := func(dir string) (ptyproxy.Session, error) {
+				inDir := func(argv []string) *exec.Cmd {
+					cmd := command(argv)
+					cmd.Dir = dir
+					return cmd
 				}
-				timerCh = nil
-			case data, ok := <-stdinData:
-				if !ok {
-					return
+				child, err := ptyproxy.Start(inDir(argv))
+				if err != nil || restarts <= 0 {
+					return child, err
 				}
-				for _, b := range data {
-					// Control characters
-					if b == ctrlZ {
-						if len(pending) > 0 {
-							_, _ = ptmx.Write(pending)
-							pending = nil
-						}
-						timerCh = nil
-						ctrlCh <- sigSuspend
-						continue
+				r := newRestarter(child, restarts, commandName(argv), func() (ptyproxy.Session, error) {
+					again := argv
+					if *restartContinue && !slices.Contains(argv[1:], "--continue") {
+						again = append(slices.Clip(argv), "--continue")
 					}
-					if b == ctrlBackslash {
-						if len(pending) > 0 {
-							_, _ = ptmx.Write(pending)
-							pending = nil
-						}
-						timerCh = nil
-						ctrlCh <- sigQuit
-						continue
-					}
-
-					// State machine for ESC sequence filtering
-					if len(pending) == 0 {
-						if b == esc {
-							pending = []byte{esc}
-							timerCh = time.After(escTimeout)
-						} else {
-							_, _ = ptmx.Write([]byte{b})
-						}
-					} else if len(pending) == 1 {
-						// Have ESC pending
-						if b == '[' {
-							pending = append(pending, '[')
-							timerCh = time.After(escTimeout)
-						} else if b == esc {
-							_, _ = ptmx.Write([]byte{esc})
-							pending = []byte{esc}
-							timerCh = time.After(escTimeout)
-						} else {
-							_, _ = ptmx.Write([]byte{esc, b})
-							pending = nil
-							timerCh = nil
-						}
-					} else {
-						// Have ESC[ pending
-						if b == 'I' || b == 'O' {
-							// Swallow focus event
-							pending = nil
-							timerCh = nil
-						} else if b == esc {
-							_, _ = ptmx.Write([]byte{esc, '['})
-							pending = []byte{esc}
-							timerCh = time.After(escTimeout)
-						} else {
-							_, _ = ptmx.Write([]byte{esc, '[', b})
-							pending = nil
-							timerCh = nil
-						}
+					return ptyproxy.Start(inDir(again))
+				})
+				r.onRestart = func() {
+					stats.restart()
+					hook.send("crash", commandName(argv)+" crashed and was restarted")
+				}
+				return r, nil
+			}
+			ptmx, err = launch("")
+			// Without session switching the proxy reads the child's
+			// PTY itself, which lets it splice the output
+			if err == nil && (len(*openDirs) > 0 || cfg.Keys.bound(cfg.Keys.NewSession)) {
+				mux := newSessions(ptmx, "", cfg.Buffers.Output, launch)
+				for _, dir := range *openDirs {
+					if err = mux.open(dir); err != nil {
+						_ = mux.Kill()
+						break
 					}
 				}
+				ptmx = mux
 			}
 		}
-	}()
-
-	// Main loop: wait for exit or control signals
-	for {
-		select {
-		case <-done:
-			return
-		case sig := <-ctrlCh:
-			switch sig {
-			case sigSuspend:
-				_ = term.Restore(int(os.Stdin.Fd()), oldState)
-				signal.Reset(syscall.SIGTSTP)
-				_ = syscall.Kill(0, syscall.SIGTSTP)
-				_, _ = term.MakeRaw(int(os.Stdin.Fd()))
-			case sigQuit:
-				_ = term.Restore(int(os.Stdin.Fd()), oldState)
-				if cmd.Process != nil {
-					_ = cmd.Process.Kill()
-				}
+		if err != nil {
+			log.Fatalf("failed to start PTY: %v", err)
+		}
+	}
+	defer func() { _ = ptmx.Close() }()
+
+	var dir string // for the session's artifacts
+	if *recordFile != "" || *transcriptFile != "" || *traceFile != "" || *auditFile != "" || *outputLogFile != "" {
+		if dir, err = artifactDir(cfg.Artifacts); err != nil {
+			log.Fatalf("failed to make the artifact directory: %v", err)
+		}
+	}
+
+	var secrets *redactor
+	if cfg.Redact.Enabled {
+		secrets, _ = newRedactor(cfg.Redact.Patterns) // validated by loadConfig
+	}
+
+	files := sessionFiles{log: logs}
+	if *recordFile != "" {
+		cols, rows, _ := ptyproxy.TermSize()
+		var recipients []age.Recipient
+		if *recordEncrypt != "" {
+			if recipients, err = parseRecipients(*recordEncrypt); err != nil {
+				log.Fatalf("bad --record-encrypt: %v", err)
+			}
+		}
+		files.rec, err = newRecorder(artifactPath(dir, *recordFile), cols, rows, argv, *recordInput, recipients, secrets)
+		if err != nil {
+			log.Fatalf("failed to start recording: %v", err)
+		}
+		defer func() { _ = files.rec.Close() }()
+	}
+	if *transcriptFile != "" {
+		files.tr, err = newTranscript(artifactPath(dir, *transcriptFile), secrets)
+		if err != nil {
+			log.Fatalf("failed to start transcript: %v", err)
+		}
+		defer func() { _ = files.tr.Close() }()
+	}
+	if cfg.Archive.Enabled {
+		if files.arch, err = newArchive(cfg.Archive.Dir, secrets); err != nil {
+			log.Printf("warning: not archiving the transcript: %v", err)
+		} else {
+			defer func() { _ = files.arch.Close() }()
+		}
+	}
+	if *traceFile != "" {
+		files.trace, err = newTracer(artifactPath(dir, *traceFile), secrets)
+		if err != nil {
+			log.Fatalf("failed to start trace: %v", err)
+		}
+		defer func() { _ = files.trace.Close() }()
+	}
+	if *auditFile != "" {
+		files.audit, err = newAuditLog(artifactPath(dir, *auditFile), secrets)
+		if err != nil {
+			log.Fatalf("failed to open audit log: %v", err)
+		}
+		defer func() { _ = files.audit.Close() }()
+	}
+	if *teeFile != "" {
+		if !teeFormats[*teeFormat] {
+			log.Fatalf("unknown --tee-format %q", *teeFormat)
+		}
+		t, err := newTee(*teeFile)
+		if err != nil {
+			log.Fatalf("failed to start tee: %v", err)
+		}
+		files.tee = redactTo(t, secrets)
+		if *teeFormat == "plain" {
+			files.tee = transcriptTo(files.tee)
+		}
+		defer func() { _ = files.tee.Close() }()
+	}
+	if *outputLogFile != "" {
+		files.raw, err = newOutputLog(artifactPath(dir, *outputLogFile), secrets)
+		if err != nil {
+			log.Fatalf("failed to start output log: %v", err)
+		}
+		defer func() { _ = files.raw.Close() }()
+	}
+
+	return proxy(ptmx, cfg, argv, session, files, events, stats, hooks, hook)
+}
+
+// notice briefly shows a wrapper message on the bottom line of the screen,
+// leaving the cursor where the child had it. The child's next redraw
+// replaces it.
+func notice(msg string) {
+	notices.write(noticeBytes(msg))
+}
+
+// noticeBytes returns the output that shows msg as a notice, for writing in
+// step with the child's output.
+func noticeBytes(msg string) []byte {
+	return fmt.Appendf(nil, "\x1b7\x1b[999;1H\x1b[7m claude-unfocused: %s \x1b[0m\x1b[K\x1b8", msg)
+}
+
+// printMode reports whether claude's arguments ask for a non-interactive
+// run: -p/--print, or machine-readable output.
+func printMode(args []string) bool {
+	for i, arg := range args {
+		switch arg {
+		case "--":
+			return false
+		case "-p", "--print", "--output-format=json", "--output-format=stream-json":
+			return true
+		case "--output-format":
+			if i+1 < len(args) && (args[i+1] == "json" || args[i+1] == "stream-json") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// commandLine returns the command to run: the one given after "--", or
+// claude with the configured and passed-through arguments. A remote command
+// is left for the other side to find.
+func commandLine(fs *pflag.FlagSet, rawArgs []string, cfg config, remote bool) []string {
+	if argv, ok := wrappedCommand(fs, rawArgs); ok {
+		// Generic mode: wrap the command after "--" instead of claude
+		if len(argv) == 0 {
+			log.Fatalf("missing command after --")
+		}
+		if !remote {
+			if err := checkCommand(argv[0]); err != nil {
+				log.Fatalf("%v", err)
+			}
+		}
+		return argv
+	}
+	claude := cfg.Claude
+	switch {
+	case remote:
+		// Found, or not, on the other side
+	case claude == "claude":
+		var err error
+		if claude, err = findClaude(); err != nil {
+			log.Fatalf("%v", err)
+		}
+	default:
+		if err := checkCommand(claude); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	// Collect args to pass through (pflag drops unknown flags, so reconstruct manually)
+	argv := append([]string{claude}, cfg.Args...)
+	return append(argv, passthroughArgs(fs, rawArgs)...)
+}
+
+// wrappedCommand returns the command given after "--" to run instead of
+// claude, and whether there is one. Only a "--" that follows nothing but the
+// wrapper's own flags starts one; after arguments for claude it is claude's,
+// as in "claude-unfocused -p -- -x".
+func wrappedCommand(fs *pflag.FlagSet, rawArgs []string) ([]string, bool) {
+	i := slices.Index(rawArgs, "--")
+	if i < 0 || len(passthroughArgs(fs, rawArgs[:i])) > 0 {
+		return nil, false
+	}
+	return rawArgs[i+1:], true
+}
+
+// inputSession is a session the wrapper types into itself, showing what it
+// writes to onInput first, as the proxy shows it what the user types.
+type inputSession struct {
+	ptyproxy.Session
+	onInput func([]byte)
+}
+
+func (s inputSession) Write(b []byte) (int, error) {
+	s.onInput(b)
+	return s.Session.Write(b)
+}
+
+// sessionFiles are what a session is written to, each nil unless asked for.
+type sessionFiles struct {
+	rec   *recorder
+	tr    *transcript
+	arch  *archive
+	trace *tracer
+	audit *auditLog
+	raw   *outputLog
+	tee   io.WriteCloser
+	log   *rotatingFile
+}
+
+// proxy connects the terminal to the child until it exits, returning its
+// exit code. In a background session, the quit key detaches from it instead
+// of killing the child.
+func proxy(ptmx ptyproxy.Session, cfg config, argv []string, session string, files sessionFiles, events *eventLog, stats *metrics, hooks *script, hook *webhook) int {
+	rec, trace := files.rec, files.trace
+	hotkeys, _ := cfg.hotkeys() // validated by loadConfig
+	signals, _ := cfg.signalMap()
+	signalActions, _ := cfg.signalActions()
+	opts := escfilter.Options{
+		Focus:        cfg.Filter.Focus,
+		Mouse:        cfg.Filter.Mouse,
+		ForceFocused: cfg.Filter.ForceFocused,
+		WrapBursts:   cfg.Paste.Wrap,
+		Hotkeys:      hotkeys,
+	}
+	scrollback := cfg.Scrollback > 0 && (cfg.Keys.bound(cfg.Keys.Search) || cfg.Keys.bound(cfg.Keys.CopyMode) || cfg.WheelScroll)
+	if scrollback && cfg.WheelScroll {
+		opts.WheelUp, opts.WheelDown = actionWheelUp, actionWheelDown
+	}
+	var status *statusLine // set up once the filter is
+	if cfg.Graphics == "block" || cfg.StatusLine || cfg.Keys.bound(cfg.Keys.ToggleStatus) {
+		opts.Replies = graphicsReplies(cfg.Graphics, func() (int, int) { return status.heights() })
+	}
+	var traces []func(escfilter.Event)
+	if trace != nil {
+		traces = append(traces, trace.filterEvent)
+	}
+	if events != nil {
+		traces = append(traces, events.filterEvent)
+	}
+	if stats != nil {
+		traces = append(traces, stats.filterEvent)
+	}
+	var replies *replyCache
+	if cfg.CacheReplies {
+		replies = newReplyCache(func(b []byte) { _, _ = ptmx.Write(b) }, trace)
+		traces = append(traces, replies.filterEvent)
+	}
+	if len(traces) > 0 {
+		opts.Trace = func(e escfilter.Event) {
+			for _, t := range traces {
+				t(e)
+			}
+		}
+	}
+	filter := escfilter.New(opts)
+	inputFilter := filter // none in passthrough, leaving input as it is
+	if cfg.Passthrough {
+		inputFilter = nil
+	}
+	mux, _ := ptmx.(*sessions)
+	if cfg.Newline.Enter != "cr" {
+		ptmx = enterSession{ptmx, enterKeys[cfg.Newline.Enter]}
+	}
+	if len(cfg.Pipes.Input) > 0 {
+		input, stop, err := pipeFilters(cfg.Pipes.Input, ptmx)
+		if err != nil {
+			log.Printf("warning: input filter not started: %v", err)
+		} else {
+			ptmx = pipedSession{ptmx, input}
+			defer stop()
+		}
+	}
+	// What the wrapper types itself, for scripts, expect rules and the
+	// control socket, is noted as what the user types is, once the proxy
+	// runs
+	var onInput func([]byte)
+	typed := func(s ptyproxy.Session) ptyproxy.Session {
+		return inputSession{s, func(b []byte) { onInput(b) }}
+	}
+	if hooks != nil {
+		child := typed(ptmx) // sent input skips on_input
+		hooks.send = func(b []byte) { _, _ = child.Write(b) }
+		if hooks.defines("on_input") {
+			ptmx = scriptedSession{ptmx, hooks}
+		}
+	}
+	// With scrollback or the palette, the wrapper may take over the
+	// terminal, holding the child's output back meanwhile
+	// The wrapper works in UTF-8, converting for a terminal that doesn't
+	tty := io.Writer(os.Stdout)
+	if cfg.Charset.Terminal != "utf-8" {
+		tty = charsetWriter{os.Stdout, newTranscoder("utf-8", cfg.Charset.Terminal, cfg.Charset.Replacement)}
+	}
+	// Notices are written in step with whatever goes to the terminal
+	notices.to(tty)
+	spliceable := tty == io.Writer(os.Stdout)
+	tty = notices
+	terminal := tty
+	// Output commands stand between claude and the terminal alone; the
+	// screen, recordings and the like see claude's output as it was
+	stopPipes := func() {}
+	if len(cfg.Pipes.Output) > 0 {
+		piped, stop, err := pipeFilters(cfg.Pipes.Output, tty)
+		if err != nil {
+			log.Printf("warning: output filter not started: %v", err)
+		} else {
+			terminal, stopPipes = piped, stop
+		}
+	}
+	var screen *vt.Screen
+	var gate *outputGate
+	var view *scrollView
+	var menu *palette
+	var help *keyHelp
+	dumps := cfg.Keys.bound(cfg.Keys.DumpScreen) || slices.Contains(slices.Collect(maps.Values(signalActions)), actionDumpScreen)
+	menus := cfg.Keys.bound(cfg.Keys.Palette)
+	helps := cfg.Keys.bound(cfg.Keys.Help) || menus // the palette lists it
+	if cfg.Control || scrollback || dumps || menus || helps {
+		cols, rows, err := ptyproxy.TermSize()
+		if err != nil || cols == 0 || rows == 0 {
+			cols, rows = 80, 24
+		}
+		screen = vt.New(cols, rows)
+	}
+	if scrollback || menus || helps {
+		gate = &outputGate{w: terminal}
+		terminal = gate
+	}
+	if menus {
+		menu = &palette{screen: screen, gate: gate, w: tty, entries: paletteEntries(cfg)}
+	}
+	if helps {
+		help = &keyHelp{screen: screen, gate: gate, w: tty, lines: helpLines(cfg)}
+	}
+	if scrollback {
+		screen.SetHistory(cfg.Scrollback)
+		view = &scrollView{screen: screen, gate: gate, w: tty, yank: func(text []byte) {
+			setClipboard(cfg.Clipboard, text)
+			notice(fmt.Sprintf("copied %d characters", utf8.RuneCount(text)))
+		}}
+	}
+	var usage *usageTracker
+	if cfg.Usage.Enabled {
+		if wd, err := os.Getwd(); err != nil {
+			log.Printf("warning: not tracking usage: %v", err)
+		} else if dir, err := claudeProjectDir(wd); err != nil {
+			log.Printf("warning: not tracking usage: %v", err)
+		} else {
+			usage = newUsageTracker(dir, cfg.Usage.Prices)
+			// Summed up once the status line is gone and the terminal restored
+			defer func() {
+				usage.Close()
+				fmt.Fprintf(os.Stderr, "claude-unfocused: %s used %s\n", commandName(argv), usage.summary())
+			}()
+		}
+	}
+	if cfg.StatusLine || cfg.Keys.bound(cfg.Keys.ToggleStatus) {
+		status = newStatusLine(terminal, statusName(session), inputFilter, usage)
+		ptmx = status.wrap(ptmx)
+		defer status.Close()
+		if cfg.StatusLine {
+			status.toggle()
+		}
+	}
+	if stats != nil {
+		ln, err := stats.serve(cfg.MetricsAddr)
+		if err != nil {
+			log.Printf("warning: could not serve metrics: %v", err)
+		} else {
+			defer func() { _ = ln.Close() }()
+		}
+	}
+
+	var idle *idleNotifier
+	if cfg.Notify.Idle > 0 {
+		idle = newIdleNotifier(cfg.Notify.Idle, argv, nil)
+		defer idle.Close()
+	}
+	var idleHook *idleNotifier
+	if hook.wants("idle") && cfg.Webhook.Idle > 0 {
+		idleHook = newIdleNotifier(cfg.Webhook.Idle, argv, func(msg string) { hook.send("idle", msg) })
+		defer idleHook.Close()
+	}
+	var prompts *promptWatcher
+	if cfg.Permission.Notify || cfg.Permission.Bell || hook.wants("permission") {
+		patterns, _ := permissionPatterns(cfg.Permission.Patterns) // validated by loadConfig
+		prompts = newPromptWatcher(patterns, func(msg string) {
+			if cfg.Permission.Notify {
+				desktopAlert(msg)
+			}
+			hook.send("permission", msg)
+		}, cfg.Permission.Bell, argv)
+	}
+	var expect *expecter
+	if len(cfg.Expect) > 0 {
+		child := typed(ptmx)
+		expect = newExpecter(cfg.Expect, cfg.ExpectDryRun, func(b []byte) { _, _ = child.Write(b) })
+	}
+	var stall *watchdog
+	if cfg.Stall.Timeout > 0 {
+		stall = newWatchdog(cfg.Stall.Timeout, cfg.Stall.Action, argv, ptmx.Kill, func() error {
+			return restartChild(ptmx)
+		})
+		defer stall.Close()
+	}
+
+	out := []io.Writer{terminal}
+	var rules []outputRule
+	if status != nil {
+		out[0] = status
+		rules = append(rules, status.rule())
+	}
+	if cfg.Bell != defaultConfig().Bell {
+		out[0] = newBellWatcher(out[0], cfg.Bell, argv)
+	}
+	var marks *turnMarker
+	if cfg.PromptMarks {
+		marks = newTurnMarker(out[0])
+		out[0] = marks
+		defer marks.Close()
+	}
+	if replies != nil {
+		rules = append(rules, replies.rule())
+	}
+	if cfg.Filter.StripFocusMode {
+		rules = append(rules, focusModeRule())
+	}
+	var wheel *wheelScroller
+	if opts.WheelUp != 0 {
+		wheel = &wheelScroller{filter: filter}
+		defer func() { _, _ = os.Stdout.Write(wheel.restore()) }()
+	}
+	var sgr []sgrRewrite
+	if cfg.Downgrade {
+		if ti, err := terminfo.Load(os.Getenv("TERM")); err != nil {
+			log.Printf("warning: not downgrading output: %v", err)
+		} else {
+			if rule := syncRule(ti); rule != nil {
+				rules = append(rules, rule)
+			}
+			if rw := attrDowngrade(ti); rw != nil {
+				sgr = append(sgr, rw)
+			}
+		}
+	}
+	if cfg.StripColor {
+		sgr = append(sgr, stripColor)
+	} else if cfg.DowngradeColor != "" {
+		sgr = append(sgr, colorDowngrade(cfg.DowngradeColor))
+	}
+	if len(sgr) > 0 {
+		rules = append(rules, sgrRule(sgr...))
+	}
+	if rule := graphicsRule(cfg.Graphics); rule != nil {
+		rules = append(rules, rule)
+	}
+	if rule := shellIntegrationRule(cfg.Filter.ShellIntegration); rule != nil {
+		rules = append(rules, rule)
+	}
+	if rule := clipboardRule(cfg.Clipboard, commandName(argv)); rule != nil {
+		rules = append(rules, rule)
+	}
+	if cfg.Title.Mode != "pass" {
+		title := newTitleKeeper(cfg.Title.Template, session)
+		rules = append(rules, title.rule(cfg.Title.Mode))
+		if cfg.Title.Mode == "rewrite" {
+			_, _ = tty.Write(title.start())
+			defer func() { _, _ = io.WriteString(os.Stdout, popTitle) }()
+		}
+	}
+	if wheel != nil {
+		rules = append(rules, wheel.rule()) // last, to see every mode change
+	}
+	if len(rules) > 0 {
+		out[0] = newOutputFilter(out[0], rules...)
+	}
+	if cfg.Newline.Output != "" {
+		out[0] = newNewlineWriter(out[0], cfg.Newline.Output)
+	}
+	if rec != nil {
+		out = append(out, rec)
+	}
+	if files.tr != nil {
+		out = append(out, files.tr)
+	}
+	if files.arch != nil {
+		out = append(out, files.arch)
+	}
+	if files.raw != nil {
+		out = append(out, files.raw)
+	}
+	if files.tee != nil {
+		out = append(out, files.tee)
+	}
+	if trace != nil {
+		out = append(out, trace)
+	}
+	if idle != nil {
+		out = append(out, idle)
+	}
+	if idleHook != nil {
+		out = append(out, idleHook)
+	}
+	if prompts != nil {
+		out = append(out, prompts)
+	}
+	if expect != nil {
+		out = append(out, expect)
+	}
+	if stall != nil {
+		out = append(out, stall)
+	}
+	if stats != nil {
+		out = append(out, stats)
+	}
+	if screen != nil {
+		out = append(out, screen)
+	}
+	if view != nil {
+		out = append(out, view)
+	}
+	var output io.Writer // unset when output goes straight to the terminal
+	if len(out) > 1 || out[0] != io.Writer(notices) || !spliceable {
+		output = io.MultiWriter(out...)
+	}
+	if hooks.defines("on_output") {
+		if output == nil {
+			output = notices
+		}
+		output = scriptedOutput{output, hooks}
+	}
+	if cfg.Charset.Child != "utf-8" {
+		if output == nil {
+			output = notices
+		}
+		output = charsetWriter{output, newTranscoder(cfg.Charset.Child, "utf-8", "")}
+	}
+	modes := newModeTracker()
+	var watch io.Writer = modes
+	if output == nil {
+		watch = io.MultiWriter(modes, notices.watch())
+	}
+	detached := false
+	var decode func([]byte) []byte
+	if cfg.Charset.Terminal != cfg.Charset.Child {
+		decode = newTranscoder(cfg.Charset.Terminal, cfg.Charset.Child, cfg.Charset.Replacement).convert
+	}
+	onInput = func(b []byte) {
+		rec.recordInput(b)
+		files.audit.input(b)
+		idle.input(b)
+		idleHook.input(b)
+		marks.input(b)
+		prompts.input(b)
+		stall.input(b)
+		stats.input(b)
+	}
+	p := &ptyproxy.Proxy{
+		Session:       ptmx,
+		Filter:        inputFilter,
+		EscTimeout:    cfg.EscTimeout,
+		PasteChunk:    cfg.Paste.Chunk,
+		PasteDelay:    cfg.Paste.Delay,
+		InputBuffer:   cfg.Buffers.Input,
+		OutputBuffer:  cfg.Buffers.Output,
+		Coalesce:      cfg.Buffers.Coalesce,
+		ResizePoll:    cfg.ResizePoll,
+		Output:        output,
+		Decode:        decode,
+		Watch:         watch,
+		OnInput:       onInput,
+		Signals:       signals,
+		SignalActions: signalActions,
+		OnSignal:      events.signal,
+		OnResize: func(cols, rows int) {
+			rec.resize(cols, rows)
+			events.resize(cols, rows)
+			stats.resize(cols, rows)
+			if screen != nil {
+				screen.Resize(cols, rows)
+			}
+			view.resize(cols, rows)
+			menu.resize(cols, rows)
+			help.resize(cols, rows)
+		},
+	}
+	if cfg.Control {
+		ctl, err := startControl(typed(ptmx), filter, screen, argv)
+		if err != nil {
+			log.Printf("warning: could not open control socket: %v", err)
+		} else {
+			defer func() { _ = ctl.Close() }()
+		}
+	}
+	if view != nil {
+		view.session, view.capture = ptmx, p.Capture
+	}
+	if menu != nil {
+		menu.session, menu.capture, menu.act = ptmx, p.Capture, p.Act
+	}
+	if help != nil {
+		help.session, help.capture = ptmx, p.Capture
+	}
+	p.OnAction = func(a escfilter.Action) {
+		switch a {
+		case actionSuspend:
+			if err := p.Suspend(); err != nil {
+				// No job control: let the child have the keypress
+				keys, _ := escfilter.ParseKeys(cfg.Keys.SuspendKey)
+				_, _ = ptmx.Write(keys)
+			}
+		case actionQuit:
+			if session != "" {
+				detached = true
+				p.Stop(0)
+				return
+			}
+			p.Terminate(cfg.QuitTimeout)
+		case actionTimeLimit:
+			log.Printf("warning: %s reached the session time limit of %v, stopping it", commandName(argv), cfg.SessionLimit)
+			p.Terminate(cfg.QuitTimeout)
+		case actionToggleFilter:
+			on := !filter.Focus()
+			filter.SetFocus(on)
+			notice("focus filter " + onOff(on))
+		case actionWheelUp, actionWheelDown:
+			view.wheel(a == actionWheelUp)
+		case actionSearch, actionCopyMode:
+			switch {
+			case view == nil:
+				notice("scrollback is off; turn it on with --scrollback")
+			case a == actionSearch:
+				view.show()
+			default:
+				view.copy()
+			}
+		case actionPalette:
+			menu.show()
+		case actionHelp:
+			help.show()
+		case actionToggleStatus:
+			if status != nil {
+				status.toggle()
+			}
+		case actionDetach:
+			if session == "" {
+				notice("not a background session: start it with --detach to detach")
 				return
 			}
+			detached = true
+			p.Stop(0)
+		case actionDumpScreen:
+			path, err := dumpScreen(screen, cfg.Artifacts)
+			if err != nil {
+				notice("screen dump failed: " + err.Error())
+			} else {
+				notice("screen saved to " + path)
+			}
+		case actionRotateLogs:
+			if err := files.log.rotateNow(); err != nil {
+				notice("log rotation failed: " + err.Error())
+			}
+		case actionNewSession, actionNextSession, actionPrevSession:
+			switch {
+			case mux == nil && session != "":
+				notice("sessions can't be switched in a background session")
+			case mux == nil:
+				notice("session switching is off; bind new_session or use --open to turn it on")
+			case a == actionNewSession:
+				if err := mux.open(""); err != nil {
+					notice("failed to start: " + err.Error())
+				}
+			case a == actionNextSession:
+				mux.cycle(1)
+			default:
+				mux.cycle(-1)
+			}
+		}
+	}
+	if session != "" {
+		// Losing the terminal detaches, leaving the session for later
+		p.OnHangup = func() {
+			detached = true
+			p.Stop(0)
+		}
+	}
+
+	life := newLifecycle(cfg.Hooks, session, argv)
+	pid := ptmx.Pid()
+	if session != "" {
+		if err := life.run("on_attach", pid); err != nil {
+			log.Printf("warning: %v", err)
+		}
+	}
+	events.start(argv, pid)
+	hooks.start(argv, pid)
+	if cfg.SessionLimit > 0 {
+		limit := newTimeLimit(cfg.SessionLimit, cfg.SessionWarning, argv, func() { p.Act(actionTimeLimit) })
+		defer limit.Close()
+	}
+	messages.start(func(msg string) {
+		if !status.flash(msg) {
+			notice(msg)
+		}
+	})
+	code, err := p.Run()
+	messages.stop()
+	events.exit(code, err)
+	hooks.exit(code)
+	switch {
+	case detached || errors.Is(err, errDetached):
+		if err := life.run("on_detach", pid); err != nil {
+			log.Printf("warning: %v", err)
 		}
+	case session == "":
+		// A background session's server runs post_exit
+		life.exited(pid, code)
+		hook.exited(commandName(argv), code)
 	}
+	stopPipes()
+	// A crashed child leaves the terminal as it had it
+	_, _ = os.Stdout.Write(modes.restore())
+	switch {
+	case detached || errors.Is(err, errDetached):
+		fmt.Fprintln(os.Stderr, "[detached]")
+	case err != nil:
+		log.Printf("%v", err)
+	}
+	return code
 }
 
-// passthroughArgs returns all args except --claude and its value
-func passthroughArgs(rawArgs []string) []string {
+// childEnv returns the wrapper's environment with the KEY=VAL pairs in set
+// added and the keys in unset removed, or nil to inherit it unchanged.
+func childEnv(set, unset []string) ([]string, error) {
+	if len(set) == 0 && len(unset) == 0 {
+		return nil, nil
+	}
+	env := os.Environ()
+	for _, key := range unset {
+		env = slices.DeleteFunc(env, func(kv string) bool {
+			return strings.HasPrefix(kv, key+"=")
+		})
+	}
+	for _, kv := range set {
+		key, _, ok := strings.Cut(kv, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("bad --env %q: want KEY=VAL", kv)
+		}
+		env = slices.DeleteFunc(env, func(old string) bool {
+			return strings.HasPrefix(old, key+"=")
+		})
+		env = append(env, kv)
+	}
+	return env, nil
+}
+
+// termEnv returns the variables to set in the child's environment, and
+// those to remove, for a TERM and COLORTERM of its own. A TERM without a
+// COLORTERM goes without the terminal's, which was about the terminal.
+func termEnv(term, colorterm string) (set, unset []string) {
+	if term != "" {
+		set = append(set, "TERM="+term)
+	}
+	switch {
+	case colorterm != "":
+		set = append(set, "COLORTERM="+colorterm)
+	case term != "":
+		unset = append(unset, "COLORTERM")
+	}
+	return set, unset
+}
+
+// nonNegative returns v, the value of the flag name, failing if it is
+// negative.
+func nonNegative[T int | time.Duration](name string, v T) T {
+	if v < 0 {
+		log.Fatalf("--%s must not be negative", name)
+	}
+	return v
+}
+
+// passthroughArgs returns all args except the wrapper's own flags and their values
+func passthroughArgs(fs *pflag.FlagSet, rawArgs []string) []string {
 	var args []string
 	for i := 0; i < len(rawArgs); i++ {
 		arg := rawArgs[i]
+		if arg == "--" {
+			// The rest is claude's, flags that look like the wrapper's too
+			return append(args, rawArgs[i:]...)
+		}
+		name, _, hasValue := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+		flag := fs.Lookup(name)
 		switch {
-		case arg == "--claude" && i+1 < len(rawArgs):
-			i++ // skip value
-		case strings.HasPrefix(arg, "--claude="):
+		case !strings.HasPrefix(arg, "--") || flag == nil:
+			args = append(args, arg)
+		case hasValue || flag.NoOptDefVal != "":
 			// skip
+		case i+1 < len(rawArgs):
+			i++ // skip value
 		default:
 			args = append(args, arg)
 		}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func TestWrappedCommand(t *testing.T) {
+	fs := pflag.NewFlagSet("claude-unfocused", pflag.ContinueOnError)
+	fs.Bool("no-archive", false, "")
+	fs.String("session", "", "")
+	fs.Int("restart-on-crash", 0, "")
+	fs.Lookup("restart-on-crash").NoOptDefVal = "5"
+
+	tests := []struct {
+		args        string
+		wrapped     bool
+		command     string // the wrapped command, if wrapped
+		passthrough string // claude's arguments, if not
+	}{
+		{"", false, "", ""},
+		{"-- aider --model sonnet", true, "aider --model sonnet", ""},
+		{"--no-archive --session work -- codex", true, "codex", ""},
+		{"--restart-on-crash -- codex", true, "codex", ""},
+		{"--", true, "", ""},
+		{"-p hello", false, "", "-p hello"},
+		{"-p -- -x", false, "", "-p -- -x"},
+		{"--no-archive -p -- -x", false, "", "-p -- -x"},
+		{"--resume -- --no-archive", false, "", "--resume -- --no-archive"},
+	}
+	for _, tt := range tests {
+		args := strings.Fields(tt.args)
+		command, wrapped := wrappedCommand(fs, args)
+		if wrapped != tt.wrapped || strings.Join(command, " ") != tt.command {
+			t.Errorf("wrappedCommand(%q) = %q, %v, want %q, %v", tt.args, command, wrapped, tt.command, tt.wrapped)
+		}
+		if wrapped {
+			continue
+		}
+		if got := passthroughArgs(fs, args); !slices.Equal(got, strings.Fields(tt.passthrough)) {
+			t.Errorf("passthroughArgs(%q) = %q, want %q", tt.args, got, tt.passthrough)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+// turnIdle is how long the child must be quiet after output for its turn to
+// count as over. claude's spinner redraws several times a second while it
+// works, so a pause this long means it is waiting for the user.
+const turnIdle = 1500 * time.Millisecond
+
+// OSC 133 semantic prompt sequences, as a shell's integration writes them
+// around each command.
+const (
+	markPrompt = "\x1b]133;A\x07" // a prompt starts
+	markInput  = "\x1b]133;B\x07" // the user's input starts
+	markOutput = "\x1b]133;C\x07" // the command runs
+	markDone   = "\x1b]133;D\x07" // the command is done
+)
+
+// turnMarker marks claude's turns with OSC 133, so terminals that know it
+// can jump between them and time them like shell commands: the prompt once
+// the child goes quiet after output, the output when Enter sends the
+// prompt, and the turn's end when it goes quiet again. It writes to the
+// terminal behind the output filter, which hands it only whole sequences,
+// so a marker never lands inside one. A nil *turnMarker does nothing.
+type turnMarker struct {
+	w io.Writer // the terminal
+
+	mu       sync.Mutex
+	timer    *time.Timer
+	prompted bool // a prompt is marked and not yet sent
+	running  bool // a turn is marked and not yet done
+	closed   bool
+}
+
+func newTurnMarker(w io.Writer) *turnMarker {
+	return &turnMarker{w: w}
+}
+
+// Write passes output on to the terminal, restarting the idle clock.
+func (m *turnMarker) Write(b []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.timer == nil {
+		m.timer = time.AfterFunc(turnIdle, m.idle)
+	} else {
+		m.timer.Reset(turnIdle)
+	}
+	return m.w.Write(b)
+}
+
+// input notes bytes typed into the child: Enter at a prompt starts a turn.
+func (m *turnMarker) input(b []byte) {
+	if m == nil || !bytes.ContainsRune(b, '\r') {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.prompted {
+		_, _ = io.WriteString(m.w, markOutput)
+		m.prompted, m.running = false, true
+	}
+}
+
+// idle ends the turn, if one is running, and marks the prompt.
+func (m *turnMarker) idle() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return
+	}
+	if m.running {
+		_, _ = io.WriteString(m.w, markDone)
+		m.running = false
+	}
+	if !m.prompted {
+		_, _ = io.WriteString(m.w, markPrompt+markInput)
+		m.prompted = true
+	}
+}
+
+// Close stops the idle clock and ends a turn still running.
+func (m *turnMarker) Close() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	if m.timer != nil {
+		m.timer.Stop()
+	}
+	if m.running {
+		_, _ = io.WriteString(m.w, markDone)
+		m.running = false
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// messageModes are the accepted values of log.messages, which decides where
+// the wrapper's warnings go on the terminal:
+//
+//	terminal  stderr, or while claude has the screen the status line or
+//	          a notice on the bottom row
+//	log       nowhere, leaving them to the log file
+//	none      nowhere (--quiet)
+//
+// Fatal errors, and other messages than warnings, always go to the
+// terminal.
+var messageModes = map[string]bool{"terminal": true, "log": true, "none": true}
+
+// messageSink is the terminal end of the wrapper's log. The log's lines,
+// written raw into a session, would smear claude's screen, so once start
+// is called each is passed to show as a line of text instead, until stop.
+type messageSink struct {
+	mu   sync.Mutex
+	mode string
+	show func(msg string)
+}
+
+// messages is the sink startLog logs to.
+var messages = &messageSink{mode: "terminal"}
+
+// start has show display each message from now on.
+func (m *messageSink) start(show func(msg string)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.show = show
+}
+
+// stop goes back to writing messages to stderr, once the session has given
+// the terminal back.
+func (m *messageSink) stop() {
+	m.start(nil)
+}
+
+func (m *messageSink) Write(b []byte) (int, error) {
+	msg := messageText(b)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case m.mode != "terminal" && strings.HasPrefix(msg, "warning: "):
+	case m.show != nil:
+		m.show(strings.Join(strings.Fields(msg), " "))
+	default:
+		return os.Stderr.Write(b)
+	}
+	return len(b), nil
+}
+
+// messageText returns the message in a line of the log: its msg in the
+// json format, or the line without its timestamp in the text one.
+func messageText(line []byte) string {
+	if bytes.HasPrefix(line, []byte("{")) {
+		var record struct {
+			Msg string `json:"msg"`
+		}
+		if json.Unmarshal(line, &record) == nil {
+			return record.Msg
+		}
+	}
+	const stamp = "2006/01/02 15:04:05 " // as log.LstdFlags writes it
+	if len(line) >= len(stamp) {
+		if _, err := time.Parse(stamp, string(line[:len(stamp)])); err == nil {
+			line = line[len(stamp):]
+		}
+	}
+	return strings.TrimSpace(string(line))
+}
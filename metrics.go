@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/samuelstevens/claude-unfocused/pkg/escfilter"
+)
+
+// metrics counts what crosses the wrapper and serves the counts over HTTP
+// in the Prometheus text format, labelled with the session's name. A nil
+// *metrics counts nothing.
+type metrics struct {
+	session   string
+	started   time.Time
+	in, out   atomic.Int64 // bytes
+	swallowed atomic.Int64 // focus reports
+	resizes   atomic.Int64
+	restarts  atomic.Int64
+}
+
+func newMetrics(session string) *metrics {
+	return &metrics{session: session, started: time.Now()}
+}
+
+// serve answers GET /metrics on addr in the background.
+func (m *metrics) serve(addr string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", m.handle)
+	go func() { _ = http.Serve(ln, mux) }()
+	return ln, nil
+}
+
+func (m *metrics) handle(w http.ResponseWriter, _ *http.Request) {
+	label := fmt.Sprintf("{session=%s}", strconv.Quote(m.session))
+	var b bytes.Buffer
+	for _, c := range []struct {
+		name, kind, help string
+		value            float64
+	}{
+		{"claude_unfocused_input_bytes_total", "counter", "Bytes written to the child.", float64(m.in.Load())},
+		{"claude_unfocused_output_bytes_total", "counter", "Bytes of output read from the child.", float64(m.out.Load())},
+		{"claude_unfocused_focus_events_swallowed_total", "counter", "Focus reports kept from the child.", float64(m.swallowed.Load())},
+		{"claude_unfocused_resizes_total", "counter", "Times the child's terminal was resized.", float64(m.resizes.Load())},
+		{"claude_unfocused_restarts_total", "counter", "Times the child was restarted after a crash.", float64(m.restarts.Load())},
+		{"claude_unfocused_session_duration_seconds", "gauge", "How long the session has been running.", time.Since(m.started).Seconds()},
+	} {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n%s%s %v\n", c.name, c.help, c.name, c.kind, c.name, label, c.value)
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = w.Write(b.Bytes())
+}
+
+// Write counts child output, so metrics can sit in an io.MultiWriter.
+func (m *metrics) Write(b []byte) (int, error) {
+	if m != nil {
+		m.out.Add(int64(len(b)))
+	}
+	return len(b), nil
+}
+
+// input counts bytes written to the child.
+func (m *metrics) input(b []byte) {
+	if m != nil {
+		m.in.Add(int64(len(b)))
+	}
+}
+
+func (m *metrics) resize(cols, rows int) {
+	if m != nil {
+		m.resizes.Add(1)
+	}
+}
+
+func (m *metrics) restart() {
+	if m != nil {
+		m.restarts.Add(1)
+	}
+}
+
+// filterEvent counts the focus reports the input filter swallows.
+func (m *metrics) filterEvent(e escfilter.Event) {
+	if m != nil && e.Action == "swallow" && (bytes.Equal(e.Raw, []byte("\x1b[I")) || bytes.Equal(e.Raw, []byte("\x1b[O"))) {
+		m.swallowed.Add(1)
+	}
+}
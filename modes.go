@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/samuelstevens/claude-unfocused/internal/ansiparse"
+)
+
+// trackedModes are the DEC private modes that strand the user if the child
+// dies with them changed, mapped to the state to put back: the alternate
+// screens, cursor visibility, and the mouse, focus and paste reporting that
+// would otherwise keep arriving at the shell.
+var trackedModes = map[int]bool{
+	47: false, 1047: false, 1049: false,
+	25:   true,
+	1000: false, 1002: false, 1003: false, 1006: false, 1015: false,
+	1004: false,
+	2004: false,
+}
+
+// modeTracker follows the terminal modes the child changes, so that the
+// wrapper can put them back if it exits without doing so itself, as after a
+// crash.
+type modeTracker struct {
+	mu      sync.Mutex
+	parser  ansiparse.Parser
+	changed map[int]bool // mode to its current state, while not the default
+}
+
+func newModeTracker() *modeTracker {
+	return &modeTracker{changed: map[int]bool{}}
+}
+
+// Write follows the modes set in child output, so a modeTracker can sit in
+// an io.MultiWriter.
+func (t *modeTracker) Write(b []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.parser.Feed(b, func(seq ansiparse.Sequence) {
+		if seq.Kind == ansiparse.Esc && len(seq.Intermediates) == 0 && seq.Final == 'c' {
+			clear(t.changed) // full reset
+			return
+		}
+		if seq.Kind != ansiparse.CSI || seq.Private() != '?' || len(seq.Intermediates) > 0 ||
+			(seq.Final != 'h' && seq.Final != 'l') {
+			return
+		}
+		for _, mode := range seq.Ints() {
+			def, ok := trackedModes[mode]
+			switch on := seq.Final == 'h'; {
+			case !ok:
+			case on == def:
+				delete(t.changed, mode)
+			default:
+				t.changed[mode] = on
+			}
+		}
+	})
+	return len(b), nil
+}
+
+// restore returns the sequences putting back the modes the child left
+// changed, leaving the alternate screen first.
+func (t *modeTracker) restore() []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var b []byte
+	for _, mode := range []int{1049, 1047, 47} {
+		if t.changed[mode] {
+			b = fmt.Appendf(b, "\x1b[?%dl", mode)
+		}
+	}
+	for _, mode := range slices.Sorted(maps.Keys(t.changed)) {
+		switch {
+		case mode == 1049 || mode == 1047 || mode == 47:
+		case trackedModes[mode]:
+			b = fmt.Appendf(b, "\x1b[?%dh", mode)
+		default:
+			b = fmt.Appendf(b, "\x1b[?%dl", mode)
+		}
+	}
+	clear(t.changed)
+	return b
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/samuelstevens/claude-unfocused/internal/ansiparse"
+	"github.com/samuelstevens/claude-unfocused/pkg/ptyproxy"
+)
+
+// newlineModes are the accepted values of newline.output, how the child's
+// line ends reach the terminal: as they are (""), with a carriage return
+// before each bare line feed ("crlf"), or with the carriage return taken
+// off each CR LF ("lf").
+var newlineModes = map[string]bool{"": true, "crlf": true, "lf": true}
+
+// enterKeys are the accepted values of newline.enter, with what the Enter
+// key sends the child as each.
+var enterKeys = map[string][]byte{"cr": []byte("\r"), "lf": []byte("\n"), "crlf": []byte("\r\n")}
+
+// newlineWriter rewrites line ends on their way to w, for a transport
+// without the terminal line discipline that would: line feeds inside
+// escape sequences are left alone. In lf mode, a CR LF split across writes
+// is written as it is, rather than holding back every carriage return.
+type newlineWriter struct {
+	w      io.Writer
+	crlf   bool // or lf mode
+	parser ansiparse.Parser
+	cr     bool // the last byte written was a carriage return
+	buf    []byte
+}
+
+func newNewlineWriter(w io.Writer, mode string) *newlineWriter {
+	return &newlineWriter{w: w, crlf: mode == "crlf"}
+}
+
+func (n *newlineWriter) Write(p []byte) (int, error) {
+	n.buf = n.buf[:0]
+	inWrite := false // the carriage return came in this write
+	n.parser.Feed(p, func(seq ansiparse.Sequence) {
+		lf := seq.Kind == ansiparse.Control && seq.Raw[0] == '\n'
+		switch {
+		case lf && n.crlf && !n.cr:
+			n.buf = append(n.buf, '\r')
+		case lf && !n.crlf && n.cr && inWrite:
+			n.buf = n.buf[:len(n.buf)-1]
+		}
+		n.buf = append(n.buf, seq.Raw...)
+		n.cr = seq.Kind == ansiparse.Control && seq.Raw[0] == '\r'
+		inWrite = n.cr
+	})
+	if len(n.buf) > 0 {
+		if _, err := n.w.Write(n.buf); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// enterSession sends a session's input with enter, what one of the
+// enterKeys stands for, in place of each carriage return, which is what
+// the Enter key and line ends in pasted text come as.
+type enterSession struct {
+	ptyproxy.Session
+	enter []byte
+}
+
+func (s enterSession) Write(b []byte) (int, error) {
+	if _, err := s.Session.Write(bytes.ReplaceAll(b, []byte("\r"), s.enter)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
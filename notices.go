@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io"
+	"os"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/samuelstevens/claude-unfocused/internal/ansiparse"
+)
+
+// notices is where the wrapper's own writes to the terminal outside the
+// child's output, notices and bells, go to be shown in step with it.
+var notices = &noticeQueue{w: os.Stdout}
+
+// noticeQueue passes output on to the terminal and writes what the wrapper
+// has to show of its own between writes of it, never inside a sequence or
+// character a write left unfinished: that is held, in order, until output
+// finishes it.
+type noticeQueue struct {
+	mu      sync.Mutex
+	w       io.Writer
+	parser  ansiparse.Parser
+	midRune bool // the last write ended inside a UTF-8 character
+	held    [][]byte
+}
+
+// to sends output and notices to w from now on.
+func (q *noticeQueue) to(w io.Writer) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.w = w
+}
+
+func (q *noticeQueue) Write(b []byte) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n, err := q.w.Write(b)
+	q.follow(b[:n])
+	return n, err
+}
+
+// watch returns a writer following output that reaches the terminal some
+// other way, spliced by the proxy, so notices are still written between
+// sequences of it.
+func (q *noticeQueue) watch() io.Writer {
+	return noticeWatch{q}
+}
+
+type noticeWatch struct{ q *noticeQueue }
+
+func (w noticeWatch) Write(b []byte) (int, error) {
+	w.q.mu.Lock()
+	defer w.q.mu.Unlock()
+	w.q.follow(b)
+	return len(b), nil
+}
+
+// follow notes b having been written and writes what was held if that
+// finished it. The caller holds mu.
+func (q *noticeQueue) follow(b []byte) {
+	q.parser.Feed(b, func(ansiparse.Sequence) {})
+	if len(b) > 0 {
+		q.midRune = endsMidRune(b)
+	}
+	if q.between() {
+		for _, h := range q.held {
+			_, _ = q.w.Write(h)
+		}
+		q.held = nil
+	}
+}
+
+// write shows b as soon as the output is between sequences.
+func (q *noticeQueue) write(b []byte) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.between() {
+		q.held = append(q.held, b)
+		return
+	}
+	_, _ = q.w.Write(b)
+}
+
+// between reports whether the output so far ends between sequences and
+// characters. The caller holds mu.
+func (q *noticeQueue) between() bool {
+	return len(q.parser.Pending()) == 0 && !q.midRune
+}
+
+// endsMidRune reports whether b ends with the start of a UTF-8 character
+// the rest of which is still to come.
+func endsMidRune(b []byte) bool {
+	for i := len(b) - 1; i >= max(len(b)-utf8.UTFMax, 0); i-- {
+		if utf8.RuneStart(b[i]) {
+			return !utf8.FullRune(b[i:])
+		}
+	}
+	return false
+}
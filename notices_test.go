@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNoticeQueue(t *testing.T) {
+	tests := []struct {
+		name   string
+		before string // output written before the notice
+		after  string // output written after it
+		want   string
+	}{
+		{"idle", "hello", " there", "hello!! there"},
+		{"mid OSC", "\x1b]0;tit", "le\a", "\x1b]0;title\a!!"},
+		{"mid CSI", "\x1b[3", "1mred", "\x1b[31mred!!"},
+		{"mid rune", "caf\xc3", "\xa9", "caf\xc3\xa9!!"},
+		{"unfinished", "\x1b]0;title", "", "\x1b]0;title"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			q := &noticeQueue{w: &buf}
+			_, _ = q.Write([]byte(tt.before))
+			q.write([]byte("!!"))
+			_, _ = q.Write([]byte(tt.after))
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNoticeQueueWatch(t *testing.T) {
+	var buf bytes.Buffer
+	q := &noticeQueue{w: &buf}
+	w := q.watch()
+	_, _ = w.Write([]byte("\x1b]0;tit"))
+	q.write([]byte("!!"))
+	if buf.Len() != 0 {
+		t.Fatalf("wrote %q mid-sequence", buf.String())
+	}
+	_, _ = w.Write([]byte("le\a"))
+	if got := buf.String(); got != "!!" {
+		t.Errorf("got %q, want %q", got, "!!")
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// notify shows a desktop notification, using osascript on macOS and
+// notify-send elsewhere.
+func notify(title, body string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := "display notification " + appleQuote(body) + " with title " + appleQuote(title)
+		cmd = exec.Command("osascript", "-e", script)
+	case "windows":
+		return errors.ErrUnsupported
+	default:
+		cmd = exec.Command("notify-send", "--app-name=claude-unfocused", title, body)
+	}
+	return cmd.Run()
+}
+
+// appleQuote quotes s as an AppleScript string literal.
+func appleQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// commandName names the wrapped command in messages.
+func commandName(argv []string) string {
+	if len(argv) == 0 {
+		return "claude"
+	}
+	return filepath.Base(argv[0])
+}
+
+// idleNotifier sends a desktop notification when the child goes quiet after
+// producing output, which usually means it is waiting for a prompt or a
+// permission answer. It notifies once per burst of output. A nil
+// *idleNotifier does nothing.
+type idleNotifier struct {
+	mu    sync.Mutex
+	after time.Duration
+	name  string
+	alert func(msg string)
+	timer *time.Timer
+	armed bool // output arrived since the last notification
+}
+
+// newIdleNotifier returns an idleNotifier passing its message to alert, or
+// showing it on the desktop if alert is nil.
+func newIdleNotifier(after time.Duration, argv []string, alert func(msg string)) *idleNotifier {
+	if alert == nil {
+		alert = desktopAlert
+	}
+	return &idleNotifier{after: after, name: commandName(argv), alert: alert}
+}
+
+// desktopAlert shows msg as a desktop notification, or on the bottom line
+// if that fails.
+func desktopAlert(msg string) {
+	if err := notify("claude-unfocused", msg); err != nil {
+		notice("notification failed: " + err.Error())
+	}
+}
+
+// Write notes child output, so an idleNotifier can sit in an io.MultiWriter.
+func (n *idleNotifier) Write(b []byte) (int, error) {
+	n.activity(true)
+	return len(b), nil
+}
+
+// input notes bytes typed into the child. Typing restarts the idle clock
+// but does not by itself make the child worth waiting for.
+func (n *idleNotifier) input(b []byte) {
+	n.activity(false)
+}
+
+func (n *idleNotifier) activity(output bool) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if output {
+		n.armed = true
+	}
+	if n.timer == nil {
+		n.timer = time.AfterFunc(n.after, n.fire)
+	} else {
+		n.timer.Reset(n.after)
+	}
+}
+
+func (n *idleNotifier) fire() {
+	n.mu.Lock()
+	armed := n.armed
+	n.armed = false
+	n.mu.Unlock()
+	if !armed {
+		return
+	}
+	n.alert(n.name + " is waiting for input")
+}
+
+// Close stops the idle clock.
+func (n *idleNotifier) Close() {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.timer != nil {
+		n.timer.Stop()
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"sync"
+	"time"
+)
+
+// outputLogMagic starts every output log, followed by the start time as
+// nanoseconds since the Unix epoch, big-endian in 8 bytes.
+const outputLogMagic = "claude-unfocused output log 1\n"
+
+// outputLog writes the child's output exactly as it arrived, one record
+// per read: the time since the log started in nanoseconds, big-endian in
+// 8 bytes, the length of the data, big-endian in 4, then the data. Times
+// come from the monotonic clock, so they keep their order and spacing if
+// the wall clock jumps. With a redactor, secrets are masked within each
+// record, keeping its length. A nil *outputLog discards everything.
+type outputLog struct {
+	mu     sync.Mutex
+	f      *os.File
+	redact *redactor
+	start  time.Time
+}
+
+func newOutputLog(path string, redact *redactor) (*outputLog, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	header := binary.BigEndian.AppendUint64([]byte(outputLogMagic), uint64(start.UnixNano()))
+	if _, err := f.Write(header); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &outputLog{f: f, redact: redact, start: start}, nil
+}
+
+// Write logs child output, so an outputLog can sit in an io.MultiWriter.
+func (o *outputLog) Write(b []byte) (int, error) {
+	if o == nil || len(b) == 0 {
+		return len(b), nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.f == nil {
+		return len(b), nil
+	}
+	rec := make([]byte, 0, 12+len(b))
+	rec = binary.BigEndian.AppendUint64(rec, uint64(time.Since(o.start)))
+	rec = binary.BigEndian.AppendUint32(rec, uint32(len(b)))
+	rec = append(rec, o.redact.mask(b)...)
+	_, err := o.f.Write(rec)
+	return len(b), err
+}
+
+func (o *outputLog) Close() error {
+	if o == nil {
+		return nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	err := o.f.Close()
+	o.f = nil
+	return err
+}
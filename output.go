@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io"
+
+	"github.com/samuelstevens/claude-unfocused/internal/ansiparse"
+)
+
+// outputRule may rewrite one sequence of child output. It returns the bytes
+// to write in its place and whether it handled seq; unhandled sequences go
+// on to the next rule and are finally written as they were.
+type outputRule func(seq ansiparse.Sequence) ([]byte, bool)
+
+// outputFilter rewrites child output on its way to w, one sequence at a
+// time. A sequence split across writes is held until the rest arrives,
+// which is normally the child's very next write.
+type outputFilter struct {
+	w      io.Writer
+	parser ansiparse.Parser
+	rules  []outputRule
+	buf    []byte
+}
+
+func newOutputFilter(w io.Writer, rules ...outputRule) *outputFilter {
+	return &outputFilter{w: w, rules: rules}
+}
+
+func (f *outputFilter) Write(p []byte) (int, error) {
+	f.buf = f.buf[:0]
+	f.parser.Feed(p, func(seq ansiparse.Sequence) {
+		for _, rule := range f.rules {
+			if b, ok := rule(seq); ok {
+				f.buf = append(f.buf, b...)
+				return
+			}
+		}
+		f.buf = append(f.buf, seq.Raw...)
+	})
+	if len(f.buf) > 0 {
+		if _, err := f.w.Write(f.buf); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
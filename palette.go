@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/samuelstevens/claude-unfocused/internal/vt"
+	"github.com/samuelstevens/claude-unfocused/pkg/escfilter"
+	"github.com/samuelstevens/claude-unfocused/pkg/ptyproxy"
+)
+
+// paletteRows is how many entries the palette lists at most.
+const paletteRows = 10
+
+// paletteEntry is something the palette can do: run a wrapper action, or
+// type a snippet into the child.
+type paletteEntry struct {
+	name   string
+	keys   string // the chord bound to it, if any
+	action escfilter.Action
+	text   []byte
+}
+
+// paletteEntries lists the wrapper's actions and snippets, with the chords
+// cfg binds them to.
+func paletteEntries(cfg config) []paletteEntry {
+	k := cfg.Keys
+	entries := []paletteEntry{
+		{name: "toggle focus filter", keys: k.ToggleFilter, action: actionToggleFilter},
+		{name: "toggle status line", keys: k.ToggleStatus, action: actionToggleStatus},
+		{name: "search scrollback", keys: k.Search, action: actionSearch},
+		{name: "copy mode", keys: k.CopyMode, action: actionCopyMode},
+		{name: "new session", keys: k.NewSession, action: actionNewSession},
+		{name: "next session", keys: k.NextSession, action: actionNextSession},
+		{name: "previous session", keys: k.PrevSession, action: actionPrevSession},
+		{name: "dump screen", keys: k.DumpScreen, action: actionDumpScreen},
+		{name: "rotate log file", action: actionRotateLogs},
+		{name: "detach", keys: k.Detach, action: actionDetach},
+		{name: "suspend", keys: k.Suspend, action: actionSuspend},
+		{name: "show key bindings", keys: k.Help, action: actionHelp},
+		{name: "quit", keys: k.Quit, action: actionQuit},
+	}
+	for _, chord := range slices.Sorted(maps.Keys(cfg.Snippets)) {
+		text := cfg.Snippets[chord]
+		name, _, _ := strings.Cut(strings.TrimSpace(text), "\n")
+		entries = append(entries, paletteEntry{name: "type: " + name, keys: chord, text: []byte(text)})
+	}
+	for i := range entries {
+		entries[i].keys = k.expand(entries[i].keys)
+	}
+	return entries
+}
+
+// palette is a menu of everything the wrapper can do, shown in a box over
+// the child's screen with the entries matching what has been typed, fuzzily.
+// The child's output is held back while it is open, and the screen is
+// repainted from the virtual terminal when it closes. A nil *palette does
+// nothing.
+type palette struct {
+	screen  *vt.Screen
+	gate    *outputGate
+	w       io.Writer // the terminal
+	entries []paletteEntry
+	session ptyproxy.Session
+	capture func(func([]byte) bool)
+	act     func(escfilter.Action) // runs an action as if its hotkey was typed
+
+	mu         sync.Mutex
+	open       bool
+	keys       keyReader
+	query      []rune
+	shown      []paletteEntry // the entries matching query, best first
+	selected   int
+	cols, rows int
+}
+
+// show opens the palette. It does nothing while it is open.
+func (p *palette) show() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.open {
+		return
+	}
+	p.cols, p.rows = p.screen.Size()
+	if cols, rows, err := ptyproxy.TermSize(); err == nil && cols > 0 && rows > 0 {
+		p.cols, p.rows = cols, rows
+	}
+	p.keys, p.query = keyReader{}, nil
+	p.filter()
+	p.open = true
+	p.gate.hold()
+	p.capture(p.input)
+	p.draw()
+}
+
+// close repaints the child's screen. The caller holds mu.
+func (p *palette) close() {
+	p.open = false
+	p.capture(nil)
+	p.gate.release()
+	// The repaint has the text; the child's redraw brings back its colors
+	_, _ = p.w.Write(p.screen.Redraw())
+	ptyproxy.Refresh(p.session)
+}
+
+// resize redraws the palette for a resized terminal.
+func (p *palette) resize(cols, rows int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.open {
+		return
+	}
+	p.cols, p.rows = cols, rows
+	_, _ = p.w.Write(p.screen.Redraw())
+	p.draw()
+}
+
+// input handles terminal input while the palette is open, which takes all
+// of it, running the entry chosen.
+func (p *palette) input(b []byte) bool {
+	// Unlocked, since the action may open the palette again
+	if entry := p.feed(b); entry != nil {
+		if entry.text != nil {
+			_, _ = p.session.Write(entry.text)
+		} else {
+			p.act(entry.action)
+		}
+	}
+	return true
+}
+
+// feed handles keys, returning the entry chosen with enter, if that closed
+// the palette.
+func (p *palette) feed(b []byte) *paletteEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var chosen *paletteEntry
+	p.keys.feed(b, func(key string) {
+		if !p.open {
+			return
+		}
+		switch key {
+		case "esc", "ctrl-c", "ctrl-g":
+			p.close()
+		case "enter":
+			if p.selected < len(p.shown) {
+				entry := p.shown[p.selected]
+				chosen = &entry
+			}
+			p.close()
+		case "up", "ctrl-p", "ctrl-k":
+			p.selected = max(p.selected-1, 0)
+		case "down", "ctrl-n", "ctrl-j", "tab":
+			p.selected = min(p.selected+1, max(len(p.shown)-1, 0))
+		case "backspace":
+			if len(p.query) > 0 {
+				p.query = p.query[:len(p.query)-1]
+				p.filter()
+			}
+		case "ctrl-u":
+			p.query = nil
+			p.filter()
+		default:
+			if r := []rune(key); len(r) == 1 && unicode.IsPrint(r[0]) {
+				p.query = append(p.query, r[0])
+				p.filter()
+			}
+		}
+	})
+	if p.open {
+		p.draw()
+	}
+	return chosen
+}
+
+// filter lists the entries matching the query, best first. The caller
+// holds mu.
+func (p *palette) filter() {
+	type scored struct {
+		entry paletteEntry
+		score int
+	}
+	var matches []scored
+	for _, e := range p.entries {
+		if score, ok := fuzzyScore(e.name, string(p.query)); ok {
+			matches = append(matches, scored{e, score})
+		}
+	}
+	slices.SortStableFunc(matches, func(a, b scored) int { return cmp.Compare(a.score, b.score) })
+	p.shown = p.shown[:0]
+	for _, m := range matches {
+		p.shown = append(p.shown, m.entry)
+	}
+	p.selected = 0
+}
+
+// fuzzyScore reports whether the characters of query appear in text in
+// order, ignoring case, and how loosely: the lower the score, the earlier
+// and closer together they are.
+func fuzzyScore(text, query string) (int, bool) {
+	text, query = strings.ToLower(text), strings.ToLower(query)
+	if query == "" {
+		return 0, true
+	}
+	first, _ := utf8.DecodeRuneInString(query)
+	best, found := 0, false
+	// Try each place the first character appears, keeping the tightest
+	for start, r := range text {
+		if r != first {
+			continue
+		}
+		end := start
+		for _, want := range query {
+			j := strings.IndexRune(text[end:], want)
+			if j < 0 {
+				return best, found // nor will any later start
+			}
+			end += j + utf8.RuneLen(want)
+		}
+		if score := end - start + start/4; !found || score < best {
+			best, found = score, true
+		}
+	}
+	return best, found
+}
+
+// draw paints the palette: a box a third of the way down, with the query
+// on top and the matching entries under it. The caller holds mu.
+func (p *palette) draw() {
+	width := min(p.cols-2, 64)
+	if width < 8 || p.rows < 4 {
+		return
+	}
+	// The box keeps its size as the list is filtered
+	rows := min(len(p.entries), paletteRows, p.rows-3)
+	top := max((p.rows-rows-3)/3, 0) + 1
+	left := (p.cols-width)/2 + 1
+	inner := width - 4
+	first := max(p.selected-rows+1, 0) // keep the selection in view
+
+	var b bytes.Buffer
+	line := func(y int, text string) {
+		fmt.Fprintf(&b, "\x1b[%d;%dH\x1b[0m%s", y, left, text)
+	}
+	line(top, "┌"+strings.Repeat("─", width-2)+"┐")
+	query := []rune(string(p.query))
+	query = query[max(len(query)-inner+2, 0):] // keep the end in view
+	line(top+1, "│ "+padRight("> "+string(query), inner)+" │")
+	for i := range rows {
+		var text string
+		switch n := first + i; {
+		case n < len(p.shown):
+			e := p.shown[n]
+			keys := fitWidth(e.keys, inner/3)
+			name := fitWidth(e.name, inner-utf8.RuneCountInString(keys)-1)
+			text = padRight(name, inner-utf8.RuneCountInString(keys)) + keys
+			if n == p.selected {
+				text = "\x1b[7m" + text + "\x1b[27m"
+			}
+		case i == 0:
+			text = padRight("no match", inner)
+		default:
+			text = strings.Repeat(" ", inner)
+		}
+		line(top+2+i, "│ "+text+" │")
+	}
+	line(top+2+rows, "└"+strings.Repeat("─", width-2)+"┘")
+	// The cursor sits at the end of the query
+	fmt.Fprintf(&b, "\x1b[0m\x1b[%d;%dH", top+1, left+4+len(query))
+	_, _ = p.w.Write(b.Bytes())
+}
+
+// padRight cuts or pads text with spaces to n characters.
+func padRight(text string, n int) string {
+	text = fitWidth(text, n)
+	return text + strings.Repeat(" ", max(n-utf8.RuneCountInString(text), 0))
+}
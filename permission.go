@@ -0,0 +1,88 @@
+package main
+
+import (
+	"regexp"
+	"sync"
+)
+
+// defaultPermissionPatterns match the prompts claude shows before using a
+// tool it needs permission for.
+var defaultPermissionPatterns = []string{
+	`Do you want to (allow|proceed|make this edit|create|run|fetch)`,
+}
+
+// promptWatcher scans the child's output for permission prompts and alerts
+// once per prompt: having alerted, it waits for input, the prompt's answer,
+// before alerting again. Lines are matched as plainLines. A nil
+// *promptWatcher does nothing.
+type promptWatcher struct {
+	patterns []*regexp.Regexp
+	alert    func(msg string)
+	bell     bool
+	name     string
+
+	mu      sync.Mutex
+	lines   plainLines
+	waiting bool // alerted, and no input since
+}
+
+func newPromptWatcher(patterns []*regexp.Regexp, alert func(msg string), bell bool, argv []string) *promptWatcher {
+	return &promptWatcher{patterns: patterns, alert: alert, bell: bell, name: commandName(argv)}
+}
+
+// permissionPatterns compiles patterns, or the defaults if there are none.
+func permissionPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	if len(patterns) == 0 {
+		patterns = defaultPermissionPatterns
+	}
+	res := make([]*regexp.Regexp, len(patterns))
+	for i, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = re
+	}
+	return res, nil
+}
+
+// Write scans child output, so a promptWatcher can sit in an io.MultiWriter.
+func (w *promptWatcher) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	found := false
+	w.lines.feed(b, func(line []byte) {
+		found = found || w.match(line)
+	})
+	alert := found && !w.waiting
+	if found {
+		w.waiting = true
+	}
+	w.mu.Unlock()
+
+	if alert {
+		w.alert(w.name + " is asking for permission")
+		if w.bell {
+			notices.write([]byte{'\a'})
+		}
+	}
+	return len(b), nil
+}
+
+func (w *promptWatcher) match(line []byte) bool {
+	for _, re := range w.patterns {
+		if re.Match(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// input notes bytes written to the child, which answer a prompt.
+func (w *promptWatcher) input(b []byte) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.waiting = false
+}
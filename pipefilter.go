@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"sync/atomic"
+	"time"
+
+	"github.com/samuelstevens/claude-unfocused/pkg/ptyproxy"
+)
+
+// pipeFilter passes a byte stream through an external command, run by the
+// shell: what is written to it goes to the command's stdin, and what the
+// command prints goes on to next. The command's stderr goes to the log.
+// Should the command exit or stop reading, the stream goes on to next
+// unchanged.
+type pipeFilter struct {
+	command string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	next    io.Writer
+	copied  chan struct{}
+	failed  atomic.Bool
+}
+
+func newPipeFilter(command string, next io.Writer) (*pipeFilter, error) {
+	cmd := shellCommand(command)
+	cmd.Stderr = log.Writer()
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("filter %q: %w", command, err)
+	}
+	f := &pipeFilter{command: command, cmd: cmd, stdin: stdin, next: next, copied: make(chan struct{})}
+	go func() {
+		_, _ = io.Copy(next, stdout)
+		close(f.copied)
+	}()
+	return f, nil
+}
+
+// pipeFilters chains commands in front of next, the first command seeing
+// the stream first. It returns the writer to feed and a function stopping
+// the commands.
+func pipeFilters(commands []string, next io.Writer) (io.Writer, func(), error) {
+	var started []*pipeFilter
+	stop := func() {
+		// From the front, so each command sees the end of its input
+		for i := len(started) - 1; i >= 0; i-- {
+			_ = started[i].Close()
+		}
+	}
+	for i := len(commands) - 1; i >= 0; i-- {
+		f, err := newPipeFilter(commands[i], next)
+		if err != nil {
+			stop()
+			return nil, nil, err
+		}
+		started = append(started, f)
+		next = f
+	}
+	return next, stop, nil
+}
+
+func (f *pipeFilter) Write(b []byte) (int, error) {
+	if !f.failed.Load() {
+		if _, err := f.stdin.Write(b); err == nil {
+			return len(b), nil
+		}
+		if !f.failed.Swap(true) {
+			notice(fmt.Sprintf("filter %q stopped, passing the stream through", f.command))
+		}
+	}
+	return f.next.Write(b)
+}
+
+// Close ends the command's input and waits for it to finish its output,
+// killing it if it takes more than a second.
+func (f *pipeFilter) Close() error {
+	_ = f.stdin.Close()
+	select {
+	case <-f.copied:
+	case <-time.After(time.Second):
+		_ = f.cmd.Process.Kill()
+		<-f.copied
+	}
+	return f.cmd.Wait()
+}
+
+// pipedSession sends a session's input through a command.
+type pipedSession struct {
+	ptyproxy.Session
+	input io.Writer
+}
+
+func (s pipedSession) Write(b []byte) (int, error) {
+	return s.input.Write(b)
+}
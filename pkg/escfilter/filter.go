@@ -0,0 +1,395 @@
+// Package escfilter filters terminal input on its way to a child program,
+// swallowing the reports a terminal sends unprompted (focus changes, and
+// optionally mouse events) and recognizing hotkeys, while passing everything
+// else through byte for byte.
+//
+// A Filter is fed raw input as it is read and writes what should reach the
+// child:
+//
+//	f := escfilter.New(escfilter.Options{
+//		Focus:   true,
+//		Hotkeys: []escfilter.Hotkey{{Keys: []byte{0x1c}, Action: quit}},
+//	})
+//	f.Process(data, func(b []byte) { child.Write(b) }, func(a escfilter.Action) {
+//		// handle the hotkey
+//	})
+//
+// Sequences split across reads are held back until the rest arrives. If no
+// more input comes, the caller should forward Flush's bytes after a short
+// timeout, so that a lone ESC still reaches the child.
+//
+// The child's output should be passed to Observe, which tracks the terminal
+// modes the child has asked for.
+package escfilter
+
+import (
+	"bytes"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/samuelstevens/claude-unfocused/internal/ansiparse"
+)
+
+// Options configure a Filter.
+type Options struct {
+	// Focus swallows focus-in and focus-out reports (ESC[I, ESC[O) while
+	// the child has focus reporting enabled. Otherwise they were not asked
+	// for and pass through untouched.
+	Focus bool
+	// Mouse swallows SGR, urxvt and X10 mouse reports.
+	Mouse bool
+	// ForceFocused makes the child believe it always has focus: each time
+	// it enables focus reporting it is sent a focus-in report, and
+	// focus-out reports never reach it while focus reporting is enabled.
+	ForceFocused bool
+	// WrapBursts, if positive, treats a read of at least this many bytes
+	// of plain text as a paste from a terminal that doesn't bracket them:
+	// while the child has bracketed paste enabled, it is forwarded wrapped
+	// in paste markers, and untouched by hotkeys.
+	WrapBursts int
+	// Hotkeys are reported to the action callback, or remapped, instead of
+	// forwarded.
+	Hotkeys []Hotkey
+	// WheelUp and WheelDown, if set, are reported to the action callback
+	// for SGR mouse wheel reports while SetWheel is on, when other mouse
+	// reports are swallowed: for a caller that turns mouse reporting on
+	// itself, to scroll with the wheel.
+	WheelUp, WheelDown Action
+	// Replies, if set, is passed each of the terminal's replies to the
+	// child's queries, named as ansiparse.Sequence.Reply names them, and
+	// returns what to forward in its place.
+	Replies func(name string, raw []byte) []byte
+	// Trace, if set, is told what the filter did with each sequence.
+	Trace func(Event)
+}
+
+// Event describes a filter decision for tracing. Action is one of
+// "forward", "swallow", "paste", "hotkey", "remap", "timeout", "inject" or
+// "reply" (the terminal answering a query of the child's); Kind names the
+// sequence type, or for a reply the query answered, as
+// ansiparse.Sequence.Reply does. Raw is only valid during the call.
+type Event struct {
+	Action string
+	Kind   string
+	Raw    []byte
+}
+
+// Filter decides, sequence by sequence, which input bytes reach the child.
+// Process, Pending, Flush and Pasting must be called from one goroutine and
+// Observe from one other; the filter toggles may be flipped from any.
+type Filter struct {
+	parser       ansiparse.Parser
+	output       ansiparse.Parser // for Observe
+	focus        atomic.Bool
+	mouse        atomic.Bool
+	wheel        atomic.Bool
+	wheelUp      Action
+	wheelDown    Action
+	forceFocused bool
+	reporting    atomic.Bool // the child has enabled focus reporting
+	bracketed    atomic.Bool // the child has enabled bracketed paste
+	wrapBursts   int
+	keys         keyMatcher
+	replies      func(name string, raw []byte) []byte
+	trace        func(Event)
+	skip         int          // payload bytes of an X10 mouse report still to drop
+	paste        bool         // inside a bracketed paste
+	queried      atomic.Int64 // when the child last queried the terminal, in Unix nanoseconds
+}
+
+// replyWindow is how long after the child queries the terminal input that
+// looks like a reply is taken for one.
+const replyWindow = 2 * time.Second
+
+// focusIn is the report a terminal sends when it gains focus.
+var focusIn = []byte("\x1b[I")
+
+// pasteStart and pasteEnd bracket a paste.
+var (
+	pasteStart = []byte("\x1b[200~")
+	pasteEnd   = []byte("\x1b[201~")
+)
+
+// New returns a Filter configured by opts.
+func New(opts Options) *Filter {
+	f := &Filter{keys: keyMatcher{hotkeys: opts.Hotkeys}, forceFocused: opts.ForceFocused, wrapBursts: opts.WrapBursts, trace: opts.Trace,
+		replies: opts.Replies, wheelUp: opts.WheelUp, wheelDown: opts.WheelDown}
+	f.focus.Store(opts.Focus)
+	f.mouse.Store(opts.Mouse)
+	return f
+}
+
+// Focus reports whether focus reports are being swallowed.
+func (f *Filter) Focus() bool { return f.focus.Load() }
+
+// SetFocus turns swallowing of focus reports on or off.
+func (f *Filter) SetFocus(on bool) { f.focus.Store(on) }
+
+// Mouse reports whether mouse reports are being swallowed.
+func (f *Filter) Mouse() bool { return f.mouse.Load() }
+
+// SetMouse turns swallowing of mouse reports on or off.
+func (f *Filter) SetMouse(on bool) { f.mouse.Store(on) }
+
+// SetWheel turns reporting of the mouse wheel to the action callback on or
+// off. It has no effect without Options.WheelUp and WheelDown.
+func (f *Filter) SetWheel(on bool) { f.wheel.Store(on && f.wheelUp != 0 && f.wheelDown != 0) }
+
+// FocusReporting reports whether the child's output has enabled focus
+// reporting (DECSET 1004).
+func (f *Filter) FocusReporting() bool { return f.reporting.Load() }
+
+// Process parses data and passes the bytes to forward to write. Hotkeys are
+// reported to action after everything before them has been written.
+func (f *Filter) Process(data []byte, write func([]byte), action func(Action)) {
+	if f.isBurst(data) {
+		f.traceRaw("paste", "Burst", data)
+		write(slices.Concat(f.keys.flush(nil), pasteStart, data, pasteEnd))
+		return
+	}
+	var out []byte
+	f.parser.Feed(data, func(seq ansiparse.Sequence) {
+		// Pasted text goes through untouched, hotkeys included
+		if f.paste || isPasteGuard(seq, 200) {
+			f.paste = !isPasteGuard(seq, 201)
+			f.traceSeq("paste", seq)
+			out = append(out, seq.Raw...)
+			return
+		}
+		if f.skip > 0 {
+			seq.Raw = f.skipPayload(seq)
+			if len(seq.Raw) == 0 {
+				return
+			}
+		}
+		if seq.Kind == ansiparse.Text || seq.Kind == ansiparse.Control {
+			for _, b := range seq.Raw {
+				var hk *Hotkey
+				n := len(out)
+				if out, hk = f.keys.feed(out, b); hk == nil {
+					if seq.Kind == ansiparse.Control && len(out) > n {
+						f.traceSeq("forward", seq)
+					}
+					continue
+				}
+				if hk.Send != nil {
+					f.traceRaw("remap", "Keys", hk.Send)
+					out = append(out, hk.Send...)
+					continue
+				}
+				f.traceRaw("hotkey", "Keys", []byte{b})
+				if len(out) > 0 {
+					write(out)
+					out = nil
+				}
+				action(hk.Action)
+			}
+			return
+		}
+		out = f.keys.flush(out)
+		if f.awaitingReply() && seq.Reply() != "" {
+			// Never taken for a key or a report to swallow
+			f.traceRaw("reply", seq.Reply(), seq.Raw)
+			if f.replies != nil {
+				out = append(out, f.replies(seq.Reply(), seq.Raw)...)
+				return
+			}
+			out = append(out, seq.Raw...)
+			return
+		}
+		if f.wheel.Load() && isMouseEvent(seq) {
+			if a := f.wheelAction(seq); a != 0 {
+				f.traceSeq("hotkey", seq)
+				if len(out) > 0 {
+					write(out)
+					out = nil
+				}
+				action(a)
+				return
+			}
+			if len(seq.Params) == 0 {
+				f.skip = 3 // X10 payload
+			}
+			f.traceSeq("swallow", seq)
+			return
+		}
+		if f.swallow(seq) {
+			f.traceSeq("swallow", seq)
+			return
+		}
+		f.traceSeq("forward", seq)
+		out = append(out, seq.Raw...)
+	})
+	if len(out) > 0 {
+		write(out)
+	}
+}
+
+// isBurst reports whether data should be wrapped as a paste.
+func (f *Filter) isBurst(data []byte) bool {
+	return f.wrapBursts > 0 && len(data) >= f.wrapBursts && !f.paste && f.bracketed.Load() &&
+		len(f.parser.Pending()) == 0 && !slices.Contains(data, 0x1b)
+}
+
+// Pasting reports whether the input so far ended inside a bracketed paste.
+func (f *Filter) Pasting() bool { return f.paste }
+
+// Pending reports whether an incomplete sequence is being held back and
+// should be flushed if no more input arrives. Bytes held inside a bracketed
+// paste are never flushed early; the rest of the paste completes them.
+func (f *Filter) Pending() bool {
+	return !f.paste && len(f.parser.Pending()) > 0
+}
+
+// PendingReply reports whether the incomplete sequence being held back may
+// be the start of the terminal's reply to a query, which can arrive in
+// pieces over a slow link and should be given longer to complete than a
+// keypress.
+func (f *Filter) PendingReply() bool {
+	pending := f.parser.Pending()
+	return f.Pending() && len(pending) > 1 && bytes.IndexByte([]byte("[]P"), pending[1]) >= 0 && f.awaitingReply()
+}
+
+// awaitingReply reports whether the child has queried the terminal lately.
+func (f *Filter) awaitingReply() bool {
+	return time.Since(time.Unix(0, f.queried.Load())) < replyWindow
+}
+
+// Flush returns the held-back bytes of an incomplete sequence so they can be
+// forwarded as ordinary keypresses (e.g. a lone ESC for vim mode).
+func (f *Filter) Flush() []byte {
+	data := append([]byte(nil), f.parser.Pending()...)
+	f.parser.Reset()
+	f.traceRaw("timeout", "Pending", data)
+	return data
+}
+
+// Observe watches the child's output for focus reporting being switched on
+// or off, which decides whether focus reports are filtered, and likewise
+// bracketed paste. With ForceFocused, write is passed the focus-in report to
+// send the child each time focus reporting is switched on.
+func (f *Filter) Observe(data []byte, write func([]byte)) {
+	f.output.Feed(data, func(seq ansiparse.Sequence) {
+		if seq.Query() != "" {
+			f.queried.Store(time.Now().UnixNano())
+			return
+		}
+		if seq.Kind == ansiparse.Esc && len(seq.Intermediates) == 0 && seq.Final == 'c' {
+			// Full reset (RIS)
+			f.reporting.Store(false)
+			f.bracketed.Store(false)
+			return
+		}
+		if on, ok := decMode(seq, 2004); ok {
+			f.bracketed.Store(on)
+		}
+		on, ok := decMode(seq, 1004)
+		if !ok {
+			return
+		}
+		if was := f.reporting.Swap(on); on && !was && f.forceFocused {
+			f.traceRaw("inject", "CSI", focusIn)
+			write(focusIn)
+		}
+	})
+}
+
+// swallow reports whether seq should be dropped instead of forwarded.
+func (f *Filter) swallow(seq ansiparse.Sequence) bool {
+	if isFocusEvent(seq) && f.reporting.Load() && (f.focus.Load() || f.forceFocused && seq.Final == 'O') {
+		return true
+	}
+	if f.mouse.Load() && isMouseEvent(seq) {
+		if len(seq.Params) == 0 {
+			// X10 report: the button and coordinates follow as raw bytes
+			f.skip = 3
+		}
+		return true
+	}
+	return false
+}
+
+// wheelAction returns the action for seq if it is an SGR wheel report, with
+// or without modifiers, or else 0.
+func (f *Filter) wheelAction(seq ansiparse.Sequence) Action {
+	ints := seq.Ints()
+	if seq.Private() != '<' || seq.Final != 'M' || len(ints) != 3 {
+		return 0
+	}
+	switch ints[0] &^ (4 | 8 | 16) { // shift, meta, ctrl
+	case 64:
+		return f.wheelUp
+	case 65:
+		return f.wheelDown
+	}
+	return 0
+}
+
+// skipPayload drops the pending X10 mouse payload from the start of seq and
+// returns what is left to forward.
+func (f *Filter) skipPayload(seq ansiparse.Sequence) []byte {
+	if seq.Kind != ansiparse.Text && seq.Kind != ansiparse.Control {
+		// Payload bytes are never ESC, so the report was cut short
+		f.skip = 0
+		return seq.Raw
+	}
+	n := min(f.skip, len(seq.Raw))
+	f.skip -= n
+	f.traceRaw("swallow", "X10", seq.Raw[:n])
+	return seq.Raw[n:]
+}
+
+// traceSeq reports seq to the trace hook unless it is plain text.
+func (f *Filter) traceSeq(action string, seq ansiparse.Sequence) {
+	if seq.Kind != ansiparse.Text {
+		f.traceRaw(action, seq.Kind.String(), seq.Raw)
+	}
+}
+
+func (f *Filter) traceRaw(action, kind string, b []byte) {
+	if f.trace != nil {
+		f.trace(Event{Action: action, Kind: kind, Raw: b})
+	}
+}
+
+// decMode reports whether seq sets (ESC[?nh) or resets (ESC[?nl) DEC
+// private mode n, and ok if it does either.
+func decMode(seq ansiparse.Sequence, n int) (set, ok bool) {
+	if seq.Kind != ansiparse.CSI || seq.Private() != '?' || len(seq.Intermediates) > 0 ||
+		(seq.Final != 'h' && seq.Final != 'l') {
+		return false, false
+	}
+	return seq.Final == 'h', slices.Contains(seq.Ints(), n)
+}
+
+// isFocusEvent matches the focus-in (ESC[I) and focus-out (ESC[O) reports.
+func isFocusEvent(seq ansiparse.Sequence) bool {
+	return seq.Kind == ansiparse.CSI && len(seq.Params) == 0 && len(seq.Intermediates) == 0 &&
+		(seq.Final == 'I' || seq.Final == 'O')
+}
+
+// isPasteGuard matches the bracketed paste start (ESC[200~) or end
+// (ESC[201~) marker given by n.
+func isPasteGuard(seq ansiparse.Sequence, n int) bool {
+	if seq.Kind != ansiparse.CSI || seq.Final != '~' || seq.Private() != 0 || len(seq.Intermediates) > 0 {
+		return false
+	}
+	ints := seq.Ints()
+	return len(ints) == 1 && ints[0] == n
+}
+
+// isMouseEvent matches SGR (ESC[<b;x;yM/m), urxvt (ESC[b;x;yM) and the
+// prefix of X10 (ESC[M) mouse reports.
+func isMouseEvent(seq ansiparse.Sequence) bool {
+	if seq.Kind != ansiparse.CSI || len(seq.Intermediates) > 0 {
+		return false
+	}
+	switch seq.Private() {
+	case '<':
+		return (seq.Final == 'M' || seq.Final == 'm') && len(seq.Ints()) == 3
+	case 0:
+		return seq.Final == 'M' && (len(seq.Params) == 0 || len(seq.Ints()) == 3)
+	}
+	return false
+}
@@ -0,0 +1,482 @@
+package escfilter_test
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/samuelstevens/claude-unfocused/pkg/escfilter"
+)
+
+// Actions reported by the hotkeys in the tests.
+const (
+	quit escfilter.Action = iota + 1
+	toggle
+)
+
+// hotkeys are Ctrl-\ to quit, Ctrl-] f to toggle and Ctrl-A remapped to
+// Ctrl-B.
+var hotkeys = []escfilter.Hotkey{
+	{Keys: []byte{0x1c}, Action: quit},
+	{Keys: []byte{0x1d, 'f'}, Action: toggle},
+	{Keys: []byte{0x01}, Send: []byte{0x02}},
+}
+
+// run has f observe the child's output, then feeds it chunks as separate
+// reads, then flushes what is still held at the end, as the proxy does once
+// input goes quiet, or as the rest of an unfinished paste would. It returns
+// what reached the child, with any focus-in reports f injected, and the
+// actions reported.
+func run(f *escfilter.Filter, output string, chunks ...string) (string, []escfilter.Action) {
+	var got []byte
+	var actions []escfilter.Action
+	write := func(b []byte) { got = append(got, b...) }
+	f.Observe([]byte(output), write)
+	for _, chunk := range chunks {
+		f.Process([]byte(chunk), write, func(a escfilter.Action) { actions = append(actions, a) })
+	}
+	if f.Pending() || f.Pasting() {
+		got = append(got, f.Flush()...)
+	}
+	return string(got), actions
+}
+
+func TestProcess(t *testing.T) {
+	const reporting = "\x1b[?1004h"
+	tests := []struct {
+		name    string
+		opts    escfilter.Options
+		output  string // the child's, observed first
+		wheel   bool
+		chunks  []string
+		want    string
+		actions []escfilter.Action
+	}{
+		{
+			name:   "text",
+			opts:   escfilter.Options{Focus: true},
+			chunks: []string{"hello, world\r"},
+			want:   "hello, world\r",
+		},
+		{
+			name:   "controls and keys",
+			opts:   escfilter.Options{Focus: true},
+			chunks: []string{"\x03\x1a\t\x7f\x1b[A\x1b[1;5C\x1bOP"},
+			want:   "\x03\x1a\t\x7f\x1b[A\x1b[1;5C\x1bOP",
+		},
+		{
+			name:   "utf-8",
+			opts:   escfilter.Options{Focus: true},
+			chunks: []string{"h\xc3", "\xa9llo \xe2\x9c", "\x93"},
+			want:   "héllo ✓",
+		},
+		{
+			name:   "focus reports without reporting",
+			opts:   escfilter.Options{Focus: true},
+			chunks: []string{"a\x1b[Ib\x1b[Oc"},
+			want:   "a\x1b[Ib\x1b[Oc",
+		},
+		{
+			name:   "focus reports with reporting",
+			opts:   escfilter.Options{Focus: true},
+			output: reporting,
+			chunks: []string{"a\x1b[Ib\x1b[Oc"},
+			want:   "abc",
+		},
+		{
+			name:   "focus filter off",
+			opts:   escfilter.Options{},
+			output: reporting,
+			chunks: []string{"\x1b[I\x1b[O"},
+			want:   "\x1b[I\x1b[O",
+		},
+		{
+			name:   "reporting among other modes",
+			opts:   escfilter.Options{Focus: true},
+			output: "\x1b[?1049;1004;2004h",
+			chunks: []string{"\x1b[I"},
+			want:   "",
+		},
+		{
+			name:   "reporting turned off",
+			opts:   escfilter.Options{Focus: true},
+			output: reporting + "\x1b[?1004l",
+			chunks: []string{"\x1b[I"},
+			want:   "\x1b[I",
+		},
+		{
+			name:   "reporting reset",
+			opts:   escfilter.Options{Focus: true},
+			output: reporting + "\x1bc",
+			chunks: []string{"\x1b[O"},
+			want:   "\x1b[O",
+		},
+		{
+			name:   "focus report split byte by byte",
+			opts:   escfilter.Options{Focus: true},
+			output: reporting,
+			chunks: []string{"x\x1b", "[", "I", "y"},
+			want:   "xy",
+		},
+		{
+			name:   "focus report split after ESC",
+			opts:   escfilter.Options{Focus: true},
+			output: reporting,
+			chunks: []string{"\x1b", "[O"},
+			want:   "",
+		},
+		{
+			name:   "lone ESC",
+			opts:   escfilter.Options{Focus: true},
+			output: reporting,
+			chunks: []string{"\x1b"},
+			want:   "\x1b",
+		},
+		{
+			name:   "ESC before a focus report",
+			opts:   escfilter.Options{Focus: true},
+			output: reporting,
+			chunks: []string{"\x1b\x1b[I"},
+			want:   "\x1b",
+		},
+		{
+			name:   "ESC after a focus report",
+			opts:   escfilter.Options{Focus: true},
+			output: reporting,
+			chunks: []string{"\x1b[O\x1b"},
+			want:   "\x1b",
+		},
+		{
+			name:   "ESC between focus reports",
+			opts:   escfilter.Options{Focus: true},
+			output: reporting,
+			chunks: []string{"\x1b[O", "\x1b", "\x1b[I"},
+			want:   "\x1b",
+		},
+		{
+			name:   "ESC interrupting a sequence",
+			opts:   escfilter.Options{Focus: true},
+			output: reporting,
+			chunks: []string{"\x1b[1\x1b[I"},
+			want:   "\x1b[1",
+		},
+		{
+			name:   "alt key",
+			opts:   escfilter.Options{Focus: true},
+			output: reporting,
+			chunks: []string{"\x1bf\x1b[I\x1bb"},
+			want:   "\x1bf\x1bb",
+		},
+		{
+			name:   "cancelled sequence",
+			opts:   escfilter.Options{Focus: true},
+			output: reporting,
+			chunks: []string{"\x1b[\x18I"},
+			want:   "\x1b[\x18I",
+		},
+		{
+			name:   "not quite focus reports",
+			opts:   escfilter.Options{Focus: true},
+			output: reporting,
+			chunks: []string{"\x1b[1I\x1b[?I\x1b[ I\x1bOI"},
+			want:   "\x1b[1I\x1b[?I\x1b[ I\x1bOI",
+		},
+		{
+			name:   "force focused",
+			opts:   escfilter.Options{ForceFocused: true},
+			output: reporting,
+			chunks: []string{"\x1b[O\x1b[I"},
+			want:   "\x1b[I\x1b[I",
+		},
+		{
+			name:   "force focused, enabled twice",
+			opts:   escfilter.Options{ForceFocused: true},
+			output: reporting + "\x1b[?1004l" + reporting,
+			want:   "\x1b[I\x1b[I",
+		},
+		{
+			name:   "sgr mouse",
+			opts:   escfilter.Options{Mouse: true},
+			chunks: []string{"a\x1b[<0;10;5Mb\x1b[<0;10;5mc\x1b[<64;1;1M"},
+			want:   "abc",
+		},
+		{
+			name:   "urxvt mouse",
+			opts:   escfilter.Options{Mouse: true},
+			chunks: []string{"\x1b[32;10;5Mx"},
+			want:   "x",
+		},
+		{
+			name:   "x10 mouse",
+			opts:   escfilter.Options{Mouse: true},
+			chunks: []string{"\x1b[M !!x"},
+			want:   "x",
+		},
+		{
+			name:   "x10 mouse split",
+			opts:   escfilter.Options{Mouse: true},
+			chunks: []string{"\x1b[M", " ", "!!", "x"},
+			want:   "x",
+		},
+		{
+			name:   "x10 mouse cut short",
+			opts:   escfilter.Options{Mouse: true},
+			chunks: []string{"\x1b[M \x1b[Ax"},
+			want:   "\x1b[Ax",
+		},
+		{
+			name:   "mouse filter off",
+			opts:   escfilter.Options{},
+			chunks: []string{"\x1b[<0;10;5M"},
+			want:   "\x1b[<0;10;5M",
+		},
+		{
+			name:    "hotkey",
+			opts:    escfilter.Options{Hotkeys: hotkeys},
+			chunks:  []string{"ab\x1ccd"},
+			want:    "abcd",
+			actions: []escfilter.Action{quit},
+		},
+		{
+			name:    "chord split across reads",
+			opts:    escfilter.Options{Hotkeys: hotkeys},
+			chunks:  []string{"a\x1d", "fb"},
+			want:    "ab",
+			actions: []escfilter.Action{toggle},
+		},
+		{
+			name:   "chord not completed",
+			opts:   escfilter.Options{Hotkeys: hotkeys},
+			chunks: []string{"\x1dg"},
+			want:   "\x1dg",
+		},
+		{
+			name:    "chord restarted",
+			opts:    escfilter.Options{Hotkeys: hotkeys},
+			chunks:  []string{"\x1d\x1df"},
+			want:    "\x1d",
+			actions: []escfilter.Action{toggle},
+		},
+		{
+			name:   "chord cut by a sequence",
+			opts:   escfilter.Options{Hotkeys: hotkeys},
+			chunks: []string{"\x1d\x1b[Af"},
+			want:   "\x1d\x1b[Af",
+		},
+		{
+			name:   "remap",
+			opts:   escfilter.Options{Hotkeys: hotkeys},
+			chunks: []string{"a\x01b"},
+			want:   "a\x02b",
+		},
+		{
+			name:   "bracketed paste",
+			opts:   escfilter.Options{Focus: true, Mouse: true, Hotkeys: hotkeys},
+			output: reporting,
+			chunks: []string{"\x1b[200~a\x1c\x1b[I\x1b[<0;1;1Mb\x1b[201~\x1c"},
+			want:   "\x1b[200~a\x1c\x1b[I\x1b[<0;1;1Mb\x1b[201~",
+			actions: []escfilter.Action{
+				quit,
+			},
+		},
+		{
+			name:   "bracketed paste split",
+			opts:   escfilter.Options{Focus: true, Hotkeys: hotkeys},
+			output: reporting,
+			chunks: []string{"\x1b[20", "0~a\x1b", "[Ib\x1b[2", "01~\x1b[I"},
+			want:   "\x1b[200~a\x1b[Ib\x1b[201~",
+		},
+		{
+			name:   "unbracketed burst",
+			opts:   escfilter.Options{WrapBursts: 8},
+			output: "\x1b[?2004h",
+			chunks: []string{"short", "a longer burst\r"},
+			want:   "short\x1b[200~a longer burst\r\x1b[201~",
+		},
+		{
+			name:   "burst without bracketed paste",
+			opts:   escfilter.Options{WrapBursts: 8},
+			chunks: []string{"a longer burst\r"},
+			want:   "a longer burst\r",
+		},
+		{
+			name:    "wheel",
+			opts:    escfilter.Options{Mouse: true, WheelUp: quit, WheelDown: toggle},
+			wheel:   true,
+			chunks:  []string{"\x1b[<64;1;1M\x1b[<65;1;1M\x1b[<0;1;1M"},
+			actions: []escfilter.Action{quit, toggle},
+		},
+		{
+			name:   "strings pass whole",
+			opts:   escfilter.Options{Focus: true},
+			output: reporting,
+			chunks: []string{"\x1b]11;rgb:0/0/0\x07", "\x1bP1$r0m\x1b\\"},
+			want:   "\x1b]11;rgb:0/0/0\x07\x1bP1$r0m\x1b\\",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := escfilter.New(tt.opts)
+			f.SetWheel(tt.wheel)
+			got, actions := run(f, tt.output, tt.chunks...)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if !slices.Equal(actions, tt.actions) {
+				t.Errorf("got actions %v, want %v", actions, tt.actions)
+			}
+		})
+	}
+}
+
+func TestToggles(t *testing.T) {
+	f := escfilter.New(escfilter.Options{Focus: true})
+	got, _ := run(f, "\x1b[?1004h", "\x1b[I")
+	if got != "" || !f.FocusReporting() {
+		t.Fatalf("got %q with reporting %v, want the report swallowed", got, f.FocusReporting())
+	}
+	f.SetFocus(false)
+	if got, _ := run(f, "", "\x1b[I"); got != "\x1b[I" {
+		t.Errorf("with the focus filter off got %q, want the report", got)
+	}
+	f.SetMouse(true)
+	if got, _ := run(f, "", "\x1b[<0;1;1M"); got != "" || !f.Mouse() {
+		t.Errorf("with the mouse filter on got %q, want nothing", got)
+	}
+}
+
+func TestPending(t *testing.T) {
+	f := escfilter.New(escfilter.Options{Focus: true})
+	write := func([]byte) { t.Error("wrote a partial sequence") }
+	f.Process([]byte("\x1b["), write, nil)
+	if !f.Pending() {
+		t.Fatal("a partial CSI isn't pending")
+	}
+	if got := string(f.Flush()); got != "\x1b[" {
+		t.Errorf("Flush returned %q", got)
+	}
+	if f.Pending() {
+		t.Error("still pending after Flush")
+	}
+	f.Process([]byte("\x1b[200~\x1b"), func([]byte) {}, nil)
+	if f.Pending() || !f.Pasting() {
+		t.Errorf("an ESC inside a paste is pending %v, pasting %v; want held for the paste", f.Pending(), f.Pasting())
+	}
+}
+
+func TestTrace(t *testing.T) {
+	var events []string
+	f := escfilter.New(escfilter.Options{Focus: true, Hotkeys: hotkeys, Trace: func(e escfilter.Event) {
+		events = append(events, e.Action+" "+e.Kind)
+	}})
+	run(f, "\x1b[?1004h", "a\x1b[I\x1c\x1b[A\x1b")
+	want := []string{"swallow CSI", "hotkey Keys", "forward CSI", "timeout Pending"}
+	if !slices.Equal(events, want) {
+		t.Errorf("got events %q, want %q", events, want)
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		chord string
+		want  string
+		err   bool
+	}{
+		{chord: "", want: ""},
+		{chord: "ctrl-] f", want: "\x1df"},
+		{chord: "C-a", want: "\x01"},
+		{chord: "ctrl-\\", want: "\x1c"},
+		{chord: "ctrl-?", want: "\x7f"},
+		{chord: "ctrl-@", want: "\x00"},
+		{chord: "Enter tab space", want: "\r\t "},
+		{chord: "shift-tab", want: "\x1b[Z"},
+		{chord: "é", want: "é"},
+		{chord: "ctrl-1", err: true},
+		{chord: "bogus", err: true},
+	}
+	for _, tt := range tests {
+		got, err := escfilter.ParseKeys(tt.chord)
+		switch {
+		case tt.err && err == nil:
+			t.Errorf("ParseKeys(%q) = %q, want an error", tt.chord, got)
+		case !tt.err && err != nil:
+			t.Errorf("ParseKeys(%q): %v", tt.chord, err)
+		case !tt.err && string(got) != tt.want:
+			t.Errorf("ParseKeys(%q) = %q, want %q", tt.chord, got, tt.want)
+		}
+	}
+	if escfilter.ValidKeys([]byte("\x1b[A")) == nil {
+		t.Error("ValidKeys accepted ESC")
+	}
+}
+
+// FuzzProcess feeds the filter arbitrary input, split into reads of every
+// size from one byte up, and checks that how it is split makes no
+// difference, that nothing is lost with the filters idle, and that no focus
+// report gets through with them on.
+func FuzzProcess(f *testing.F) {
+	for _, seed := range []string{
+		"",
+		"hello\r",
+		"\x1b",
+		"\x1b[I\x1b[O",
+		"\x1b\x1b[I",
+		"\x1b[1\x1b[O\x1b",
+		"\x1b[200~\x1b[I\x1c\x1b[201~",
+		"\x1b[\x18I\x1b[\x1aO",
+		"\x1b[M !!\x1b[<0;1;1M\x1b[32;1;1M",
+		"\x1d\x1df\x01\x1c",
+		"\x1b]11;rgb:0/0/0\x1b\\\x1bP1$r\x1b[I",
+		"\x1b]0;\x1b[I\x07\x1bX\x1b[O\x1b\\",
+		"\xc3\x1b[I\xa9",
+	} {
+		f.Add([]byte(seed), uint8(0), true, false)
+	}
+	f.Fuzz(func(t *testing.T, data []byte, size uint8, mouse, hotkeysOn bool) {
+		opts := escfilter.Options{Focus: true, Mouse: mouse}
+		if hotkeysOn {
+			opts.Hotkeys = hotkeys
+		}
+		whole, wholeActions := run(escfilter.New(opts), "\x1b[?1004h", string(data))
+		n := int(size)%16 + 1
+		var chunks []string
+		for b := data; len(b) > 0; b = b[min(n, len(b)):] {
+			chunks = append(chunks, string(b[:min(n, len(b))]))
+		}
+		split, splitActions := run(escfilter.New(opts), "\x1b[?1004h", chunks...)
+		if split != whole || !slices.Equal(splitActions, wholeActions) {
+			t.Errorf("in reads of %d: got %q and %v, whole %q and %v", n, split, splitActions, whole, wholeActions)
+		}
+
+		// Not reporting focus, with no mouse filter or hotkeys, the filter
+		// has nothing to take
+		idle, _ := run(escfilter.New(escfilter.Options{Focus: true}), "", chunks...)
+		if idle != string(data) {
+			t.Errorf("idle filter changed %q to %q", data, idle)
+		}
+
+		// Outside pastes and strings, which carry other bytes whole, no
+		// focus report reaches the child, unless what is left of the input
+		// around the reports makes one. Hotkeys and mouse reports taken out
+		// can leave one the same way
+		if mouse || hotkeysOn {
+			return
+		}
+		for _, opener := range []string{"\x1b[20", "\x1b]", "\x1bP", "\x1bX", "\x1b^", "\x1b_"} {
+			if bytes.Contains(data, []byte(opener)) {
+				return
+			}
+		}
+		reports := []string{"\x1b[I", "\x1b[O"}
+		rest := strings.NewReplacer(reports[0], "", reports[1], "").Replace(string(data))
+		if strings.Contains(rest, reports[0]) || strings.Contains(rest, reports[1]) {
+			return
+		}
+		for _, report := range reports {
+			if strings.Contains(whole, report) {
+				t.Errorf("%q reached the child from %q as %q", report, data, whole)
+			}
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package escfilter
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Action identifies what a Hotkey does. Its values are up to the caller,
+// except that the zero value None means no action.
+type Action int
+
+// None is the Action of no hotkey.
+const None Action = 0
+
+// Hotkey binds a sequence of typed bytes to an Action, or remaps it: if
+// Send is set, it is forwarded in place of Keys and no Action is reported.
+// Keys must be plain typed bytes, not escape sequences, which never reach
+// the matcher.
+type Hotkey struct {
+	Keys   []byte
+	Action Action
+	Send   []byte
+}
+
+// keyNames are the named keys accepted by ParseKeys.
+var keyNames = map[string]string{
+	"esc":       "\x1b",
+	"escape":    "\x1b",
+	"enter":     "\r",
+	"tab":       "\t",
+	"space":     " ",
+	"backspace": "\x7f",
+	"shift-tab": "\x1b[Z",
+	"up":        "\x1b[A",
+	"down":      "\x1b[B",
+	"right":     "\x1b[C",
+	"left":      "\x1b[D",
+	"home":      "\x1b[H",
+	"end":       "\x1b[F",
+	"delete":    "\x1b[3~",
+	"pageup":    "\x1b[5~",
+	"pagedown":  "\x1b[6~",
+}
+
+// ParseKeys parses a space-separated key chord such as "ctrl-] f" into the
+// bytes a terminal sends for it. Keys are single characters, names from
+// keyNames, or "ctrl-" followed by a character. An empty chord is nil.
+func ParseKeys(chord string) ([]byte, error) {
+	var keys []byte
+	for _, key := range strings.Fields(chord) {
+		lower := strings.ToLower(key)
+		switch {
+		case keyNames[lower] != "":
+			keys = append(keys, keyNames[lower]...)
+		case (strings.HasPrefix(lower, "ctrl-") || strings.HasPrefix(lower, "c-")) && len(key) > 2 && key[len(key)-2] == '-':
+			c := key[len(key)-1]
+			switch {
+			case c == '?':
+				keys = append(keys, 0x7f)
+			case c >= 'a' && c <= 'z':
+				keys = append(keys, c-'a'+1)
+			case c >= '@' && c <= '_':
+				keys = append(keys, c-'@')
+			default:
+				return nil, fmt.Errorf("bad key %q in %q", key, chord)
+			}
+		case len([]rune(key)) == 1:
+			keys = append(keys, key...)
+		default:
+			return nil, fmt.Errorf("bad key %q in %q", key, chord)
+		}
+	}
+	return keys, nil
+}
+
+// ValidKeys reports an error if keys cannot be matched as a Hotkey because
+// they include ESC, which starts an escape sequence instead.
+func ValidKeys(keys []byte) error {
+	if bytes.IndexByte(keys, 0x1b) >= 0 {
+		return fmt.Errorf("hotkey %q includes ESC", keys)
+	}
+	return nil
+}
+
+// keyMatcher recognizes hotkeys in typed input. Bytes that could still be
+// the start of a hotkey are held until the next byte settles it.
+type keyMatcher struct {
+	hotkeys []Hotkey
+	held    []byte
+}
+
+// feed processes one typed byte, appending any bytes that turned out not to
+// belong to a hotkey to out. It returns the extended out and the completed
+// hotkey, or nil.
+func (m *keyMatcher) feed(out []byte, b byte) ([]byte, *Hotkey) {
+	candidate := append(m.held, b)
+	prefix := false
+	for i, hk := range m.hotkeys {
+		if bytes.Equal(hk.Keys, candidate) {
+			m.held = m.held[:0]
+			return out, &m.hotkeys[i]
+		}
+		if bytes.HasPrefix(hk.Keys, candidate) {
+			prefix = true
+		}
+	}
+	if prefix {
+		m.held = candidate
+		return out, nil
+	}
+	if len(m.held) == 0 {
+		return append(out, b), nil
+	}
+	// The held bytes were ordinary input after all; b may start a new hotkey
+	out = append(out, m.held...)
+	m.held = m.held[:0]
+	return m.feed(out, b)
+}
+
+// flush appends any held bytes to out, for when non-key input interrupts a
+// partial hotkey.
+func (m *keyMatcher) flush(out []byte) []byte {
+	out = append(out, m.held...)
+	m.held = m.held[:0]
+	return out
+}
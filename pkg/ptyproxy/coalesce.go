@@ -0,0 +1,69 @@
+package ptyproxy
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// coalescer gathers writes for up to delay before passing them on as one,
+// or sooner once limit bytes are waiting, so output streamed a few bytes at
+// a time reaches the terminal in fewer writes. A nil *coalescer does
+// nothing.
+type coalescer struct {
+	w     io.Writer
+	delay time.Duration
+	limit int
+
+	mu    sync.Mutex
+	buf   []byte
+	timer *time.Timer
+	armed bool
+	err   error // from the last write to w
+}
+
+func newCoalescer(w io.Writer, delay time.Duration, limit int) *coalescer {
+	c := &coalescer{w: w, delay: delay, limit: limit}
+	c.timer = time.AfterFunc(delay, c.Flush)
+	c.timer.Stop()
+	return c
+}
+
+func (c *coalescer) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return 0, c.err
+	}
+	c.buf = append(c.buf, b...)
+	if len(c.buf) >= c.limit {
+		c.flush()
+		return len(b), c.err
+	}
+	if !c.armed {
+		c.armed = true
+		c.timer.Reset(c.delay)
+	}
+	return len(b), nil
+}
+
+// Flush writes out whatever is waiting.
+func (c *coalescer) Flush() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flush()
+}
+
+// flush writes out the buffer. The caller holds mu.
+func (c *coalescer) flush() {
+	c.armed = false
+	c.timer.Stop()
+	if len(c.buf) == 0 || c.err != nil {
+		return
+	}
+	_, c.err = c.w.Write(c.buf)
+	c.buf = c.buf[:0]
+}
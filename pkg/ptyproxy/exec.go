@@ -0,0 +1,40 @@
+package ptyproxy
+
+import (
+	"errors"
+	"maps"
+	"os"
+	"os/exec"
+)
+
+// Exec runs cmd on the calling process's own stdin, stdout and stderr,
+// unless it has a Stderr of its own, without a pseudo-terminal, and returns
+// its exit code. It is for when there is no terminal to proxy, such as in a
+// pipeline, so the output reaches its reader untouched. Signals are relayed
+// as by Run, as signals says, or as DefaultSignals does if it is nil.
+func Exec(cmd *exec.Cmd, signals SignalMap) (int, error) {
+	cmd.Stdin, cmd.Stdout = os.Stdin, os.Stdout
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+	if err := cmd.Start(); err != nil {
+		return 1, err
+	}
+	if signals == nil {
+		signals = DefaultSignals()
+	}
+	// With no terminal to lose, a hangup is just passed on
+	signals = maps.Clone(signals)
+	for _, sig := range hangupSignals {
+		if _, ok := signals[sig]; !ok {
+			signals[sig] = sig
+		}
+	}
+	defer signals.relay(cmd.Process.Signal, nil)()
+	err := cmd.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		err = nil
+	}
+	return exitCode(cmd.ProcessState), err
+}
@@ -0,0 +1,512 @@
+package ptyproxy
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/samuelstevens/claude-unfocused/pkg/escfilter"
+	"golang.org/x/term"
+)
+
+// resizeDebounce is how long the terminal size must stay put before the
+// child is told about it.
+const resizeDebounce = 50 * time.Millisecond
+
+// hangupGrace is the default for Proxy.HangupGrace.
+const hangupGrace = 5 * time.Second
+
+// drainTimeout is how long, once the child exits, Run waits for the last of
+// its output to be copied, which a process it left behind holding the PTY
+// open would otherwise hold up for good.
+const drainTimeout = 100 * time.Millisecond
+
+// replyTimeout is how long the start of what may be the terminal's reply to
+// a query is held back, waiting for the rest, when EscTimeout is shorter.
+const replyTimeout = 500 * time.Millisecond
+
+// Defaults for Proxy.InputBuffer and Proxy.OutputBuffer.
+const (
+	inputBuffer  = 4 << 10
+	outputBuffer = 64 << 10
+)
+
+// Proxy connects the calling process's terminal to a Session. Only Session
+// is required.
+type Proxy struct {
+	Session Session
+	// Filter, if set, decides which input reaches the child and reports its
+	// hotkeys to OnAction. Without one, input is forwarded verbatim.
+	Filter *escfilter.Filter
+	// EscTimeout is how long the Filter may hold an incomplete escape
+	// sequence before it is forwarded as typed. If zero, it is forwarded
+	// as soon as the read it arrived in has been filtered, so only
+	// sequences that arrive whole are recognized.
+	EscTimeout time.Duration
+	// PasteChunk, if positive, paces large input such as pastes: writes
+	// longer than this, and anything inside a bracketed paste, reach the
+	// child this many bytes at a time, PasteDelay apart.
+	PasteChunk int
+	PasteDelay time.Duration
+	// InputBuffer and OutputBuffer size the reads of terminal input and of
+	// the child's output. They default to 4 and 64 KiB.
+	InputBuffer  int
+	OutputBuffer int
+	// Coalesce, if positive, is how long output may be held back to be
+	// written together with what follows, up to OutputBuffer bytes, which
+	// saves writes and flicker when the child streams a few bytes at a
+	// time. Spliced output isn't held back.
+	Coalesce time.Duration
+	// Watch, if set, is shown the child's output after Output, for
+	// following it without writing it anywhere; unlike an Output, it still
+	// lets the output be spliced.
+	Watch io.Writer
+	// Output receives the child's output. It defaults to os.Stdout, in
+	// which case, on Linux, output from a local PTY is moved to the
+	// terminal inside the kernel rather than being copied through the
+	// wrapper.
+	Output io.Writer
+	// Decode, if set, converts terminal input as it is read, before the
+	// Filter sees it, as for a terminal using another character set than
+	// the child.
+	Decode func([]byte) []byte
+	// OnInput, if set, is called with the bytes written to the child.
+	OnInput func([]byte)
+	// ResizePoll, if positive, is how often to check the terminal's size
+	// as well as on SIGWINCH, for terminals that don't always send it.
+	ResizePoll time.Duration
+	// OnResize, if set, is called after the child's terminal is resized.
+	OnResize func(cols, rows int)
+	// Signals says which signals the wrapper receives are passed on to the
+	// child, and as what. It defaults to DefaultSignals.
+	Signals SignalMap
+	// SignalActions are signals that, rather than being passed on, are
+	// handed to OnAction as these actions, as if their hotkeys were typed.
+	SignalActions map[os.Signal]escfilter.Action
+	// OnSignal, if set, is called with each signal passed on to the child.
+	// It may run on any goroutine.
+	OnSignal func(os.Signal)
+	// OnAction handles the Filter's hotkeys. It runs on Run's goroutine and
+	// may call Suspend, Kill and Stop.
+	OnAction func(escfilter.Action)
+	// OnHangup, if set, handles the terminal hanging up (SIGHUP) instead of
+	// the default, which passes the hangup on to the child and kills it if
+	// it hasn't exited after HangupGrace. It runs on Run's goroutine and may
+	// call Kill and Stop.
+	OnHangup func()
+	// HangupGrace is how long the child has to save its work and exit after
+	// a hangup. It defaults to 5 seconds.
+	HangupGrace time.Duration
+
+	oldState    *term.State
+	resized     func()      // passes a change in the terminal's size on
+	resumed     atomic.Bool // Suspend has set the terminal up again itself
+	done        chan struct{}
+	code        int
+	err         error
+	stopped     bool
+	stopCode    int
+	terminating <-chan time.Time // fires when a Terminate or hangup grace runs out
+	capture     atomic.Pointer[func([]byte) bool]
+	actions     chan escfilter.Action
+}
+
+// Run puts the terminal in raw mode and relays it to the child until the
+// child exits or OnAction calls Stop. It returns the child's exit code, or
+// the one passed to Stop.
+func (p *Proxy) Run() (int, error) {
+	if err := inheritSize(p.Session); err != nil {
+		log.Printf("warning: could not inherit size: %v", err)
+	}
+	// Drag-resizing sends a storm of SIGWINCH; only pass on where it settles
+	var lastCols, lastRows int
+	resized := debounce(resizeDebounce, func() {
+		cols, rows, err := TermSize()
+		if err != nil || (cols == lastCols && rows == lastRows) {
+			return
+		}
+		lastCols, lastRows = cols, rows
+		_ = p.Session.Resize(cols, rows)
+		if p.OnResize != nil {
+			p.OnResize(cols, rows)
+		}
+	})
+	watchResize(resized)
+	p.resized = resized
+
+	// Raw mode
+	restoreConsole, err := setupConsole()
+	if err != nil {
+		log.Printf("warning: could not enable VT processing: %v", err)
+	}
+	defer restoreConsole()
+	p.oldState, err = term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return 1, fmt.Errorf("set raw mode: %w", err)
+	}
+	defer func() { _ = term.Restore(int(os.Stdin.Fd()), p.oldState) }()
+
+	// Forward signals to child
+	signals := p.Signals
+	if signals == nil {
+		signals = DefaultSignals()
+	}
+	defer signals.relay(p.Session.Signal, p.signaled)()
+	hangup := make(chan os.Signal, 1)
+	for _, sig := range hangupSignals {
+		if _, ok := p.SignalActions[sig]; !ok { // bound to an action instead
+			signal.Notify(hangup, sig)
+		}
+	}
+	defer signal.Stop(hangup)
+	// Stopped from outside, by SIGSTOP or the shell, the wrapper comes back
+	// to a terminal that may have been reset and resized meanwhile
+	resume := make(chan os.Signal, 1)
+	if len(resumeSignals) > 0 {
+		signal.Notify(resume, resumeSignals...)
+		defer signal.Stop(resume)
+	}
+
+	// Wait for child in background
+	p.done = make(chan struct{})
+	go func() {
+		p.code, p.err = p.Session.Wait()
+		close(p.done)
+	}()
+	if p.ResizePoll > 0 {
+		go func() {
+			tick := time.NewTicker(p.ResizePoll)
+			defer tick.Stop()
+			for {
+				select {
+				case <-tick.C:
+					resized()
+				case <-p.done:
+					return
+				}
+			}
+		}()
+	}
+
+	// Copy child output to stdout
+	out := p.Output
+	if out == nil {
+		out = os.Stdout
+	}
+	var coalesce *coalescer
+	if p.Coalesce > 0 {
+		coalesce = newCoalescer(out, p.Coalesce, bufferSize(p.OutputBuffer, outputBuffer))
+		out = coalesce
+	}
+	drained := make(chan struct{}) // the child's output has ended
+	go func() {
+		var watchers []io.Writer
+		if p.Filter != nil {
+			watchers = append(watchers, observer{p})
+		}
+		if p.Watch != nil {
+			watchers = append(watchers, p.Watch)
+		}
+		var watch io.Writer
+		if len(watchers) > 0 {
+			watch = io.MultiWriter(watchers...)
+		}
+		if p.Output == nil && spliceOutput(os.Stdout, p.Session, watch) {
+			close(drained)
+			_, _ = io.Copy(io.Discard, p.Session)
+			return
+		}
+		if watch != nil {
+			out = io.MultiWriter(out, watch)
+		}
+		// Hide the ends' ReadFrom and WriteTo, which bring their own buffers
+		buf := make([]byte, bufferSize(p.OutputBuffer, outputBuffer))
+		_, _ = io.CopyBuffer(struct{ io.Writer }{out}, struct{ io.Reader }{p.Session}, buf)
+		coalesce.Flush()
+		close(drained)
+		// Out is gone (the terminal hung up, say), but the child must not
+		// block writing to the PTY while it shuts down
+		_, _ = io.Copy(io.Discard, p.Session)
+	}()
+
+	actions := make(chan escfilter.Action, 1)
+	p.actions = actions
+	go p.relayInput(actions)
+	if len(p.SignalActions) > 0 {
+		sigCh := make(chan os.Signal, 1)
+		for sig := range p.SignalActions {
+			signal.Notify(sigCh, sig)
+		}
+		defer signal.Stop(sigCh)
+		go func() {
+			for sig := range sigCh {
+				select {
+				case actions <- p.SignalActions[sig]:
+				case <-p.done:
+					return
+				}
+			}
+		}()
+	}
+
+	// Main loop: wait for exit, hotkeys or a hangup
+	for {
+		select {
+		case <-p.done:
+			select {
+			case <-drained:
+			case <-time.After(drainTimeout):
+			}
+			coalesce.Flush()
+			return p.code, p.err
+		case sig := <-hangup:
+			if p.OnHangup != nil {
+				p.OnHangup()
+				if p.stopped {
+					return p.stopCode, nil
+				}
+				continue
+			}
+			if p.terminating == nil {
+				grace := p.HangupGrace
+				if grace <= 0 {
+					grace = hangupGrace
+				}
+				if !p.signalThenKill(sig, grace) {
+					_ = p.Session.Kill()
+				}
+			}
+		case <-resume:
+			// Continued in the background, the terminal isn't the
+			// wrapper's until it is brought back and continued again
+			if !p.resumed.Swap(false) && foreground() {
+				p.resume()
+			}
+		case <-p.terminating:
+			_ = p.Session.Kill()
+		case a := <-actions:
+			if p.OnAction != nil {
+				p.OnAction(a)
+			}
+			if p.stopped {
+				return p.stopCode, nil
+			}
+		}
+	}
+}
+
+// relayInput filters stdin into the child until it exits, handing hotkeys
+// to the main loop.
+func (p *Proxy) relayInput(actions chan<- escfilter.Action) {
+	// Read in a goroutine so waiting for input can be interrupted by timeout
+	stdinData := make(chan []byte)
+	go func() {
+		buf := make([]byte, bufferSize(p.InputBuffer, inputBuffer))
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				close(stdinData)
+				return
+			}
+			if n > 0 {
+				data := make([]byte, n)
+				copy(data, buf[:n])
+				if p.Decode != nil {
+					data = p.Decode(data)
+				}
+				stdinData <- data
+			}
+		}
+	}()
+
+	action := func(a escfilter.Action) { actions <- a }
+
+	var timerCh <-chan time.Time
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-timerCh:
+			// Incomplete sequence timed out: forward it as typed (e.g. a lone ESC)
+			if p.Filter.Pending() {
+				p.write(p.Filter.Flush())
+			}
+			timerCh = nil
+		case data, ok := <-stdinData:
+			if !ok {
+				return
+			}
+			if fn := p.capture.Load(); fn != nil && (*fn)(data) {
+				continue
+			}
+			if p.Filter == nil {
+				p.writeInput(data)
+				continue
+			}
+			p.Filter.Process(data, p.writeInput, action)
+			timerCh = nil
+			switch {
+			case !p.Filter.Pending():
+			case p.Filter.PendingReply():
+				timerCh = time.After(max(p.EscTimeout, replyTimeout))
+			case p.EscTimeout <= 0:
+				p.write(p.Filter.Flush())
+			default:
+				timerCh = time.After(p.EscTimeout)
+			}
+		}
+	}
+}
+
+// writeInput delivers typed input to the child, pacing it if it is a paste.
+func (p *Proxy) writeInput(b []byte) {
+	pasting := p.Filter != nil && p.Filter.Pasting()
+	if p.PasteChunk <= 0 || len(b) <= p.PasteChunk && !pasting {
+		p.write(b)
+		return
+	}
+	for len(b) > 0 {
+		n := min(len(b), p.PasteChunk)
+		p.write(b[:n])
+		b = b[n:]
+		time.Sleep(p.PasteDelay)
+	}
+}
+
+// write delivers input to the child.
+func (p *Proxy) write(b []byte) {
+	_, _ = p.Session.Write(b)
+	if p.OnInput != nil {
+		p.OnInput(b)
+	}
+}
+
+// observer shows the child's output to the Filter, which may answer with
+// input of its own.
+type observer struct{ p *Proxy }
+
+func (o observer) Write(b []byte) (int, error) {
+	o.p.Filter.Observe(b, o.p.write)
+	return len(b), nil
+}
+
+// Capture sends terminal input to fn instead of the Filter and the child,
+// until it is called again with nil. Fn reports whether it took the input;
+// if not, the input goes on as usual. It runs on the goroutine reading
+// input, which it holds up until it returns.
+func (p *Proxy) Capture(fn func([]byte) bool) {
+	if fn == nil {
+		p.capture.Store(nil)
+		return
+	}
+	p.capture.Store(&fn)
+}
+
+// Suspend stops the calling process as if the shell had sent SIGTSTP,
+// taking the terminal out of raw mode until it is resumed. It fails where
+// there is no job control.
+func (p *Proxy) Suspend() error {
+	_ = term.Restore(int(os.Stdin.Fd()), p.oldState)
+	if err := suspend(); err != nil {
+		_, _ = term.MakeRaw(int(os.Stdin.Fd()))
+		return err
+	}
+	// Put in the background instead, the wrapper sets the terminal up once
+	// it is continued in the foreground
+	if foreground() {
+		p.resumed.Store(true)
+		p.resume()
+	}
+	return nil
+}
+
+// resume sets the terminal up again after the wrapper was stopped, which
+// may have reset and resized it, and has the child redraw.
+func (p *Proxy) resume() {
+	_, _ = term.MakeRaw(int(os.Stdin.Fd()))
+	p.resized()
+	Refresh(p.Session)
+}
+
+// Act hands a to OnAction on Run's goroutine, as if its hotkey had been
+// typed, for actions chosen some other way, such as from a menu shown by a
+// Capture function.
+func (p *Proxy) Act(a escfilter.Action) {
+	select {
+	case p.actions <- a:
+	case <-p.done:
+	}
+}
+
+// Kill kills the child, waits for it to exit and makes Run return its exit
+// code.
+func (p *Proxy) Kill() {
+	_ = p.Session.Kill()
+	<-p.done
+	p.Stop(p.code)
+}
+
+// Terminate asks the child to exit with SIGTERM, killing it if it is still
+// running after grace, or when Terminate is called again in the meantime.
+// Run returns its exit code once it has exited. Without a grace period, or
+// where SIGTERM can't be sent, it kills at once.
+func (p *Proxy) Terminate(grace time.Duration) {
+	if p.terminating != nil || grace <= 0 || !p.signalThenKill(syscall.SIGTERM, grace) {
+		p.Kill()
+	}
+}
+
+// signalThenKill sends sig to the child and arranges for Run to kill it
+// after grace, reporting whether sig could be sent.
+func (p *Proxy) signalThenKill(sig os.Signal, grace time.Duration) bool {
+	if err := p.Session.Signal(sig); err != nil {
+		return false
+	}
+	p.signaled(sig)
+	p.terminating = time.After(grace)
+	return true
+}
+
+func (p *Proxy) signaled(sig os.Signal) {
+	if p.OnSignal != nil {
+		p.OnSignal(sig)
+	}
+}
+
+// Stop makes Run return code once OnAction returns, leaving the child
+// running.
+func (p *Proxy) Stop(code int) {
+	p.stopped, p.stopCode = true, code
+}
+
+// bufferSize returns size, or def if size isn't positive.
+func bufferSize(size, def int) int {
+	if size > 0 {
+		return size
+	}
+	return def
+}
+
+// debounce returns a function that calls fn once calls to it have stopped
+// for d.
+func debounce(d time.Duration, fn func()) func() {
+	var (
+		mu    sync.Mutex
+		timer *time.Timer
+	)
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if timer == nil {
+			timer = time.AfterFunc(d, fn)
+		} else {
+			timer.Reset(d)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+//go:build !windows
+
+package ptyproxy
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"github.com/creack/pty"
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
+)
+
+// forwardSignals are relayed from the wrapper to the child.
+var forwardSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2}
+
+// hangupSignals mean the wrapper's terminal has gone away.
+var hangupSignals = []os.Signal{syscall.SIGHUP}
+
+// resumeSignals mean the wrapper has been continued after being stopped.
+var resumeSignals = []os.Signal{syscall.SIGCONT}
+
+type unixPTY struct {
+	*os.File
+	cmd *exec.Cmd
+}
+
+// Start runs cmd in a new pseudo-terminal, which is its stdin, stdout and
+// stderr except where cmd has its own, the size of the outer terminal if
+// there is one, so the child never sees it 0x0.
+func Start(cmd *exec.Cmd) (Session, error) {
+	var size *pty.Winsize
+	if cols, rows, err := TermSize(); err == nil {
+		size = &pty.Winsize{Cols: uint16(cols), Rows: uint16(rows)}
+	}
+	ptmx, err := pty.StartWithSize(cmd, size)
+	if err != nil {
+		return nil, err
+	}
+	return &unixPTY{File: ptmx, cmd: cmd}, nil
+}
+
+func (p *unixPTY) Resize(cols, rows int) error {
+	return pty.Setsize(p.File, &pty.Winsize{Cols: uint16(cols), Rows: uint16(rows)})
+}
+
+func (p *unixPTY) Pid() int {
+	return p.cmd.Process.Pid
+}
+
+func (p *unixPTY) Signal(sig os.Signal) error {
+	return p.cmd.Process.Signal(sig)
+}
+
+func (p *unixPTY) Kill() error {
+	return p.cmd.Process.Kill()
+}
+
+func (p *unixPTY) Wait() (int, error) {
+	err := p.cmd.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		err = nil
+	}
+	return exitCode(p.cmd.ProcessState), err
+}
+
+// TermSize returns the size of the outer terminal.
+func TermSize() (cols, rows int, err error) {
+	return term.GetSize(int(os.Stdin.Fd()))
+}
+
+// watchResize calls fn whenever the outer terminal is resized.
+func watchResize(fn func()) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGWINCH)
+	go func() {
+		for range ch {
+			fn()
+		}
+	}()
+}
+
+// suspend stops the wrapper's process group as if the shell had sent
+// SIGTSTP, returning once it is resumed.
+func suspend() error {
+	signal.Reset(syscall.SIGTSTP)
+	return syscall.Kill(0, syscall.SIGTSTP)
+}
+
+// foreground reports whether the wrapper's process group has the terminal,
+// as it needs to change the terminal's modes without being stopped again.
+// Where stdin isn't a terminal to ask, it reports true.
+func foreground() bool {
+	pgrp, err := unix.IoctlGetInt(int(os.Stdin.Fd()), unix.TIOCGPGRP)
+	return err != nil || pgrp == syscall.Getpgrp()
+}
+
+// Refresh makes the child redraw its screen, e.g. after its output was not
+// shown for a while.
+func Refresh(p Session) {
+	_ = p.Signal(syscall.SIGWINCH)
+}
+
+// setupConsole prepares the outer terminal for escape sequences. Unix
+// terminals need nothing beyond raw mode.
+func setupConsole() (restore func(), err error) {
+	return func() {}, nil
+}
@@ -0,0 +1,224 @@
+//go:build windows
+
+package ptyproxy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/term"
+)
+
+// forwardSignals are relayed from the wrapper to the child. Windows has no
+// signals to relay: Ctrl-C reaches the child as console input.
+var forwardSignals []os.Signal
+
+// hangupSignals mean the wrapper's terminal has gone away. Windows has no
+// such signal.
+var hangupSignals []os.Signal
+
+// resumeSignals mean the wrapper has been continued after being stopped.
+// Windows has no job control.
+var resumeSignals []os.Signal
+
+// resizePoll is how often the console size is checked, since Windows has no
+// SIGWINCH.
+const resizePoll = 250 * time.Millisecond
+
+// conPTY hosts the child in a Windows pseudo console.
+type conPTY struct {
+	console windows.Handle
+	in      *os.File // our end of the console's input pipe
+	out     *os.File // our end of the console's output pipe
+	proc    *os.Process
+}
+
+// Start runs cmd in a new pseudo console, which is its stdin, stdout and
+// stderr whatever cmd has for them.
+func Start(cmd *exec.Cmd) (Session, error) {
+	if cmd.Err != nil {
+		return nil, cmd.Err
+	}
+	cols, rows, err := TermSize()
+	if err != nil {
+		cols, rows = 80, 25
+	}
+
+	var inRead, inWrite, outRead, outWrite windows.Handle
+	if err := windows.CreatePipe(&inRead, &inWrite, nil, 0); err != nil {
+		return nil, fmt.Errorf("create input pipe: %w", err)
+	}
+	if err := windows.CreatePipe(&outRead, &outWrite, nil, 0); err != nil {
+		_ = windows.CloseHandle(inRead)
+		_ = windows.CloseHandle(inWrite)
+		return nil, fmt.Errorf("create output pipe: %w", err)
+	}
+	p := &conPTY{
+		in:  os.NewFile(uintptr(inWrite), "conpty-in"),
+		out: os.NewFile(uintptr(outRead), "conpty-out"),
+	}
+
+	// The console duplicates the far ends of the pipes, so ours can go
+	err = windows.CreatePseudoConsole(coord(cols, rows), inRead, outWrite, 0, &p.console)
+	_ = windows.CloseHandle(inRead)
+	_ = windows.CloseHandle(outWrite)
+	if err != nil {
+		_ = p.in.Close()
+		_ = p.out.Close()
+		return nil, fmt.Errorf("create pseudo console: %w", err)
+	}
+
+	if err := p.spawn(cmd); err != nil {
+		_ = p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// spawn starts cmd attached to the pseudo console.
+func (p *conPTY) spawn(cmd *exec.Cmd) error {
+	attrs, err := windows.NewProcThreadAttributeList(1)
+	if err != nil {
+		return err
+	}
+	defer attrs.Delete()
+	// The attribute value is the console handle itself, not a pointer to it
+	console := *(*unsafe.Pointer)(unsafe.Pointer(&p.console))
+	if err := attrs.Update(windows.PROC_THREAD_ATTRIBUTE_PSEUDOCONSOLE, console, unsafe.Sizeof(p.console)); err != nil {
+		return err
+	}
+
+	si := &windows.StartupInfoEx{ProcThreadAttributeList: attrs.List()}
+	si.Cb = uint32(unsafe.Sizeof(*si))
+	// Without this the child inherits our console handles instead of the pseudo console's
+	si.Flags = windows.STARTF_USESTDHANDLES
+
+	app, err := windows.UTF16PtrFromString(cmd.Path)
+	if err != nil {
+		return err
+	}
+	cmdline, err := windows.UTF16PtrFromString(windows.ComposeCommandLine(cmd.Args))
+	if err != nil {
+		return err
+	}
+	var dir *uint16
+	if cmd.Dir != "" {
+		if dir, err = windows.UTF16PtrFromString(cmd.Dir); err != nil {
+			return err
+		}
+	}
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	block, err := windows.UTF16FromString(strings.Join(env, "\x00") + "\x00")
+	if err != nil {
+		return err
+	}
+
+	var pi windows.ProcessInformation
+	flags := uint32(windows.EXTENDED_STARTUPINFO_PRESENT | windows.CREATE_UNICODE_ENVIRONMENT)
+	if err := windows.CreateProcess(app, cmdline, nil, nil, false, flags, &block[0], dir, &si.StartupInfo, &pi); err != nil {
+		return fmt.Errorf("start %s: %w", cmd.Path, err)
+	}
+	defer func() { _ = windows.CloseHandle(pi.Process) }()
+	_ = windows.CloseHandle(pi.Thread)
+
+	p.proc, err = os.FindProcess(int(pi.ProcessId))
+	return err
+}
+
+func (p *conPTY) Read(b []byte) (int, error) {
+	return p.out.Read(b)
+}
+
+func (p *conPTY) Write(b []byte) (int, error) {
+	return p.in.Write(b)
+}
+
+func (p *conPTY) Close() error {
+	windows.ClosePseudoConsole(p.console)
+	return errors.Join(p.in.Close(), p.out.Close())
+}
+
+func (p *conPTY) Resize(cols, rows int) error {
+	return windows.ResizePseudoConsole(p.console, coord(cols, rows))
+}
+
+func (p *conPTY) Pid() int {
+	return p.proc.Pid
+}
+
+func (p *conPTY) Signal(sig os.Signal) error {
+	return p.proc.Signal(sig)
+}
+
+func (p *conPTY) Kill() error {
+	return p.proc.Kill()
+}
+
+func (p *conPTY) Wait() (int, error) {
+	state, err := p.proc.Wait()
+	return exitCode(state), err
+}
+
+func coord(cols, rows int) windows.Coord {
+	return windows.Coord{X: int16(cols), Y: int16(rows)}
+}
+
+// TermSize returns the size of the outer console. Windows only reports it
+// for the output handle.
+func TermSize() (cols, rows int, err error) {
+	return term.GetSize(int(os.Stdout.Fd()))
+}
+
+// watchResize calls fn whenever the outer console is resized.
+func watchResize(fn func()) {
+	go func() {
+		lastCols, lastRows, _ := TermSize()
+		for range time.Tick(resizePoll) {
+			cols, rows, err := TermSize()
+			if err != nil || (cols == lastCols && rows == lastRows) {
+				continue
+			}
+			lastCols, lastRows = cols, rows
+			fn()
+		}
+	}()
+}
+
+// suspend is unsupported: Windows has no job control.
+func suspend() error {
+	return errors.ErrUnsupported
+}
+
+// foreground reports whether the wrapper has the console, which, without
+// job control, it always has.
+func foreground() bool {
+	return true
+}
+
+// Refresh makes the child redraw its screen. ConPTY repaints on resize, so
+// there is nothing more to do.
+func Refresh(p Session) {}
+
+// setupConsole enables VT processing so the child's escape sequences are
+// interpreted rather than printed.
+func setupConsole() (restore func(), err error) {
+	h := windows.Handle(os.Stdout.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(h, &mode); err != nil {
+		return func() {}, err
+	}
+	vt := mode | windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING | windows.DISABLE_NEWLINE_AUTO_RETURN
+	if err := windows.SetConsoleMode(h, vt); err != nil {
+		return func() {}, err
+	}
+	return func() { _ = windows.SetConsoleMode(h, mode) }, nil
+}
@@ -0,0 +1,65 @@
+// Package ptyproxy runs a program in a pseudo-terminal and connects it to the
+// terminal the caller is running in: raw-mode input, output, window resizes
+// and signals, with input optionally passed through an escfilter.Filter.
+//
+// Start launches the child and Proxy relays it until it exits:
+//
+//	s, err := ptyproxy.Start(exec.Command("claude"))
+//	if err != nil {
+//		return err
+//	}
+//	defer s.Close()
+//	p := &ptyproxy.Proxy{Session: s, Filter: escfilter.New(escfilter.Options{Focus: true})}
+//	code, err := p.Run()
+//
+// Session is an interface so that a PTY owned elsewhere, such as by a server
+// process, can be proxied the same way.
+package ptyproxy
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+// Session is a child process running in a pseudo-terminal. Reading returns
+// the child's output and writing delivers its input. Start creates one using
+// the platform's PTY implementation.
+type Session interface {
+	io.ReadWriteCloser
+	// Resize sets the terminal size in character cells.
+	Resize(cols, rows int) error
+	// Pid returns the child's process ID, or 0 if it is not known.
+	Pid() int
+	// Signal sends sig to the child.
+	Signal(sig os.Signal) error
+	// Kill terminates the child immediately.
+	Kill() error
+	// Wait waits for the child to exit and returns its exit code.
+	// Unsuccessful exits are reported through the code, not the error.
+	Wait() (int, error)
+}
+
+// inheritSize copies the outer terminal's size to the child's terminal.
+func inheritSize(p Session) error {
+	cols, rows, err := TermSize()
+	if err != nil {
+		return err
+	}
+	return p.Resize(cols, rows)
+}
+
+// exitCode maps the child's exit state to the wrapper's exit code, using
+// the shell convention of 128+N for death by signal N.
+func exitCode(state *os.ProcessState) int {
+	if state == nil {
+		return 1
+	}
+	if code := state.ExitCode(); code >= 0 {
+		return code
+	}
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return 1
+}
@@ -0,0 +1,46 @@
+package ptyproxy
+
+import (
+	"os"
+	"os/signal"
+)
+
+// SignalMap says what becomes of the signals the wrapper receives while it
+// runs the child: each is sent to the child as the signal it maps to, or
+// dropped if that is nil. Signals not in the map have their usual effect on
+// the wrapper.
+type SignalMap map[os.Signal]os.Signal
+
+// DefaultSignals returns the map passing SIGINT, SIGTERM, SIGQUIT, SIGUSR1
+// and SIGUSR2 on as they are.
+func DefaultSignals() SignalMap {
+	m := SignalMap{}
+	for _, sig := range forwardSignals {
+		m[sig] = sig
+	}
+	return m
+}
+
+// relay sends the signals in m, as received or translated, with send, and
+// calls sent with each it sent, until stop is called. On Windows, which has
+// no signals to relay, it does nothing.
+func (m SignalMap) relay(send func(os.Signal) error, sent func(os.Signal)) (stop func()) {
+	if len(m) == 0 || len(forwardSignals) == 0 {
+		return func() {}
+	}
+	sigs := make([]os.Signal, 0, len(m))
+	for sig := range m {
+		sigs = append(sigs, sig)
+	}
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, sigs...)
+	go func() {
+		for sig := range sigCh {
+			to := m[sig]
+			if to != nil && send(to) == nil && sent != nil {
+				sent(to)
+			}
+		}
+	}()
+	return func() { signal.Stop(sigCh) }
+}
@@ -0,0 +1,140 @@
+//go:build linux
+
+package ptyproxy
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// spliceSize is how much output spliceOutput moves at a time, a pipe's
+// default capacity.
+const spliceSize = 64 * 1024
+
+// spliceOutput copies the child's output to dst inside the kernel, through
+// a pipe, until the child's side closes. If observe is set, it is passed a
+// copy of the output teed off the pipe. It reports false where the session
+// is not a local PTY or either end can't be spliced, before any output is
+// lost, for the caller to carry on copying.
+func spliceOutput(dst *os.File, s Session, observe io.Writer) bool {
+	pty, ok := s.(*unixPTY)
+	if !ok {
+		return false
+	}
+	src, err := pty.SyscallConn()
+	if err != nil {
+		return false
+	}
+	out, err := dst.SyscallConn()
+	if err != nil {
+		return false
+	}
+	r, w, err := pipe()
+	if err != nil {
+		return false
+	}
+	defer closeAll(r, w)
+	var tr, tw int
+	var buf []byte
+	if observe != nil {
+		if tr, tw, err = pipe(); err != nil {
+			return false
+		}
+		defer closeAll(tr, tw)
+		buf = make([]byte, spliceSize)
+	}
+
+	for first := true; ; first = false {
+		var n int
+		var inErr error
+		if err := src.Read(func(fd uintptr) bool {
+			n, inErr = spliceRetry(int(fd), w, spliceSize)
+			return !errors.Is(inErr, syscall.EAGAIN)
+		}); err != nil || inErr != nil || n == 0 {
+			return !(first && errors.Is(inErr, syscall.EINVAL))
+		}
+
+		var seen int
+		if observe != nil {
+			m, err := unix.Tee(r, tw, n, 0)
+			if err == nil {
+				seen, _ = io.ReadFull(fdReader(tr), buf[:m])
+			}
+		}
+
+		for left := n; left > 0; {
+			var moved int
+			var outErr error
+			err := out.Write(func(fd uintptr) bool {
+				moved, outErr = spliceRetry(r, int(fd), left)
+				return !errors.Is(outErr, syscall.EAGAIN)
+			})
+			if err == nil {
+				err = outErr
+			}
+			if err != nil {
+				if first && left == n && errors.Is(err, syscall.EINVAL) {
+					// dst won't take a splice: hand back what was read
+					rest := make([]byte, left)
+					k, _ := io.ReadFull(fdReader(r), rest)
+					_, _ = dst.Write(rest[:k])
+					if observe != nil {
+						_, _ = observe.Write(buf[:seen])
+					}
+					return false
+				}
+				return true
+			}
+			left -= moved
+		}
+		if observe != nil {
+			_, _ = observe.Write(buf[:seen])
+		}
+	}
+}
+
+// spliceRetry splices up to size bytes from in to out, retrying on EINTR.
+func spliceRetry(in, out, size int) (int, error) {
+	for {
+		moved, err := unix.Splice(in, nil, out, nil, size, unix.SPLICE_F_MOVE)
+		if !errors.Is(err, syscall.EINTR) {
+			return int(moved), err
+		}
+	}
+}
+
+// pipe returns a blocking pipe. Its ends are used with raw system calls,
+// bypassing the runtime's poller.
+func pipe() (r, w int, err error) {
+	var fds [2]int
+	if err := unix.Pipe2(fds[:], unix.O_CLOEXEC); err != nil {
+		return 0, 0, err
+	}
+	return fds[0], fds[1], nil
+}
+
+func closeAll(fds ...int) {
+	for _, fd := range fds {
+		_ = unix.Close(fd)
+	}
+}
+
+// fdReader reads from a raw file descriptor.
+type fdReader int
+
+func (fd fdReader) Read(b []byte) (int, error) {
+	for {
+		n, err := unix.Read(int(fd), b)
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+		if n == 0 && err == nil {
+			return 0, io.EOF
+		}
+		return max(n, 0), err
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import "github.com/samuelstevens/claude-unfocused/internal/ansiparse"
+
+// maxPlainLine bounds the text kept of a line.
+const maxPlainLine = 1024
+
+// plainLines turns the child's output into lines of plain text for
+// matching: escape sequences are stripped, a cursor move right stands for
+// the spaces it often replaces, and a newline, carriage return or cursor
+// move to another line ends the line.
+type plainLines struct {
+	parser ansiparse.Parser
+	line   []byte
+}
+
+// feed passes each line b ends to fn, and then the line so far, which may
+// be passed again as it grows. The line is only valid during the call.
+func (p *plainLines) feed(b []byte, fn func(line []byte)) {
+	end := func() {
+		if len(p.line) > 0 {
+			fn(p.line)
+			p.line = p.line[:0]
+		}
+	}
+	p.parser.Feed(b, func(seq ansiparse.Sequence) {
+		switch seq.Kind {
+		case ansiparse.Text:
+			if len(p.line)+len(seq.Raw) <= maxPlainLine {
+				p.line = append(p.line, seq.Raw...)
+			}
+		case ansiparse.Control:
+			if seq.Raw[0] == '\n' || seq.Raw[0] == '\r' {
+				end()
+			}
+		case ansiparse.CSI:
+			switch seq.Final {
+			case 'C':
+				p.line = append(p.line, ' ')
+			case 'H', 'f', 'd', 'E', 'F', 'A', 'B':
+				end()
+			}
+		}
+	})
+	if len(p.line) > 0 {
+		fn(p.line)
+	}
+}
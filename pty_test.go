@@ -0,0 +1,499 @@
+//go:build !windows
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+// harnessTimeout is how long the harness waits for the wrapper to do what a
+// test expects of it.
+const harnessTimeout = 10 * time.Second
+
+// The binaries the harness runs, the wrapper and internal/fakeclaude, are
+// built once, into binDir, by the first test that needs them.
+var (
+	buildOnce sync.Once
+	binDir    string
+	buildErr  error
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if binDir != "" {
+		_ = os.RemoveAll(binDir)
+	}
+	os.Exit(code)
+}
+
+// harness runs the wrapper, with fakeclaude as its child, under a
+// pseudo-terminal of its own, playing the part of the user's terminal: it
+// types to the wrapper, resizes and signals it, and reads what it draws,
+// including fakeclaude's reports of what it was sent.
+type harness struct {
+	t   *testing.T
+	cmd *exec.Cmd
+	pty *os.File
+
+	mu    sync.Mutex
+	out   []byte // everything the wrapper wrote
+	seen  int    // how far expect has looked in out
+	read  int    // how far input has parsed out
+	input []byte // what fakeclaude reported reading since the last send
+	done  chan struct{}
+}
+
+// fakeInput matches fakeclaude's report of the bytes it read.
+var fakeInput = regexp.MustCompile(`input ("(?:[^"\\]|\\.)*")\r\n`)
+
+// harnessBin returns the path of the built binary name, building the
+// binaries the first time.
+func harnessBin(t *testing.T, name string) string {
+	t.Helper()
+	buildOnce.Do(func() {
+		binDir, buildErr = os.MkdirTemp("", "claude-unfocused-test")
+		if buildErr != nil {
+			return
+		}
+		out, err := exec.Command("go", "build", "-o", binDir, ".", "./internal/fakeclaude").CombinedOutput()
+		if err != nil {
+			buildErr = errors.New(string(out))
+		}
+	})
+	if buildErr != nil {
+		t.Fatalf("building the harness: %v", buildErr)
+	}
+	return filepath.Join(binDir, name)
+}
+
+// wrapperCommand returns the command running the built wrapper with args,
+// with a home, config and state of its own in home so the user's don't get
+// in the way.
+func wrapperCommand(t *testing.T, home string, args ...string) *exec.Cmd {
+	t.Helper()
+	cmd := exec.Command(harnessBin(t, "claude-unfocused"), args...)
+	cmd.Dir = home
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, flagEnvPrefix) && !strings.HasPrefix(kv, "CLAUDE_") &&
+			!strings.HasPrefix(kv, "TMUX") && !strings.HasPrefix(kv, "XDG_") {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	cmd.Env = append(cmd.Env, "HOME="+home, "TERM=xterm-256color",
+		"XDG_CONFIG_HOME="+filepath.Join(home, "config"), "XDG_CONFIG_DIRS="+filepath.Join(home, "etc"),
+		"XDG_STATE_HOME="+filepath.Join(home, "state"), "XDG_RUNTIME_DIR="+home,
+		"CLAUDE_CONFIG_DIR="+filepath.Join(home, "claude"))
+	return cmd
+}
+
+// startHarness starts the wrapper with args in a terminal of 80x24 and
+// waits for fakeclaude to be ready.
+func startHarness(t *testing.T, args ...string) *harness {
+	t.Helper()
+	return startHarnessIn(t, t.TempDir(), args...)
+}
+
+// startHarnessIn is startHarness with the wrapper's home in home, where a
+// test may have put a config file.
+func startHarnessIn(t *testing.T, home string, args ...string) *harness {
+	t.Helper()
+	h := launchHarness(t, home, args...)
+	h.expect("ready 80x24")
+	return h
+}
+
+// launchHarness is startHarnessIn without waiting for fakeclaude, for when
+// the wrapper won't show it starting.
+func launchHarness(t *testing.T, home string, args ...string) *harness {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("skipping the pty harness in short mode")
+	}
+	args = append([]string{"--no-archive"}, args...)
+	cmd := wrapperCommand(t, home, append(args, "--", harnessBin(t, "fakeclaude"))...)
+	f, err := pty.StartWithSize(cmd, &pty.Winsize{Cols: 80, Rows: 24})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &harness{t: t, cmd: cmd, pty: f, done: make(chan struct{})}
+	go func() {
+		defer close(h.done)
+		buf := make([]byte, 4096)
+		for {
+			n, err := f.Read(buf)
+			h.mu.Lock()
+			h.out = append(h.out, buf[:n]...)
+			h.mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		<-h.done
+		_ = f.Close()
+		if t.Failed() {
+			t.Logf("the wrapper wrote %q", h.output())
+		}
+	})
+	return h
+}
+
+// output returns everything the wrapper has written.
+func (h *harness) output() []byte {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]byte(nil), h.out...)
+}
+
+// until waits for cond, called with mu held, to be true, failing the test
+// with what if it isn't in time.
+func (h *harness) until(what string, cond func() bool) {
+	h.t.Helper()
+	deadline := time.Now().Add(harnessTimeout)
+	for {
+		h.mu.Lock()
+		ok := cond()
+		h.mu.Unlock()
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			h.t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// expect waits for the wrapper to write s, after what the last expect
+// found.
+func (h *harness) expect(s string) {
+	h.t.Helper()
+	h.until(strconv.Quote(s), func() bool {
+		i := bytes.Index(h.out[h.seen:], []byte(s))
+		if i < 0 {
+			return false
+		}
+		h.seen += i + len(s)
+		return true
+	})
+}
+
+// send types s to the wrapper, forgetting what fakeclaude read before.
+func (h *harness) send(s string) {
+	h.t.Helper()
+	h.mu.Lock()
+	h.parseInput()
+	h.input = nil
+	h.mu.Unlock()
+	if _, err := h.pty.WriteString(s); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// received waits for fakeclaude to have read at least n bytes since the
+// last send, ending with last, and returns all it read.
+func (h *harness) received(n int, last string) string {
+	h.t.Helper()
+	var got string
+	h.until(fmt.Sprintf("fakeclaude to read %d bytes ending %q", n, last), func() bool {
+		h.parseInput()
+		got = string(h.input)
+		return len(got) >= n && strings.HasSuffix(got, last)
+	})
+	return got
+}
+
+// parseInput adds fakeclaude's reports of its input since the last call to
+// input. The caller holds mu.
+func (h *harness) parseInput() {
+	for _, m := range fakeInput.FindAllSubmatchIndex(h.out[h.read:], -1) {
+		s, err := strconv.Unquote(string(h.out[h.read+m[2] : h.read+m[3]]))
+		if err == nil {
+			h.input = append(h.input, s...)
+		}
+	}
+	if i := bytes.LastIndex(h.out[h.read:], []byte("\r\n")); i >= 0 {
+		h.read += i + 2
+	}
+}
+
+// typed sends s and checks that fakeclaude reads exactly want.
+func (h *harness) typed(s, want string) {
+	h.t.Helper()
+	h.send(s)
+	if got := h.received(len(want), want[len(want)-1:]); got != want {
+		h.t.Errorf("typed %q, fakeclaude read %q, want %q", s, got, want)
+	}
+}
+
+// wait waits for the wrapper to exit and returns its exit code.
+func (h *harness) wait() int {
+	h.t.Helper()
+	exited := make(chan error, 1)
+	go func() { exited <- h.cmd.Wait() }()
+	select {
+	case err := <-exited:
+		var exit *exec.ExitError
+		if errors.As(err, &exit) {
+			return exit.ExitCode()
+		}
+		if err != nil {
+			h.t.Fatal(err)
+		}
+		return 0
+	case <-time.After(harnessTimeout):
+		h.t.Fatal("timed out waiting for the wrapper to exit")
+		return -1
+	}
+}
+
+func TestPTYFocusFilter(t *testing.T) {
+	h := startHarness(t)
+	h.typed("hello", "hello")
+	h.typed("a\x1b[Ib\x1b[Oc", "abc")
+	h.typed("\x1b[A\x1b[1;5D\x1b", "\x1b[A\x1b[1;5D\x1b")
+
+	// Toggled off with the prefix key, reports pass
+	h.send("\x1df")
+	h.expect("focus filter off")
+	h.typed("x\x1b[Iy", "x\x1b[Iy")
+}
+
+func TestPTYNoFilter(t *testing.T) {
+	h := startHarness(t, "--no-filter")
+	h.typed("a\x1b[Ib\x1c", "a\x1b[Ib\x1c")
+}
+
+func TestPTYPaste(t *testing.T) {
+	h := startHarness(t)
+	h.typed("\x1b[200~a\x1b[Ib\x1c\x1b[201~", "\x1b[200~a\x1b[Ib\x1c\x1b[201~")
+}
+
+func TestPTYQuery(t *testing.T) {
+	const reply = "\x1b[?62;22c"
+	h := startHarness(t)
+	for range 2 {
+		h.send("?")
+		h.expect("\x1b[c")
+		h.typed(reply, reply)
+	}
+
+	// With --cache-replies, asked again, the wrapper answers itself
+	h = startHarness(t, "--cache-replies")
+	h.send("?")
+	h.expect("\x1b[c")
+	h.typed(reply, reply)
+	seen := len(h.output())
+	h.send("?")
+	if got := h.received(len(reply), reply); got != "?"+reply {
+		t.Errorf("fakeclaude read %q, want %q", got, "?"+reply)
+	}
+	if bytes.Contains(h.output()[seen:], []byte("\x1b[c")) {
+		t.Error("the repeated query reached the terminal")
+	}
+}
+
+func TestPTYResize(t *testing.T) {
+	h := startHarness(t)
+	if err := pty.Setsize(h.pty, &pty.Winsize{Cols: 100, Rows: 30}); err != nil {
+		t.Fatal(err)
+	}
+	h.expect("size 100x30")
+	if err := pty.Setsize(h.pty, &pty.Winsize{Cols: 40, Rows: 10}); err != nil {
+		t.Fatal(err)
+	}
+	h.expect("size 40x10")
+}
+
+func TestPTYSignals(t *testing.T) {
+	h := startHarness(t)
+	if err := h.cmd.Process.Signal(syscall.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+	h.expect("signal interrupt")
+	h.typed("still here", "still here")
+	if err := h.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	h.expect("signal terminated")
+	if code := h.wait(); code != 128+int(syscall.SIGTERM) {
+		t.Errorf("exited with %d, want %d", code, 128+int(syscall.SIGTERM))
+	}
+}
+
+func TestPTYQuit(t *testing.T) {
+	h := startHarness(t)
+	h.send("\x1c")
+	h.expect("signal terminated")
+	if code := h.wait(); code != 128+int(syscall.SIGTERM) {
+		t.Errorf("exited with %d, want %d", code, 128+int(syscall.SIGTERM))
+	}
+}
+
+func TestPTYExit(t *testing.T) {
+	h := startHarness(t)
+	h.send("q")
+	if code := h.wait(); code != 0 {
+		t.Errorf("exited with %d, want 0", code)
+	}
+}
+
+// pipes returns how many pipes the wrapper has open.
+func (h *harness) pipes() int {
+	h.t.Helper()
+	dir := fmt.Sprintf("/proc/%d/fd", h.cmd.Process.Pid)
+	fds, err := os.ReadDir(dir)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	n := 0
+	for _, fd := range fds {
+		if target, err := os.Readlink(filepath.Join(dir, fd.Name())); err == nil && strings.HasPrefix(target, "pipe:") {
+			n++
+		}
+	}
+	return n
+}
+
+func TestPTYSplice(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("output is only spliced on Linux")
+	}
+
+	// By default the screen model and the status line follow the output,
+	// so it is copied
+	h := startHarness(t)
+	h.typed("hello", "hello")
+	if n := h.pipes(); n != 0 {
+		t.Errorf("with the defaults, the wrapper has %d pipes open, want 0", n)
+	}
+
+	// With nothing else to see it, it goes through splice's pipe
+	home := t.TempDir()
+	config := filepath.Join(home, "config", "claude-unfocused", "config.toml")
+	if err := os.MkdirAll(filepath.Dir(config), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	keys := "[keys]\ntoggle_status = \"\"\ndump_screen = \"\"\npalette = \"\"\nhelp = \"\"\n"
+	if err := os.WriteFile(config, []byte(keys), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h = startHarnessIn(t, home)
+	h.typed("hello", "hello")
+	if h.pipes() == 0 {
+		t.Error("with nothing else watching the output, the wrapper has no pipes open for splice")
+	}
+}
+
+// writeConfig gives the wrapper run in home the config file config.
+func writeConfig(t *testing.T, home, config string) {
+	t.Helper()
+	path := filepath.Join(home, "config", "claude-unfocused", "config.toml")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPTYAuditSentInput(t *testing.T) {
+	home := t.TempDir()
+	writeConfig(t, home, "[[expect]]\npattern = \"^ready\"\nsend = \"yes\\r\"\n")
+	auditPath := filepath.Join(home, "audit.jsonl")
+	h := startHarnessIn(t, home, "--control", "--audit", auditPath)
+	h.expect(`input "yes\r"`)
+
+	sock := filepath.Join(home, "claude-unfocused", "control", strconv.Itoa(h.cmd.Process.Pid)+".sock")
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	if _, err := io.WriteString(conn, `send-keys "hi\r"`+"\n"); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := bufio.NewReader(conn).ReadString('\n'); err != nil || reply != "ok\n" {
+		t.Fatalf("send-keys replied %q, %v", reply, err)
+	}
+	h.expect(`input "hi\r"`)
+
+	var lines []string
+	h.until("both lines in the audit log", func() bool {
+		b, err := os.ReadFile(auditPath)
+		if err != nil {
+			return false
+		}
+		if n, err := verifyAudit(bytes.NewReader(b)); err != nil || n != 2 {
+			return false
+		}
+		lines = nil
+		for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+			var e auditEntry
+			if err := json.Unmarshal([]byte(line), &e); err != nil {
+				t.Fatal(err)
+			}
+			lines = append(lines, e.Line)
+		}
+		return true
+	})
+	if want := []string{"yes", "hi"}; !slices.Equal(lines, want) {
+		t.Errorf("the audit log has %q, want %q", lines, want)
+	}
+}
+
+func TestPTYSessions(t *testing.T) {
+	// The one opened is shown first
+	h := startHarness(t, "--open", t.TempDir())
+	if !bytes.Contains(h.output(), []byte("session 2/2")) {
+		t.Fatal("the opened session wasn't shown")
+	}
+	h.typed("two", "two")
+
+	// Switched back, the first child gets the input
+	h.send("\x1dn")
+	h.expect("session 1/2")
+	h.typed("one", "one")
+	h.send("q")
+	h.expect("session 2/2")
+	h.send("q")
+	if code := h.wait(); code != 0 {
+		t.Errorf("exited with %d, want 0", code)
+	}
+}
+
+func TestPTYKill(t *testing.T) {
+	home := t.TempDir()
+	// The session's server starts claude before the client attaches
+	h := launchHarness(t, home, "--detach", "--session", "work")
+	h.expect("size 80x24")
+	h.typed("hello", "hello")
+	if out, err := wrapperCommand(t, home, "kill", "work").CombinedOutput(); err != nil {
+		t.Fatalf("kill: %v: %s", err, out)
+	}
+	// The attached client stays attached to see claude go
+	h.expect("signal terminated")
+	h.wait()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/samuelstevens/claude-unfocused/internal/ansiparse"
+	"github.com/samuelstevens/claude-unfocused/pkg/escfilter"
+)
+
+// replyCache answers the child's terminal queries itself once the terminal
+// has answered them, so repeated queries (for the device attributes or the
+// background color, which a TUI library may ask for on every redraw) don't
+// make a round trip to a terminal that may be far away, nor race with the
+// input filter. Only queries whose answers hold for the whole session are
+// cached; the cursor position and mode reports are always passed on.
+type replyCache struct {
+	answer func([]byte) // sends a reply to the child
+	trace  *tracer
+
+	mu      sync.Mutex
+	replies map[string][]byte
+}
+
+func newReplyCache(answer func([]byte), trace *tracer) *replyCache {
+	return &replyCache{answer: answer, trace: trace, replies: map[string][]byte{}}
+}
+
+// cacheable reports whether the reply to the query named name can be kept.
+func cacheable(name string) bool {
+	switch name {
+	case "DA1", "DA2", "DA3", "XTVERSION":
+		return true
+	}
+	return strings.HasPrefix(name, "OSC ")
+}
+
+// filterEvent keeps the terminal's replies, as reported by the input filter.
+func (c *replyCache) filterEvent(e escfilter.Event) {
+	if e.Action != "reply" || !cacheable(e.Kind) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.replies[e.Kind] = append([]byte(nil), e.Raw...)
+}
+
+// rule answers a query from the cache instead of passing it on.
+func (c *replyCache) rule() outputRule {
+	return func(seq ansiparse.Sequence) ([]byte, bool) {
+		name := seq.Query()
+		if name == "" {
+			return nil, false
+		}
+		c.mu.Lock()
+		reply, ok := c.replies[name]
+		c.mu.Unlock()
+		if !ok {
+			return nil, false
+		}
+		c.trace.raw("out", "answer", name, seq.Raw)
+		c.answer(reply)
+		return nil, true
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"filippo.io/age"
+)
+
+// recorder writes a session as an asciicast v2 file: a JSON header line
+// followed by one [time, type, data] line per event. A nil *recorder
+// discards everything, so callers need not check whether recording is on.
+type recorder struct {
+	mu    sync.Mutex
+	f     io.WriteCloser
+	start time.Time
+	input bool
+	tails map[string][]byte // incomplete UTF-8 held back per event type
+	// redact, if set, masks secrets; the start of one may be held back in
+	// tails too
+	redact *redactor
+}
+
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Command   string            `json:"command,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// newRecorder creates the recording at path, encrypted to recipients if
+// there are any. Input events are only written if input is set.
+func newRecorder(path string, cols, rows int, argv []string, input bool, recipients []age.Recipient, redact *redactor) (*recorder, error) {
+	var f io.WriteCloser
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if len(recipients) > 0 {
+		if f, err = encryptTo(f, recipients); err != nil {
+			return nil, err
+		}
+	}
+	r := &recorder{f: f, start: time.Now(), input: input, tails: map[string][]byte{}, redact: redact}
+	header, err := json.Marshal(castHeader{
+		Version:   2,
+		Width:     cols,
+		Height:    rows,
+		Timestamp: r.start.Unix(),
+		Command:   strings.Join(argv, " "),
+		Env:       map[string]string{"TERM": os.Getenv("TERM"), "SHELL": os.Getenv("SHELL")},
+	})
+	if err == nil {
+		_, err = fmt.Fprintf(f, "%s\n", header)
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Write records child output, so a recorder can sit in an io.MultiWriter.
+func (r *recorder) Write(b []byte) (int, error) {
+	r.event("o", b)
+	return len(b), nil
+}
+
+// recordInput records bytes delivered to the child, if input recording is on.
+func (r *recorder) recordInput(b []byte) {
+	if r != nil && r.input {
+		r.event("i", b)
+	}
+}
+
+// resize records a terminal size change.
+func (r *recorder) resize(cols, rows int) {
+	r.event("r", fmt.Appendf(nil, "%dx%d", cols, rows))
+}
+
+func (r *recorder) event(kind string, b []byte) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return
+	}
+	// asciicast data must be valid UTF-8, so never split a character
+	b = append(r.tails[kind], b...)
+	n := r.redact.complete(b[:completeUTF8(b)])
+	r.tails[kind] = append([]byte(nil), b[n:]...)
+	r.write(kind, b[:n])
+}
+
+// write writes an event. The caller holds mu.
+func (r *recorder) write(kind string, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	data, _ := json.Marshal(string(r.redact.mask(b)))
+	_, _ = fmt.Fprintf(r.f, "[%.6f, %q, %s]\n", time.Since(r.start).Seconds(), kind, data)
+}
+
+// Close finishes the recording.
+func (r *recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.redact != nil {
+		for _, kind := range []string{"o", "i"} {
+			b := r.tails[kind]
+			r.write(kind, b[:completeUTF8(b)])
+		}
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
+
+// completeUTF8 returns the length of b without a trailing incomplete UTF-8
+// sequence that later bytes could still complete.
+func completeUTF8(b []byte) int {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if !utf8.RuneStart(b[i]) {
+			continue
+		}
+		if !utf8.FullRune(b[i:]) {
+			return i
+		}
+		break
+	}
+	return len(b)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"regexp"
+	"slices"
+	"sync"
+)
+
+// secretPatterns match well-known kinds of credentials.
+var secretPatterns = []string{
+	`AKIA[0-9A-Z]{16}`,                                           // AWS access key ID
+	`gh[pousr]_[A-Za-z0-9]{36,}`,                                 // GitHub token
+	`github_pat_[A-Za-z0-9_]{22,}`,                               // GitHub fine-grained token
+	`sk-ant-[A-Za-z0-9_-]{20,}`,                                  // Anthropic API key
+	`sk-[A-Za-z0-9_-]{32,}`,                                      // OpenAI-style API key
+	`xox[abposr]-[A-Za-z0-9-]{10,}`,                              // Slack token
+	`eyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]+`, // JWT
+}
+
+// maxHeldSecret bounds how much of a possibly unfinished secret a
+// redactedFile holds back.
+const maxHeldSecret = 512
+
+// redactor masks secrets, matched by regular expressions, with asterisks,
+// keeping their length so recorded screens keep their layout.
+type redactor struct {
+	res []*regexp.Regexp
+}
+
+// newRedactor returns a redactor for the built-in patterns and extra.
+func newRedactor(extra []string) (*redactor, error) {
+	var res []*regexp.Regexp
+	for _, p := range append(slices.Clip(secretPatterns), extra...) {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+	return &redactor{res: res}, nil
+}
+
+// mask returns b with its secrets masked. A nil *redactor returns b.
+func (r *redactor) mask(b []byte) []byte {
+	if r == nil {
+		return b
+	}
+	var out []byte // b's copy, once there is something to mask
+	for _, re := range r.res {
+		for _, loc := range re.FindAllIndex(b, -1) {
+			if out == nil {
+				out = bytes.Clone(b)
+			}
+			for i := loc[0]; i < loc[1]; i++ {
+				out[i] = '*'
+			}
+		}
+	}
+	if out == nil {
+		return b
+	}
+	return out
+}
+
+// complete returns how much of b can be masked without waiting for more:
+// all but a trailing run of the characters secrets are made of, which the
+// next bytes may continue. A nil *redactor returns len(b).
+func (r *redactor) complete(b []byte) int {
+	if r == nil {
+		return len(b)
+	}
+	i := len(b)
+	for i > 0 && len(b)-i < maxHeldSecret && secretByte(b[i-1]) {
+		i--
+	}
+	if len(b)-i >= maxHeldSecret {
+		return len(b)
+	}
+	return i
+}
+
+func secretByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '-' || c == '.' || c == '+' || c == '/' || c == '='
+}
+
+// redactedFile masks secrets on their way to a file, holding back the end of
+// each write until it is known not to be the start of a secret.
+type redactedFile struct {
+	mu   sync.Mutex
+	f    io.WriteCloser
+	r    *redactor
+	held []byte
+}
+
+// redactTo returns f unchanged if r is nil, or else wrapped in a
+// redactedFile.
+func redactTo(f io.WriteCloser, r *redactor) io.WriteCloser {
+	if r == nil {
+		return f
+	}
+	return &redactedFile{f: f, r: r}
+}
+
+func (rf *redactedFile) Write(b []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	buf := append(rf.held, b...)
+	n := rf.r.complete(buf)
+	if _, err := rf.f.Write(rf.r.mask(buf[:n])); err != nil {
+		return 0, err
+	}
+	rf.held = append(rf.held[:0:0], buf[n:]...)
+	return len(b), nil
+}
+
+// Close writes what was held back and closes the file.
+func (rf *redactedFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	_, err := rf.f.Write(rf.r.mask(rf.held))
+	rf.held = nil
+	return errors.Join(err, rf.f.Close())
+}
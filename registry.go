@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// sessionInfo describes a background session. Its server keeps it in a
+// file beside the session's socket while the child runs.
+type sessionInfo struct {
+	Name    string    `json:"name"`
+	PID     int       `json:"pid"`    // the child's
+	Server  int       `json:"server"` // the session server's
+	Dir     string    `json:"dir"`
+	Command []string  `json:"command"`
+	Started time.Time `json:"started"`
+}
+
+// infoPath returns the path of the metadata file for the session at sock.
+func infoPath(sock string) string {
+	return strings.TrimSuffix(sock, ".sock") + ".json"
+}
+
+func writeSessionInfo(sock string, info sessionInfo) error {
+	b, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(infoPath(sock), b, 0o600)
+}
+
+// readSessionInfo returns the metadata of the named session. A session
+// started by an older server has none, and gets just its name.
+func readSessionInfo(name string) (sessionInfo, error) {
+	info := sessionInfo{Name: name}
+	sock, err := sessionSocket(name)
+	if err != nil {
+		return info, err
+	}
+	b, err := os.ReadFile(infoPath(sock))
+	if err != nil {
+		return info, nil
+	}
+	err = json.Unmarshal(b, &info)
+	return info, err
+}
+
+const killUsage = "usage: claude-unfocused kill <name>..."
+
+// runList implements the ls subcommand.
+func runList(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "usage: claude-unfocused ls")
+		return 2
+	}
+	names, err := listSessions()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ls: %v\n", err)
+		return 1
+	}
+	if len(names) == 0 {
+		fmt.Fprintln(os.Stderr, "no running sessions")
+		return 0
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tPID\tSTARTED\tDIR\tCOMMAND")
+	for _, name := range names {
+		info, _ := readSessionInfo(name)
+		pid, started := "-", "-"
+		if info.PID > 0 {
+			pid = fmt.Sprint(info.PID)
+		}
+		if !info.Started.IsZero() {
+			started = info.Started.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", name, pid, started, info.Dir, strings.Join(info.Command, " "))
+	}
+	_ = w.Flush()
+	return 0
+}
+
+// runKill implements the kill subcommand: each named session's server sends
+// its child SIGTERM and, if it hasn't exited after quitTimeout, kills it.
+func runKill(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, killUsage)
+		return 2
+	}
+	code := 0
+	for _, name := range args {
+		if err := killSession(name); err != nil {
+			fmt.Fprintf(os.Stderr, "kill: %v\n", err)
+			code = 1
+		}
+	}
+	return code
+}
+
+func killSession(name string) error {
+	sock, err := sessionSocket(name)
+	if err != nil {
+		return err
+	}
+	// Signalled by its server, the child can't be mistaken for a process
+	// that took over a stale PID
+	conn, err := net.DialTimeout("unix", sock, time.Second)
+	if err != nil {
+		return fmt.Errorf("no session %q", name)
+	}
+	defer func() { _ = conn.Close() }()
+	child := newRemoteSession(conn)
+	if err := child.Signal(syscall.SIGTERM); err != nil {
+		return err
+	}
+	for deadline := time.Now().Add(quitTimeout); time.Now().Before(deadline); {
+		if !sessionAlive(sock) {
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return child.Kill()
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+)
+
+// sshCommand returns the command running argv on host over ssh. With tty,
+// ssh is asked for a remote terminal, whose size follows the local one.
+func sshCommand(host string, argv []string, tty bool) []string {
+	cmd := []string{"ssh"}
+	if tty {
+		cmd = append(cmd, "-t")
+	}
+	cmd = append(cmd, host, "--")
+	// The remote shell splits what ssh sends it
+	for _, arg := range argv {
+		cmd = append(cmd, shellQuote(arg))
+	}
+	return cmd
+}
+
+// containerCommand returns the command running argv in a running container
+// with engine, docker or podman. With tty, the engine is asked for a
+// terminal, whose size follows the local one.
+func containerCommand(engine, container string, argv []string, tty bool) []string {
+	cmd := []string{engine, "exec", "-i"}
+	if tty {
+		cmd = append(cmd, "-t", "-e", "TERM")
+	}
+	return append(append(cmd, container), argv...)
+}
+
+// shellQuote quotes s for a POSIX shell, leaving it as it is when that is
+// safe.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./=:@,+%") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
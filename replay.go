@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"filippo.io/age"
+	"github.com/spf13/pflag"
+	"golang.org/x/term"
+)
+
+const replayUsage = "usage: claude-unfocused replay [--speed N] [--idle-limit D] [--identity FILE] <recording>"
+
+// player replays asciicast output events, responding to keypresses:
+// space pauses, '.' steps while paused, '+'/'-' change speed, 'q' quits.
+type player struct {
+	speed  float64
+	paused bool
+	keys   <-chan byte
+}
+
+// runReplay implements the replay subcommand.
+func runReplay(args []string) int {
+	fs := pflag.NewFlagSet("replay", pflag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	speed := fs.Float64P("speed", "s", 1, "playback speed multiplier")
+	idleLimit := fs.Duration("idle-limit", 0, "cap pauses between events (0 for no cap)")
+	identity := fs.StringP("identity", "i", "", "age identity file to decrypt an encrypted recording with")
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "replay: %v\n%s\n", err, replayUsage)
+		return 2
+	}
+	if fs.NArg() != 1 || *speed <= 0 {
+		fmt.Fprintln(os.Stderr, replayUsage)
+		return 2
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replay: %v\n", err)
+		return 1
+	}
+	defer func() { _ = f.Close() }()
+	var identities []age.Identity
+	if *identity != "" {
+		if identities, err = parseIdentities(*identity); err != nil {
+			fmt.Fprintf(os.Stderr, "replay: %v\n", err)
+			return 1
+		}
+	}
+	r, err := decrypted(bufio.NewReader(f), identities)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replay: %v\n", err)
+		return 1
+	}
+
+	p := &player{speed: *speed}
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
+		if err == nil {
+			defer func() { _ = term.Restore(int(os.Stdin.Fd()), oldState) }()
+			p.keys = readKeys(os.Stdin)
+		}
+	}
+
+	if err := p.play(r, *idleLimit); err != nil {
+		fmt.Fprintf(os.Stderr, "\r\nreplay: %v\r\n", err)
+		return 1
+	}
+	return 0
+}
+
+// play writes the recording's output events to stdout with their original
+// timing, scaled by speed and with gaps capped at idleLimit if it is set.
+func (p *player) play(r *bufio.Reader, idleLimit time.Duration) error {
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return fmt.Errorf("read header: %w", err)
+	}
+	var header castHeader
+	if err := json.Unmarshal(line, &header); err != nil {
+		return fmt.Errorf("parse header: %w", err)
+	}
+	if header.Version != 2 {
+		return fmt.Errorf("unsupported asciicast version %d", header.Version)
+	}
+
+	var last float64
+	for n := 2; ; n++ {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) && len(line) == 0 {
+			return nil
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		var (
+			at   float64
+			kind string
+			data string
+		)
+		if err := json.Unmarshal(line, &[]any{&at, &kind, &data}); err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+		if kind != "o" {
+			continue
+		}
+		delay := time.Duration((at - last) * float64(time.Second))
+		if idleLimit > 0 {
+			delay = min(delay, idleLimit)
+		}
+		last = at
+		if !p.wait(delay) {
+			return nil
+		}
+		_, _ = io.WriteString(os.Stdout, data)
+	}
+}
+
+// wait sleeps for d of recording time while handling keypresses. It
+// reports false if playback should stop.
+func (p *player) wait(d time.Duration) bool {
+	for d > 0 || p.paused {
+		var timer <-chan time.Time
+		start := time.Now()
+		if !p.paused {
+			timer = time.After(time.Duration(float64(d) / p.speed))
+		}
+		select {
+		case <-timer:
+			return true
+		case key, ok := <-p.keys:
+			if !ok {
+				p.keys, p.paused = nil, false
+				continue
+			}
+			if !p.paused {
+				d -= time.Duration(float64(time.Since(start)) * p.speed)
+			}
+			switch key {
+			case ' ':
+				p.paused = !p.paused
+			case '.':
+				if p.paused {
+					return true
+				}
+			case '+', '=':
+				p.speed = min(p.speed*2, 64)
+			case '-':
+				p.speed = max(p.speed/2, 1.0/64)
+			case 'q', 0x03:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// readKeys delivers bytes read from r until it fails.
+func readKeys(r io.Reader) <-chan byte {
+	keys := make(chan byte)
+	go func() {
+		defer close(keys)
+		buf := make([]byte, 64)
+		for {
+			n, err := r.Read(buf)
+			for _, b := range buf[:n] {
+				keys <- b
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return keys
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/samuelstevens/claude-unfocused/pkg/ptyproxy"
+)
+
+// Restart backoff: the first restart waits restartBackoff, doubling for each
+// further crash up to maxRestartBackoff. A child that ran for restartReset
+// before crashing starts the count over.
+const (
+	restartBackoff    = 500 * time.Millisecond
+	maxRestartBackoff = 30 * time.Second
+	restartReset      = time.Minute
+)
+
+// restarter is a ptyproxy.Session that restarts its child when it crashes,
+// exiting non-zero or by a signal, up to max times in a row. The proxy sees
+// one session throughout, so the terminal stays set up and input keeps
+// flowing to whichever child is current. Exits the wrapper asked for, by
+// Kill or a terminating signal, are not restarted.
+type restarter struct {
+	start func() (ptyproxy.Session, error)
+	max   int
+	name  string
+	// onRestart, if set, is called each time a new child takes over
+	onRestart func()
+
+	mu         sync.Mutex
+	cur        ptyproxy.Session
+	started    time.Time
+	next       chan struct{} // closed once Wait has replaced cur or given up
+	final      bool          // cur is the last child
+	cols, rows int
+	stopping   bool
+	stop       chan struct{}
+}
+
+func newRestarter(first ptyproxy.Session, max int, name string, start func() (ptyproxy.Session, error)) *restarter {
+	return &restarter{
+		start:   start,
+		max:     max,
+		name:    name,
+		cur:     first,
+		started: time.Now(),
+		next:    make(chan struct{}),
+		stop:    make(chan struct{}),
+	}
+}
+
+func (r *restarter) current() (ptyproxy.Session, chan struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cur, r.next
+}
+
+// Read returns the current child's output. When it ends, Read waits to see
+// whether a new child takes over before reporting the end.
+func (r *restarter) Read(b []byte) (int, error) {
+	for {
+		s, next := r.current()
+		n, err := s.Read(b)
+		if n > 0 || err == nil {
+			return n, nil
+		}
+		<-next
+		r.mu.Lock()
+		final := r.final
+		r.mu.Unlock()
+		if final {
+			return 0, err
+		}
+	}
+}
+
+func (r *restarter) Write(b []byte) (int, error) {
+	s, _ := r.current()
+	return s.Write(b)
+}
+
+func (r *restarter) Resize(cols, rows int) error {
+	r.mu.Lock()
+	r.cols, r.rows = cols, rows
+	s := r.cur
+	r.mu.Unlock()
+	return s.Resize(cols, rows)
+}
+
+func (r *restarter) Pid() int {
+	s, _ := r.current()
+	return s.Pid()
+}
+
+func (r *restarter) Signal(sig os.Signal) error {
+	switch sig {
+	case os.Interrupt, os.Kill, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGHUP:
+		r.halt()
+	}
+	s, _ := r.current()
+	return s.Signal(sig)
+}
+
+func (r *restarter) Kill() error {
+	r.halt()
+	s, _ := r.current()
+	return s.Kill()
+}
+
+// restartChild restarts the current child of session as if it had crashed.
+func restartChild(session ptyproxy.Session) error {
+	if ss, ok := session.(statusSession); ok {
+		session = ss.Session
+	}
+	if mux, ok := session.(*sessions); ok {
+		session = mux.current().(*managed).Session
+	}
+	r, ok := session.(*restarter)
+	if !ok {
+		return errors.New("not available in a background session")
+	}
+	return r.kick()
+}
+
+// kick kills the current child as a crash, so that it is restarted.
+func (r *restarter) kick() error {
+	s, _ := r.current()
+	return s.Kill()
+}
+
+// halt stops any further restarts.
+func (r *restarter) halt() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.stopping {
+		r.stopping = true
+		close(r.stop)
+	}
+}
+
+// Wait waits for the last child to exit, restarting crashed ones on the
+// way, and returns its exit code.
+func (r *restarter) Wait() (int, error) {
+	for attempt := 1; ; attempt++ {
+		s, _ := r.current()
+		code, err := s.Wait()
+
+		r.mu.Lock()
+		if time.Since(r.started) >= restartReset {
+			attempt = 1
+		}
+		if code == 0 || err != nil || r.stopping || attempt > r.max {
+			r.final = true
+			close(r.next)
+			r.mu.Unlock()
+			return code, err
+		}
+		r.mu.Unlock()
+
+		delay := min(restartBackoff<<min(attempt-1, 10), maxRestartBackoff)
+		notice(fmt.Sprintf("%s exited with status %d, restarting in %v (%d/%d)", r.name, code, delay, attempt, r.max))
+		next, startErr := r.relaunch(delay)
+
+		r.mu.Lock()
+		if next == nil || r.stopping {
+			if startErr != nil {
+				notice("restart failed: " + startErr.Error())
+			}
+			if next != nil {
+				_ = next.Kill()
+				_ = next.Close()
+			}
+			r.final = true
+			close(r.next)
+			r.mu.Unlock()
+			return code, nil
+		}
+		if r.cols > 0 && r.rows > 0 {
+			_ = next.Resize(r.cols, r.rows)
+		}
+		r.cur, r.started = next, time.Now()
+		close(r.next)
+		r.next = make(chan struct{})
+		r.mu.Unlock()
+		_ = s.Close()
+		if r.onRestart != nil {
+			r.onRestart()
+		}
+	}
+}
+
+// relaunch starts the next child after delay. It returns nil if restarts
+// were stopped in the meantime.
+func (r *restarter) relaunch(delay time.Duration) (ptyproxy.Session, error) {
+	select {
+	case <-time.After(delay):
+	case <-r.stop:
+		return nil, nil
+	}
+	return r.start()
+}
+
+func (r *restarter) Close() error {
+	s, _ := r.current()
+	return s.Close()
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+
+	"github.com/samuelstevens/claude-unfocused/pkg/ptyproxy"
+)
+
+// scriptHooks are the functions a script may define, and what they are
+// called with.
+var scriptHooks = []string{
+	"on_start",  // (argv, pid)
+	"on_input",  // (data): returns the bytes to send instead, or None
+	"on_output", // (data): returns the bytes to show instead, or None
+	"on_exit",   // (code)
+}
+
+// script runs the hooks a Starlark file defines. Besides Starlark's own
+// builtins, the file can call send(data) to type into the child,
+// notice(msg) to show a message on the bottom line, and keep what it likes
+// in the state dict between calls. A hook that fails is logged and not
+// called again. A nil *script does nothing.
+type script struct {
+	path string
+
+	mu     sync.Mutex // one hook at a time
+	thread *starlark.Thread
+	hooks  map[string]starlark.Callable
+	send   func([]byte) // set once the session is known
+}
+
+func loadScript(path string) (*script, error) {
+	s := &script{path: path, hooks: map[string]starlark.Callable{}}
+	s.thread = &starlark.Thread{
+		Name:  "script",
+		Print: func(_ *starlark.Thread, msg string) { log.Printf("%s: %s", path, msg) },
+	}
+	predeclared := starlark.StringDict{
+		"send":   starlark.NewBuiltin("send", s.builtinSend),
+		"notice": starlark.NewBuiltin("notice", builtinNotice),
+		"state":  starlark.NewDict(0),
+	}
+	opts := &syntax.FileOptions{Set: true, While: true, TopLevelControl: true, GlobalReassign: true}
+	globals, err := starlark.ExecFileOptions(opts, s.thread, path, nil, predeclared)
+	if err != nil {
+		return nil, scriptError(err)
+	}
+	for _, name := range scriptHooks {
+		if fn, ok := globals[name].(starlark.Callable); ok {
+			s.hooks[name] = fn
+		}
+	}
+	return s, nil
+}
+
+// scriptError returns err with the Starlark backtrace, if it has one.
+func scriptError(err error) error {
+	var evalErr *starlark.EvalError
+	if errors.As(err, &evalErr) {
+		return errors.New(evalErr.Backtrace())
+	}
+	return err
+}
+
+// defines reports whether the script has the hook name.
+func (s *script) defines(name string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.hooks[name]
+	return ok
+}
+
+// call runs the hook name, if the script defines it, and returns its
+// result, or nil if there is no hook or it failed.
+func (s *script) call(name string, args ...starlark.Value) starlark.Value {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn, ok := s.hooks[name]
+	if !ok {
+		return nil
+	}
+	v, err := starlark.Call(s.thread, fn, args, nil)
+	if err != nil {
+		delete(s.hooks, name)
+		log.Printf("%s: %s: %v", s.path, name, scriptError(err))
+		notice(fmt.Sprintf("script %s failed and is off; see the log", name))
+		return nil
+	}
+	return v
+}
+
+// start calls on_start with the child's command line and process ID.
+func (s *script) start(argv []string, pid int) {
+	if s == nil {
+		return
+	}
+	list := make([]starlark.Value, len(argv))
+	for i, arg := range argv {
+		list[i] = starlark.String(arg)
+	}
+	s.call("on_start", starlark.NewList(list), starlark.MakeInt(pid))
+}
+
+// exit calls on_exit with the child's exit code.
+func (s *script) exit(code int) {
+	s.call("on_exit", starlark.MakeInt(code))
+}
+
+// filter passes b through the hook name, returning what it returned
+// instead, or b if it returned nothing usable.
+func (s *script) filter(name string, b []byte) []byte {
+	switch v := s.call(name, starlark.Bytes(b)).(type) {
+	case starlark.Bytes:
+		return []byte(v)
+	case starlark.String:
+		return []byte(v)
+	case nil, starlark.NoneType:
+		return b
+	default:
+		log.Printf("%s: %s returned %s, not bytes", s.path, name, v.Type())
+		return b
+	}
+}
+
+func (s *script) builtinSend(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var data starlark.Value
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &data); err != nil {
+		return nil, err
+	}
+	var b []byte
+	switch v := data.(type) {
+	case starlark.Bytes:
+		b = []byte(v)
+	case starlark.String:
+		b = []byte(v)
+	default:
+		return nil, fmt.Errorf("send: got %s, want bytes or string", data.Type())
+	}
+	if s.send != nil {
+		s.send(b)
+	}
+	return starlark.None, nil
+}
+
+func builtinNotice(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var msg string
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &msg); err != nil {
+		return nil, err
+	}
+	notice(msg)
+	return starlark.None, nil
+}
+
+// scriptedSession passes a session's input through on_input.
+type scriptedSession struct {
+	ptyproxy.Session
+	s *script
+}
+
+func (ss scriptedSession) Write(b []byte) (int, error) {
+	if out := ss.s.filter("on_input", b); len(out) > 0 {
+		if _, err := ss.Session.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// scriptedOutput passes the child's output through on_output.
+type scriptedOutput struct {
+	w io.Writer
+	s *script
+}
+
+func (so scriptedOutput) Write(b []byte) (int, error) {
+	if out := so.s.filter("on_output", b); len(out) > 0 {
+		if _, err := so.w.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
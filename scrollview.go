@@ -0,0 +1,542 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/samuelstevens/claude-unfocused/internal/ansiparse"
+	"github.com/samuelstevens/claude-unfocused/internal/vt"
+	"github.com/samuelstevens/claude-unfocused/pkg/ptyproxy"
+)
+
+// maxHeldOutput bounds how much of the child's output an outputGate keeps
+// while it is held.
+const maxHeldOutput = 4 << 20
+
+// wheelLines is how far one step of the mouse wheel scrolls.
+const wheelLines = 3
+
+// outputGate passes the child's output on to the terminal, or keeps it
+// while the wrapper has the screen, to write once it is done.
+type outputGate struct {
+	mu   sync.Mutex
+	w    io.Writer
+	held bool
+	buf  []byte
+	lost bool // more was held than maxHeldOutput
+}
+
+func (g *outputGate) Write(b []byte) (int, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.held {
+		return g.w.Write(b)
+	}
+	if len(g.buf)+len(b) > maxHeldOutput {
+		g.buf, g.lost = nil, true
+	}
+	if !g.lost {
+		g.buf = append(g.buf, b...)
+	}
+	return len(b), nil
+}
+
+// hold keeps output back until release.
+func (g *outputGate) hold() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.held, g.buf, g.lost = true, nil, false
+}
+
+// release writes what was kept back and lets output through again,
+// reporting whether any had to be dropped.
+func (g *outputGate) release() (lost bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, _ = g.w.Write(g.buf)
+	lost = g.lost
+	g.held, g.buf, g.lost = false, nil, false
+	return lost
+}
+
+// keyReader splits terminal input into keys: printable characters as
+// themselves, the rest by the names escfilter.ParseKeys uses ("enter",
+// "ctrl-u", "pageup" and so on). Keys it doesn't know are dropped.
+type keyReader struct {
+	parser ansiparse.Parser
+	ss3    bool // ESC O was read; the next character names the key
+}
+
+var ss3Keys = map[byte]string{'A': "up", 'B': "down", 'C': "right", 'D': "left", 'H': "home", 'F': "end"}
+
+var tildeKeys = map[int]string{1: "home", 3: "delete", 4: "end", 5: "pageup", 6: "pagedown", 7: "home", 8: "end"}
+
+func (k *keyReader) feed(b []byte, fn func(key string)) {
+	if len(b) == 1 && b[0] == 0x1b {
+		fn("esc") // alone in its read, so not the start of a sequence
+		return
+	}
+	k.parser.Feed(b, func(seq ansiparse.Sequence) {
+		switch seq.Kind {
+		case ansiparse.Text:
+			text := string(seq.Raw)
+			if k.ss3 {
+				k.ss3 = false
+				if key, ok := ss3Keys[text[0]]; ok {
+					fn(key)
+				}
+				text = text[1:]
+			}
+			for _, r := range text {
+				fn(string(r))
+			}
+		case ansiparse.Control:
+			switch c := seq.Raw[0]; {
+			case c == '\r' || c == '\n':
+				fn("enter")
+			case c == '\t':
+				fn("tab")
+			case c == 0x7f || c == '\b':
+				fn("backspace")
+			case c >= 1 && c <= 26:
+				fn("ctrl-" + string(rune('a'+c-1)))
+			}
+		case ansiparse.Esc:
+			k.ss3 = seq.Final == 'O' && len(seq.Intermediates) == 0
+		case ansiparse.CSI:
+			switch {
+			case len(seq.Intermediates) > 0:
+			case seq.Private() == '<' && (seq.Final == 'M' || seq.Final == 'm'):
+				fn(mouseKey(seq))
+			case seq.Private() != 0:
+			case seq.Final == '~':
+				if args := seq.Ints(); len(args) > 0 && tildeKeys[args[0]] != "" {
+					fn(tildeKeys[args[0]])
+				}
+			default:
+				if key, ok := ss3Keys[seq.Final]; ok {
+					fn(key)
+				}
+			}
+		}
+	})
+}
+
+// mouseKey names an SGR mouse report: "wheelup" or "wheeldown" for the
+// wheel, "mouse" for the rest.
+func mouseKey(seq ansiparse.Sequence) string {
+	if args := seq.Ints(); seq.Final == 'M' && len(args) == 3 {
+		switch args[0] &^ (4 | 8 | 16) { // shift, meta, ctrl
+		case 64:
+			return "wheelup"
+		case 65:
+			return "wheeldown"
+		}
+	}
+	return "mouse"
+}
+
+// viewModes are the ways a scrollView opens.
+type viewMode int
+
+const (
+	viewSearch   viewMode = iota // at a search prompt
+	viewCopy                     // in copy mode
+	viewScrolled                 // scrolled up by the wheel, until output arrives
+)
+
+// scrollView shows the child's scrollback, the lines that have scrolled off
+// the screen followed by the screen itself, full-screen over the child and
+// lets it be paged through and searched, less-style, or, in copy mode,
+// selected from with a cursor (see copymode.go). The child's output is
+// held back meanwhile. When the child is on the main screen the view uses
+// the terminal's alternate screen, so the terminal restores what was there;
+// otherwise the child is asked to repaint. Scrolled up by the wheel, it
+// goes back once the child has more output, or another key is pressed,
+// which the child gets. A nil *scrollView does nothing.
+type scrollView struct {
+	screen  *vt.Screen
+	gate    *outputGate
+	w       io.Writer // the terminal
+	session ptyproxy.Session
+	capture func(func([]byte) bool) // takes terminal input, or gives it back with nil
+	yank    func([]byte)            // copies text to the clipboard
+
+	mu         sync.Mutex
+	open       bool
+	scrolled   bool // opened by the wheel
+	keys       keyReader
+	lines      []string
+	top        int // first line shown
+	cols, rows int
+	alt        bool   // the view took the terminal's alternate screen
+	prompt     string // "/" or "?" while a pattern is typed
+	typed      []rune
+	re         *regexp.Regexp // the last pattern searched for
+	backward   bool           // it was searched for with ?
+	match      int            // the line of the last match, or -1
+	message    string
+
+	copying    bool // in copy mode
+	cy, cx     int  // the cursor, as a line and a character in it
+	selecting  bool // a selection runs from the anchor to the cursor
+	lineSelect bool // whole lines are selected
+	ay, ax     int  // the anchor
+}
+
+// show opens the view at the bottom of the scrollback, ready to search up.
+func (v *scrollView) show() {
+	v.start(viewSearch)
+}
+
+// wheel scrolls the view with the mouse wheel, opening it if need be.
+func (v *scrollView) wheel(up bool) {
+	if !up {
+		return // already at the bottom
+	}
+	v.start(viewScrolled)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.key("wheelup")
+	if v.open {
+		v.draw()
+	}
+}
+
+// start opens the view in mode. It does nothing while the view is open.
+func (v *scrollView) start(mode viewMode) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.open {
+		return
+	}
+	v.cols, v.rows = v.screen.Size()
+	if cols, rows, err := ptyproxy.TermSize(); err == nil && cols > 0 && rows > 0 {
+		v.cols, v.rows = cols, rows
+	}
+	lines := v.screen.Lines()
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	history := v.screen.History()
+	x, y := v.screen.Cursor()
+	v.lines = append(history, lines...)
+	v.top = max(len(v.lines)-v.page(), 0)
+	v.keys = keyReader{}
+	v.prompt, v.typed, v.match, v.message = "?", nil, -1, ""
+	v.copying, v.scrolled, v.selecting = mode == viewCopy, mode == viewScrolled, false
+	if mode != viewSearch {
+		v.prompt = ""
+	}
+	if v.copying {
+		// Start where the child's cursor is
+		v.cy, v.cx = min(len(history)+y, max(len(v.lines)-1, 0)), x
+		v.clampCursor()
+	}
+	v.open = true
+	v.gate.hold()
+	v.alt = !v.screen.AltScreen()
+	if v.alt {
+		_, _ = io.WriteString(v.w, "\x1b[?1049h")
+	}
+	v.capture(v.input)
+	v.draw()
+}
+
+// close gives the screen back to the child. The caller holds mu.
+func (v *scrollView) close() {
+	v.open = false
+	v.capture(nil)
+	if v.alt {
+		_, _ = io.WriteString(v.w, "\x1b[?1049l")
+	} else {
+		_, _ = io.WriteString(v.w, "\x1b[0m\x1b[H\x1b[2J")
+	}
+	if v.gate.release() || !v.alt {
+		ptyproxy.Refresh(v.session)
+	}
+}
+
+// Write watches the child's output, after the terminal has been given it,
+// so a scrollView can sit in an io.MultiWriter: output closes the view the
+// wheel opened.
+func (v *scrollView) Write(b []byte) (int, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.open && v.scrolled {
+		v.close()
+	}
+	return len(b), nil
+}
+
+// resize redraws the view for a resized terminal.
+func (v *scrollView) resize(cols, rows int) {
+	if v == nil {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !v.open {
+		return
+	}
+	v.cols, v.rows = cols, rows
+	v.scroll(0)
+	v.draw()
+}
+
+// page is how many lines the view shows: all but the bottom row.
+func (v *scrollView) page() int {
+	return max(v.rows-1, 1)
+}
+
+// input handles terminal input while the view is open, reporting whether
+// it took it.
+func (v *scrollView) input(b []byte) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	took := true
+	v.keys.feed(b, func(key string) {
+		if !v.open {
+			return
+		}
+		switch {
+		case v.scrolled && !slices.Contains([]string{"wheelup", "wheeldown", "mouse", "q", "esc"}, key):
+			// Typing goes back to the child
+			v.close()
+			took = false
+		case v.prompt != "":
+			v.promptKey(key)
+		case v.copying && v.copyKey(key):
+		default:
+			v.key(key)
+		}
+	})
+	if v.open {
+		v.draw()
+	}
+	return took
+}
+
+// key handles a key while paging. The caller holds mu.
+func (v *scrollView) key(key string) {
+	v.message = ""
+	page := v.page()
+	switch key {
+	case "q", "esc", "ctrl-c":
+		v.close()
+	case "up", "k", "ctrl-p", "ctrl-y":
+		v.scroll(-1)
+	case "down", "j", "ctrl-n", "ctrl-e", "enter":
+		v.scroll(1)
+	case "pageup", "b", "ctrl-b":
+		v.scroll(-page)
+	case "pagedown", " ", "f", "ctrl-f":
+		v.scroll(page)
+	case "ctrl-u":
+		v.scroll(-page / 2)
+	case "ctrl-d":
+		v.scroll(page / 2)
+	case "home", "g":
+		v.top = 0
+	case "end", "G":
+		v.top = len(v.lines)
+		v.scroll(0)
+	case "/", "?":
+		v.prompt, v.typed = key, nil
+	case "wheelup":
+		v.scroll(-wheelLines)
+	case "wheeldown":
+		v.scroll(wheelLines)
+		if v.scrolled && v.top >= len(v.lines)-v.page() {
+			v.close() // back at the bottom
+			return
+		}
+	case "n", "N":
+		if v.re == nil {
+			v.message = "no pattern yet"
+			return
+		}
+		v.search(v.backward == (key == "n"))
+	}
+}
+
+// promptKey handles a key while a pattern is typed. The caller holds mu.
+func (v *scrollView) promptKey(key string) {
+	switch key {
+	case "esc", "ctrl-c":
+		v.prompt = ""
+	case "backspace":
+		if len(v.typed) == 0 {
+			v.prompt = ""
+		} else {
+			v.typed = v.typed[:len(v.typed)-1]
+		}
+	case "ctrl-u":
+		v.typed = nil
+	case "enter":
+		if len(v.typed) > 0 {
+			v.re = patternRegexp(string(v.typed))
+		}
+		v.backward = v.prompt == "?"
+		v.prompt = ""
+		if v.re != nil {
+			v.search(v.backward)
+		}
+	default:
+		if r := []rune(key); len(r) == 1 && unicode.IsPrint(r[0]) {
+			v.typed = append(v.typed, r[0])
+		}
+	}
+}
+
+// patternRegexp matches text literally, ignoring case unless it has
+// capitals.
+func patternRegexp(text string) *regexp.Regexp {
+	expr := regexp.QuoteMeta(text)
+	if strings.ToLower(text) == text {
+		expr = "(?i)" + expr
+	}
+	return regexp.MustCompile(expr)
+}
+
+// search moves to the next line matching v.re, up if backward, taking the
+// cursor to the match in copy mode. The caller holds mu.
+func (v *scrollView) search(backward bool) {
+	step, from := 1, v.top-1
+	if backward {
+		step, from = -1, v.top+v.page()
+	}
+	switch {
+	case v.copying:
+		from = v.cy
+	case v.match >= v.top && v.match < v.top+v.page():
+		from = v.match // carry on from the match on screen
+	}
+	for i := from + step; i >= 0 && i < len(v.lines); i += step {
+		if loc := v.re.FindStringIndex(v.lines[i]); loc != nil {
+			v.match = i
+			if v.copying {
+				v.cy, v.cx = i, utf8.RuneCountInString(v.lines[i][:loc[0]])
+			}
+			if i < v.top || i >= v.top+v.page() {
+				v.top = i - v.page()/2
+				v.scroll(0)
+			}
+			return
+		}
+	}
+	v.message = "pattern not found: " + v.re.String()
+}
+
+// scroll moves the view n lines down, or up if n is negative, keeping it
+// within the scrollback. The caller holds mu.
+func (v *scrollView) scroll(n int) {
+	v.top = min(max(v.top+n, 0), max(len(v.lines)-v.page(), 0))
+}
+
+// draw paints the view. The caller holds mu.
+func (v *scrollView) draw() {
+	var b bytes.Buffer
+	for i := range v.page() {
+		fmt.Fprintf(&b, "\x1b[%d;1H\x1b[0m\x1b[2K", i+1)
+		if n := v.top + i; n < len(v.lines) {
+			v.drawLine(&b, n)
+		}
+	}
+	fmt.Fprintf(&b, "\x1b[%d;1H\x1b[0m\x1b[2K", v.rows)
+	if v.prompt != "" {
+		text := []rune(v.prompt + string(v.typed))
+		b.WriteString(string(text[max(len(text)-v.cols+1, 0):])) // keep the end in view
+	} else {
+		text := v.message
+		last := min(v.top+v.page(), len(v.lines))
+		switch {
+		case text != "":
+		case v.scrolled:
+			text = fmt.Sprintf("scrolled, lines %d-%d of %d | wheel down to the end or q to go back", min(v.top+1, last), last, len(v.lines))
+		case v.copying:
+			text = fmt.Sprintf("copy mode, line %d of %d | v V select, y copy, / ? search, q quit", v.cy+1, len(v.lines))
+		default:
+			text = fmt.Sprintf("scrollback %d-%d of %d | / ? search, n N next, q quit", min(v.top+1, last), last, len(v.lines))
+		}
+		b.WriteString("\x1b[7m " + fitWidth(text, v.cols-2) + " \x1b[0m")
+		if v.copying {
+			fmt.Fprintf(&b, "\x1b[%d;%dH", v.cy-v.top+1, v.column(v.cy, v.cx)+1)
+		}
+	}
+	_, _ = v.w.Write(b.Bytes())
+}
+
+// drawLine writes line n cut to the terminal's width, with matches of the
+// last pattern and the selection in reverse video.
+func (v *scrollView) drawLine(b *bytes.Buffer, n int) {
+	line := v.lines[n]
+	var matches [][]int
+	if v.re != nil {
+		matches = v.re.FindAllStringIndex(line, -1)
+	}
+	from, to := v.selected(n)
+	width, char, reverse := 0, 0, false
+	for i, r := range line {
+		w := 1
+		if vt.IsWide(r) {
+			w = 2
+		}
+		if width+w > v.cols {
+			break
+		}
+		width += w
+		on := char >= from && char < to
+		for _, m := range matches {
+			on = on || i >= m[0] && i < m[1]
+		}
+		if on && !reverse {
+			b.WriteString("\x1b[7m")
+		} else if !on && reverse {
+			b.WriteString("\x1b[27m")
+		}
+		reverse = on
+		b.WriteRune(r)
+		char++
+	}
+	if from < to && to > char && !reverse {
+		b.WriteString("\x1b[7m \x1b[27m") // a selected line break
+	}
+	b.WriteString("\x1b[0m")
+}
+
+// column returns the screen column of character x of line y.
+func (v *scrollView) column(y, x int) int {
+	if y < 0 || y >= len(v.lines) {
+		return 0
+	}
+	col := 0
+	for i, r := range []rune(v.lines[y]) {
+		if i == x {
+			break
+		}
+		col++
+		if vt.IsWide(r) {
+			col++
+		}
+	}
+	return min(col, v.cols-1)
+}
+
+// fitWidth cuts text to n characters.
+func fitWidth(text string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if utf8.RuneCountInString(text) <= n {
+		return text
+	}
+	return string([]rune(text)[:n])
+}
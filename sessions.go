@@ -0,0 +1,311 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/samuelstevens/claude-unfocused/internal/vt"
+	"github.com/samuelstevens/claude-unfocused/pkg/ptyproxy"
+)
+
+// drainTimeout is how long, once a child exits, its last output is waited
+// for.
+const drainTimeout = 100 * time.Millisecond
+
+// sessions is a ptyproxy.Session running several children side by side, of
+// which the terminal shows one at a time. Every child's output also feeds a
+// screen model of its own, so switching to a child repaints what it last
+// showed before asking it to redraw itself properly. Input and resizes go
+// to the active child (resizes to all); the session ends once every child
+// has exited.
+type sessions struct {
+	start   func(dir string) (ptyproxy.Session, error)
+	bufSize int        // for reading each child's output
+	out     chan chunk // the active child's output, and redraws
+	done    chan struct{}
+
+	mu         sync.Mutex
+	list       []*managed
+	active     int
+	live       int
+	cols, rows int
+	code       int
+	err        error
+	gen        int    // counts switches, for telling stale output apart
+	pending    []byte // output Read has yet to return
+}
+
+// chunk is output queued for Read, sent while the sessions' gen was gen.
+type chunk struct {
+	gen int
+	b   []byte
+}
+
+// managed is one child of a sessions.
+type managed struct {
+	ptyproxy.Session
+	dir     string
+	screen  *vt.Screen
+	exited  bool
+	drained chan struct{} // closed once its output has all been read
+}
+
+func newSessions(first ptyproxy.Session, dir string, bufSize int, start func(dir string) (ptyproxy.Session, error)) *sessions {
+	s := &sessions{start: start, bufSize: bufSize, out: make(chan chunk, 16), done: make(chan struct{})}
+	s.cols, s.rows, _ = ptyproxy.TermSize()
+	s.add(first, dir)
+	return s
+}
+
+// open starts a new child in dir and switches to it.
+func (s *sessions) open(dir string) error {
+	child, err := s.start(dir)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if s.cols > 0 && s.rows > 0 {
+		_ = child.Resize(s.cols, s.rows)
+	}
+	s.mu.Unlock()
+	i := s.add(child, dir)
+	s.show(i)
+	return nil
+}
+
+// add takes on child, returning its index.
+func (s *sessions) add(child ptyproxy.Session, dir string) int {
+	s.mu.Lock()
+	cols, rows := s.cols, s.rows
+	if cols == 0 || rows == 0 {
+		cols, rows = 80, 24
+	}
+	m := &managed{Session: child, dir: dir, screen: vt.New(cols, rows), drained: make(chan struct{})}
+	s.list = append(s.list, m)
+	s.live++
+	i := len(s.list) - 1
+	s.mu.Unlock()
+	go s.pump(m)
+	go s.wait(m)
+	return i
+}
+
+// pump reads a child's output into its screen, and to the terminal while
+// it is active.
+func (s *sessions) pump(m *managed) {
+	defer close(m.drained)
+	buf := make([]byte, s.bufSize)
+	for {
+		n, err := m.Read(buf)
+		if n > 0 {
+			// Sent unlocked, so a terminal slow to take it holds up no
+			// other child, nor a switch
+			s.mu.Lock()
+			_, _ = m.screen.Write(buf[:n])
+			var c chunk
+			active := s.list[s.active] == m
+			if active {
+				c = chunk{s.gen, append([]byte(nil), buf[:n]...)}
+			}
+			s.mu.Unlock()
+			if active {
+				s.send(c)
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// send queues output for Read, unless the session is over and nothing
+// will read it.
+func (s *sessions) send(c chunk) {
+	select {
+	case s.out <- c:
+	case <-s.done:
+	}
+}
+
+// wait waits for a child to exit, switching away from it if it was active.
+func (s *sessions) wait(m *managed) {
+	code, err := m.Wait()
+	// What it wrote last may not have been read yet, unless something it
+	// left behind holds its PTY open
+	select {
+	case <-m.drained:
+	case <-time.After(drainTimeout):
+	}
+	s.mu.Lock()
+	m.exited = true
+	s.live--
+	s.code, s.err = code, err
+	if s.live == 0 {
+		close(s.done)
+		s.mu.Unlock()
+		return
+	}
+	active := s.list[s.active] == m
+	s.mu.Unlock()
+	if active {
+		s.cycle(1)
+	}
+}
+
+// cycle switches delta children onward, skipping those that have exited.
+func (s *sessions) cycle(delta int) {
+	s.mu.Lock()
+	next := s.active
+	for range s.list {
+		next = (next + delta + len(s.list)) % len(s.list)
+		if !s.list[next].exited {
+			break
+		}
+	}
+	s.mu.Unlock()
+	s.show(next)
+}
+
+// show makes child i active and repaints the terminal with its screen.
+func (s *sessions) show(i int) {
+	s.mu.Lock()
+	m := s.list[i]
+	if m.exited {
+		s.mu.Unlock()
+		return
+	}
+	s.active = i
+	s.gen++
+	redraw := m.screen.Redraw()
+	redraw = append(redraw, noticeBytes(s.describe(i))...)
+	c := chunk{s.gen, redraw}
+	s.mu.Unlock()
+	s.send(c)
+	ptyproxy.Refresh(m.Session)
+}
+
+// describe names child i for the switch notice.
+func (s *sessions) describe(i int) string {
+	dir := s.list[i].dir
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+	return fmt.Sprintf("session %d/%d: %s", i+1, len(s.list), filepath.Base(dir))
+}
+
+func (s *sessions) current() ptyproxy.Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.list[s.active]
+}
+
+// children returns the children still running.
+func (s *sessions) children() []ptyproxy.Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var live []ptyproxy.Session
+	for _, m := range s.list {
+		if !m.exited {
+			live = append(live, m)
+		}
+	}
+	return live
+}
+
+func (s *sessions) Read(b []byte) (int, error) {
+	for len(s.pending) == 0 {
+		var c chunk
+		select {
+		case c = <-s.out:
+		case <-s.done:
+			select {
+			case c = <-s.out:
+			default:
+				return 0, io.EOF
+			}
+		}
+		// Output a child sent before a switch away from it is dropped;
+		// its screen has it for when it is shown again
+		s.mu.Lock()
+		if c.gen == s.gen {
+			s.pending = c.b
+		}
+		s.mu.Unlock()
+	}
+	n := copy(b, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+func (s *sessions) Write(b []byte) (int, error) {
+	return s.current().Write(b)
+}
+
+func (s *sessions) Resize(cols, rows int) error {
+	s.mu.Lock()
+	list := s.list
+	if cols > 0 && rows > 0 {
+		s.cols, s.rows = cols, rows
+	}
+	s.mu.Unlock()
+	for _, m := range list {
+		if cols > 0 && rows > 0 {
+			m.screen.Resize(cols, rows)
+		}
+		if !m.exited {
+			_ = m.Resize(cols, rows)
+		}
+	}
+	return nil
+}
+
+func (s *sessions) Pid() int {
+	return s.current().Pid()
+}
+
+// Signal passes an interrupt to the active child and anything else, such
+// as a hangup or SIGTERM, to them all.
+func (s *sessions) Signal(sig os.Signal) error {
+	if sig == os.Interrupt {
+		return s.current().Signal(sig)
+	}
+	var err error
+	for _, child := range s.children() {
+		if e := child.Signal(sig); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (s *sessions) Kill() error {
+	var err error
+	for _, child := range s.children() {
+		if e := child.Kill(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Wait waits for every child to exit and returns the exit code of the last.
+func (s *sessions) Wait() (int, error) {
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.code, s.err
+}
+
+func (s *sessions) Close() error {
+	s.mu.Lock()
+	list := s.list
+	s.mu.Unlock()
+	for _, m := range list {
+		_ = m.Close()
+	}
+	return nil
+}
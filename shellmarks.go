@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"os"
+
+	"github.com/samuelstevens/claude-unfocused/internal/ansiparse"
+)
+
+// shellIntegrationModes are the accepted values of filter.shell_integration,
+// which decides what happens to the shell integration sequences, OSC 133
+// prompt marks and OSC 7 working directories, that shells claude starts
+// write to the terminal:
+//
+//	pass     pass them to the terminal
+//	strip    drop them
+//	rewrite  drop the prompt marks and report the wrapper's own directory
+//	         in place of the shells'
+var shellIntegrationModes = map[string]bool{"pass": true, "strip": true, "rewrite": true}
+
+// shellIntegrationRule returns the output rule applying mode to the child's
+// shell integration sequences, or nil if they pass. The wrapper's own
+// --prompt-marks are written behind the output filter, untouched.
+func shellIntegrationRule(mode string) outputRule {
+	if mode == "pass" {
+		return nil
+	}
+	var cwd []byte
+	if mode == "rewrite" {
+		if wd, err := os.Getwd(); err == nil {
+			host, _ := os.Hostname()
+			u := url.URL{Scheme: "file", Host: host, Path: wd}
+			cwd = []byte("\x1b]7;" + u.String() + "\x07")
+		}
+	}
+	return func(seq ansiparse.Sequence) ([]byte, bool) {
+		if seq.Kind != ansiparse.OSC {
+			return nil, false
+		}
+		num, _, _ := bytes.Cut(seq.Data, []byte(";"))
+		switch string(num) {
+		case "133":
+			return nil, true
+		case "7":
+			return cwd, true
+		}
+		return nil, false
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// stallActions are the accepted values of stall.action, what the watchdog
+// does about a child that has stalled:
+//
+//	notify   send a desktop notification
+//	log      log a warning
+//	kill     kill the child
+//	restart  kill the child and start it again (implies --restart-on-crash)
+var stallActions = map[string]bool{"notify": true, "log": true, "kill": true, "restart": true}
+
+// watchdog acts when the child has produced no output and been sent no
+// input for a while, which catches it hanging on a dead network connection.
+// It acts once per stall. A nil *watchdog does nothing.
+type watchdog struct {
+	mu      sync.Mutex
+	after   time.Duration
+	action  string
+	name    string
+	kill    func() error
+	restart func() error
+	timer   *time.Timer
+	fired   bool
+}
+
+func newWatchdog(after time.Duration, action string, argv []string, kill, restart func() error) *watchdog {
+	w := &watchdog{after: after, action: action, name: commandName(argv), kill: kill, restart: restart}
+	w.timer = time.AfterFunc(after, w.fire)
+	return w
+}
+
+// Write notes child output, so a watchdog can sit in an io.MultiWriter.
+func (w *watchdog) Write(b []byte) (int, error) {
+	w.activity()
+	return len(b), nil
+}
+
+// input notes bytes written to the child.
+func (w *watchdog) input(b []byte) {
+	w.activity()
+}
+
+func (w *watchdog) activity() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.fired = false
+	w.timer.Reset(w.after)
+}
+
+func (w *watchdog) fire() {
+	w.mu.Lock()
+	if w.fired {
+		w.mu.Unlock()
+		return
+	}
+	w.fired = true
+	w.mu.Unlock()
+
+	msg := fmt.Sprintf("%s has been silent for %v", w.name, w.after)
+	var err error
+	switch w.action {
+	case "notify":
+		notice(msg)
+		err = notify("claude-unfocused", msg)
+	case "log":
+		log.Printf("warning: %s", msg)
+	case "kill":
+		notice(msg + ", killing it")
+		err = w.kill()
+	case "restart":
+		notice(msg + ", restarting it")
+		err = w.restart()
+	}
+	if err != nil {
+		notice(fmt.Sprintf("stall %s failed: %v", w.action, err))
+	}
+}
+
+// Close stops the watchdog.
+func (w *watchdog) Close() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.timer.Stop()
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/samuelstevens/claude-unfocused/internal/ansiparse"
+	"github.com/samuelstevens/claude-unfocused/pkg/escfilter"
+	"github.com/samuelstevens/claude-unfocused/pkg/ptyproxy"
+)
+
+// statusLine keeps a one-line bar on the terminal's bottom row, showing
+// the session, how long it has run, the focus filter's state, the child's
+// PID and, when tracked, its usage. While it is shown the child is given one row less and a
+// scroll region (DECSTBM) above the bar, which the rule keeps in place when
+// the child sets its own; the bar is repainted after each write of output,
+// in case the child cleared it, and every second. A message flashed on it
+// is shown in place of the rest for flashTime.
+type statusLine struct {
+	w       io.Writer // the terminal
+	name    string
+	filter  *escfilter.Filter
+	started time.Time
+	usage   *usageTracker // shown if set
+
+	mu         sync.Mutex
+	session    ptyproxy.Session
+	on         bool
+	cols, rows int
+	msg        string
+	msgUntil   time.Time
+	stop       chan struct{}
+}
+
+// flashTime is how long a message flashed on the bar stays.
+const flashTime = 5 * time.Second
+
+// statusName names the session on the status line: its background session
+// name, or else the working directory.
+func statusName(session string) string {
+	if session != "" {
+		return session
+	}
+	if wd, err := os.Getwd(); err == nil {
+		return filepath.Base(wd)
+	}
+	return "claude"
+}
+
+func newStatusLine(w io.Writer, name string, filter *escfilter.Filter, usage *usageTracker) *statusLine {
+	s := &statusLine{w: w, name: name, filter: filter, started: time.Now(), usage: usage, stop: make(chan struct{})}
+	s.cols, s.rows, _ = ptyproxy.TermSize()
+	go func() {
+		tick := time.NewTicker(time.Second)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				s.mu.Lock()
+				s.draw()
+				s.mu.Unlock()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// wrap returns session as the child should see it, a row shorter while the
+// bar is shown.
+func (s *statusLine) wrap(session ptyproxy.Session) ptyproxy.Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.session = session
+	return statusSession{session, s}
+}
+
+// statusSession resizes the child around the status line.
+type statusSession struct {
+	ptyproxy.Session
+	s *statusLine
+}
+
+func (ss statusSession) Resize(cols, rows int) error {
+	ss.s.mu.Lock()
+	defer ss.s.mu.Unlock()
+	ss.s.cols, ss.s.rows = cols, rows
+	if ss.s.fits() {
+		_, _ = io.WriteString(ss.s.w, ss.s.region())
+		rows--
+	}
+	return ss.Session.Resize(cols, rows)
+}
+
+// fits reports whether the bar is shown, which needs a terminal of at
+// least two rows.
+func (s *statusLine) fits() bool {
+	return s.on && s.rows > 1
+}
+
+// heights returns the terminal's rows and the child's, one less while the
+// bar is shown. A nil *statusLine returns zeros.
+func (s *statusLine) heights() (term, child int) {
+	if s == nil {
+		return 0, 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fits() {
+		return s.rows, s.rows - 1
+	}
+	return s.rows, s.rows
+}
+
+// toggle shows or hides the bar.
+func (s *statusLine) toggle() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.on = !s.on
+	if s.rows <= 1 {
+		return
+	}
+	rows := s.rows
+	if s.on {
+		// Scroll the screen if the cursor is on the bar's row, then keep
+		// the child out of it
+		_, _ = io.WriteString(s.w, "\x1bD\x1bM"+s.region())
+		s.draw()
+		rows--
+	} else {
+		_, _ = fmt.Fprintf(s.w, "\x1b7\x1b[r\x1b8\x1b7\x1b[%d;1H\x1b[0m\x1b[2K\x1b8", s.rows)
+	}
+	if s.session != nil {
+		_ = s.session.Resize(s.cols, rows)
+	}
+}
+
+// flash shows msg on the bar for flashTime, reporting whether the bar is
+// shown to show it. A nil *statusLine reports false.
+func (s *statusLine) flash(msg string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.fits() {
+		return false
+	}
+	s.msg, s.msgUntil = msg, time.Now().Add(flashTime)
+	s.draw()
+	return true
+}
+
+// region returns the sequence confining scrolling to the rows above the
+// bar, leaving the cursor where it was.
+func (s *statusLine) region() string {
+	return fmt.Sprintf("\x1b7\x1b[1;%dr\x1b8", s.rows-1)
+}
+
+// Write passes output on to the terminal and repaints the bar over it.
+func (s *statusLine) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.w.Write(b); err != nil {
+		return 0, err
+	}
+	s.draw()
+	return len(b), nil
+}
+
+// draw paints the bar. The caller holds mu.
+func (s *statusLine) draw() {
+	if !s.fits() {
+		return
+	}
+	elapsed := time.Since(s.started).Truncate(time.Second)
+	state := "input filter off" // no filter, with --no-filter
+	if s.filter != nil {
+		state = "focus filter " + onOff(s.filter.Focus())
+	}
+	text := fmt.Sprintf(" %s | %s | %s", s.name, elapsed, state)
+	if s.session != nil {
+		text += fmt.Sprintf(" | pid %d", s.session.Pid())
+	}
+	if s.usage != nil {
+		text += " | " + s.usage.short()
+	}
+	if time.Now().Before(s.msgUntil) {
+		text = " " + s.msg
+	}
+	if n := utf8.RuneCountInString(text); n < s.cols {
+		text += strings.Repeat(" ", s.cols-n)
+	} else {
+		text = string([]rune(text)[:s.cols])
+	}
+	_, _ = fmt.Fprintf(s.w, "\x1b7\x1b[%d;1H\x1b[0;7m%s\x1b[0m\x1b8", s.rows, text)
+}
+
+// rule keeps the scroll region above the bar when the child sets its own
+// or resets the terminal.
+func (s *statusLine) rule() outputRule {
+	return func(seq ansiparse.Sequence) ([]byte, bool) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if !s.fits() {
+			return nil, false
+		}
+		switch {
+		case seq.Kind == ansiparse.CSI && seq.Final == 'r' && seq.Private() == 0 && len(seq.Intermediates) == 0:
+			top, bottom := 1, s.rows-1
+			ints := seq.Ints()
+			if len(ints) > 0 && ints[0] > 0 {
+				top = ints[0]
+			}
+			if len(ints) > 1 && ints[1] > 0 {
+				bottom = min(ints[1], s.rows-1)
+			}
+			return fmt.Appendf(nil, "\x1b[%d;%dr", top, bottom), true
+		case seq.Kind == ansiparse.Esc && len(seq.Intermediates) == 0 && seq.Final == 'c':
+			return append(append([]byte(nil), seq.Raw...), s.region()...), true
+		}
+		return nil, false
+	}
+}
+
+// Close hides the bar and restores the full scroll region.
+func (s *statusLine) Close() {
+	close(s.stop)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fits() {
+		_, _ = fmt.Fprintf(s.w, "\x1b7\x1b[r\x1b8\x1b7\x1b[%d;1H\x1b[0m\x1b[2K\x1b8", s.rows)
+	}
+	s.on = false
+}
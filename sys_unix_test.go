@@ -0,0 +1,49 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckPrivateDir(t *testing.T) {
+	base := t.TempDir()
+	mkdir := func(name string, mode os.FileMode) string {
+		dir := filepath.Join(base, name)
+		if err := os.Mkdir(dir, mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(dir, mode); err != nil { // past the umask
+			t.Fatal(err)
+		}
+		return dir
+	}
+	private := mkdir("private", 0o700)
+	link := filepath.Join(base, "link")
+	if err := os.Symlink(private, link); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(base, "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		dir string
+		ok  bool
+	}{
+		{private, true},
+		{mkdir("shared", 0o755), false},
+		{mkdir("group", 0o770), false},
+		{link, false},
+		{file, false},
+		{filepath.Join(base, "missing"), false},
+	}
+	for _, tt := range tests {
+		if err := checkPrivateDir(tt.dir); (err == nil) != tt.ok {
+			t.Errorf("checkPrivateDir(%s) = %v, want ok %v", filepath.Base(tt.dir), err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// signalNames maps the signal names accepted by the control socket and the
+// [signals] settings. Only KILL can actually be delivered on Windows.
+var signalNames = map[string]os.Signal{
+	"INT":  os.Interrupt,
+	"KILL": os.Kill,
+	"QUIT": syscall.SIGQUIT,
+	"TERM": syscall.SIGTERM,
+}
+
+// forwardedSignals are the signals passed on to the child by default,
+// though Windows can't deliver them.
+var forwardedSignals = []string{"INT", "TERM", "QUIT"}
+
+// detachProcess makes cmd outlive the console that started it.
+func detachProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: windows.DETACHED_PROCESS | windows.CREATE_NEW_PROCESS_GROUP}
+}
+
+// checkPrivateDir makes sure dir is a directory and not a link to one.
+// Its permissions are left to the ACLs it inherits.
+func checkPrivateDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// teeFormats are the accepted values of --tee-format: the output as it
+// is, or as plain text like --transcript's.
+var teeFormats = map[string]bool{"raw": true, "plain": true}
+
+// teeBacklog is how many writes a tee queues for a slow reader before it
+// drops output.
+const teeBacklog = 256
+
+// tee copies the child's output, live, to a file or named pipe, for another
+// process to follow. Writing never holds the session up: output is queued
+// for a goroutine, and dropped when the queue is full. A named pipe gets
+// nothing until a reader opens it, and is opened again for the next reader
+// when one goes away.
+type tee struct {
+	path      string
+	fifo      bool
+	queue     chan []byte
+	connected atomic.Bool
+	done      chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func newTee(path string) (*tee, error) {
+	t := &tee{path: path, queue: make(chan []byte, teeBacklog), done: make(chan struct{})}
+	info, err := os.Stat(path)
+	t.fifo = err == nil && info.Mode()&os.ModeNamedPipe != 0
+	var f *os.File
+	if !t.fifo {
+		// Report what keeps a file from being written up front
+		if f, err = t.open(); err != nil {
+			return nil, err
+		}
+	}
+	go t.run(f)
+	return t, nil
+}
+
+// open opens the file, which for a named pipe waits for a reader.
+func (t *tee) open() (*os.File, error) {
+	return os.OpenFile(t.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+}
+
+// run writes the queue to f, opening the named pipe as readers come.
+func (t *tee) run(f *os.File) {
+	defer close(t.done)
+	for {
+		if f == nil {
+			var err error
+			if f, err = t.open(); err != nil {
+				return
+			}
+		}
+		t.connected.Store(true)
+		more := t.drain(f)
+		t.connected.Store(false)
+		_ = f.Close()
+		f = nil
+		if !more || !t.fifo {
+			return
+		}
+	}
+}
+
+// drain writes the queue to f until it is closed, or until a write fails,
+// as when the reader has gone, reporting which.
+func (t *tee) drain(f *os.File) (failed bool) {
+	for b := range t.queue {
+		if _, err := f.Write(b); err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Write queues child output, so a tee can sit in an io.MultiWriter.
+func (t *tee) Write(b []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.closed && t.connected.Load() {
+		select {
+		case t.queue <- bytes.Clone(b):
+		default: // the reader is behind
+		}
+	}
+	return len(b), nil
+}
+
+// Close writes what is queued and closes the file, giving up after a second
+// on a reader that doesn't keep up.
+func (t *tee) Close() error {
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+	t.mu.Unlock()
+	select {
+	case <-t.done:
+	case <-time.After(time.Second):
+	}
+	return nil
+}
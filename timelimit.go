@@ -0,0 +1,41 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// sessionTimeWarning is how long before the session time limit the wrapper
+// warns that it is coming.
+const sessionTimeWarning = 5 * time.Minute
+
+// timeLimit ends the session once it has run for a while: it logs a warning
+// ahead of time, then calls end, which asks the child to exit as the quit
+// key does. A nil *timeLimit does nothing.
+type timeLimit struct {
+	warn, end *time.Timer
+}
+
+// newTimeLimit starts the clock on a session of argv limited to limit,
+// warning warning ahead of it unless that is zero or the whole limit.
+func newTimeLimit(limit, warning time.Duration, argv []string, end func()) *timeLimit {
+	t := &timeLimit{end: time.AfterFunc(limit, end)}
+	if warning > 0 && warning < limit {
+		name := commandName(argv)
+		t.warn = time.AfterFunc(limit-warning, func() {
+			log.Printf("warning: %s will be stopped in %v, at the session time limit of %v", name, warning, limit)
+		})
+	}
+	return t
+}
+
+// Close stops the clock.
+func (t *timeLimit) Close() {
+	if t == nil {
+		return
+	}
+	if t.warn != nil {
+		t.warn.Stop()
+	}
+	t.end.Stop()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/samuelstevens/claude-unfocused/internal/ansiparse"
+)
+
+// titleModes are the accepted values of title.mode, which decides what
+// happens to the window and icon titles the child sets (OSC 0, 1 and 2):
+//
+//	pass     pass them to the terminal
+//	block    drop them
+//	rewrite  replace them with title.template
+var titleModes = map[string]bool{"pass": true, "block": true, "rewrite": true}
+
+// XTWINOPS sequences saving and restoring the terminal's title, so the
+// wrapper can put back whatever was there before.
+const (
+	pushTitle = "\x1b[22;0t"
+	popTitle  = "\x1b[23;0t"
+)
+
+// titleKeeper maintains the window title from a template while the wrapper
+// runs. The template may use {dir} for the working directory's name,
+// {session} for the background session's name and {status} for the title
+// the child last set, which claude uses to show what it is doing.
+type titleKeeper struct {
+	template string
+	dir      string
+	session  string
+}
+
+func newTitleKeeper(template, session string) *titleKeeper {
+	dir := ""
+	if wd, err := os.Getwd(); err == nil {
+		dir = filepath.Base(wd)
+	}
+	return &titleKeeper{template: template, dir: dir, session: session}
+}
+
+// set returns the sequence setting the title from the template.
+func (t *titleKeeper) set(status string) []byte {
+	title := strings.NewReplacer("{dir}", t.dir, "{session}", t.session, "{status}", status).Replace(t.template)
+	title = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1 // would end or break the sequence
+		}
+		return r
+	}, title)
+	// Empty placeholders should not leave dangling separators
+	title = strings.Trim(title, " -:|")
+	return []byte("\x1b]2;" + title + "\x07")
+}
+
+// start saves the terminal's title and sets the initial one.
+func (t *titleKeeper) start() []byte {
+	return append([]byte(pushTitle), t.set("")...)
+}
+
+// rule returns the output rule applying mode to the child's titles.
+func (t *titleKeeper) rule(mode string) outputRule {
+	if mode == "pass" {
+		return nil
+	}
+	return func(seq ansiparse.Sequence) ([]byte, bool) {
+		n, title, ok := titleSeq(seq)
+		if !ok {
+			return nil, false
+		}
+		if mode == "block" || n == "1" {
+			return nil, true
+		}
+		return t.set(string(title)), true
+	}
+}
+
+// titleSeq splits an OSC 0, 1 or 2 title sequence into its number and
+// title.
+func titleSeq(seq ansiparse.Sequence) (n string, title []byte, ok bool) {
+	if seq.Kind != ansiparse.OSC {
+		return "", nil, false
+	}
+	num, title, ok := bytes.Cut(seq.Data, []byte(";"))
+	if !ok || len(num) != 1 || num[0] < '0' || num[0] > '2' {
+		return "", nil, false
+	}
+	return string(num), title, true
+}
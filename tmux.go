@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// runTmux runs the wrapper in a tmux session named name instead of under
+// its own session server, creating the session unless it is already
+// running, and attaches the terminal to it. Inside the session the wrapper
+// sits between tmux and the child as usual, so the focus events tmux sends
+// are still filtered. It returns tmux's exit code.
+func runTmux(name string, args []string) int {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		fmt.Fprintln(os.Stderr, "claude-unfocused: --tmux needs tmux on PATH")
+		return 1
+	}
+	if name == "" {
+		name = "claude"
+		if wd, err := os.Getwd(); err == nil && filepath.Base(wd) != string(filepath.Separator) {
+			name = filepath.Base(wd)
+		}
+	}
+	// tmux reserves these for targets
+	name = strings.NewReplacer(".", "_", ":", "_").Replace(name)
+	target := "=" + name
+
+	if exec.Command("tmux", "has-session", "-t", target).Run() != nil {
+		exe, err := os.Executable()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "claude-unfocused: %v\n", err)
+			return 1
+		}
+		create := exec.Command("tmux", slices.Concat([]string{"new-session", "-d", "-s", name, "--", exe}, args)...)
+		create.Stderr = os.Stderr
+		if err := create.Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "claude-unfocused: failed to start tmux session %q: %v\n", name, err)
+			return 1
+		}
+	}
+
+	attach := exec.Command("tmux", "attach-session", "-t", target)
+	if os.Getenv("TMUX") != "" {
+		attach = exec.Command("tmux", "switch-client", "-t", target)
+	}
+	attach.Stdin, attach.Stdout, attach.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := attach.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitCode()
+		}
+		fmt.Fprintf(os.Stderr, "claude-unfocused: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// withoutFlag returns args with the wrapper's boolean flag name removed,
+// leaving anything after "--" alone.
+func withoutFlag(args []string, name string) []string {
+	end := len(args)
+	if i := slices.Index(args, "--"); i >= 0 {
+		end = i
+	}
+	own := slices.DeleteFunc(slices.Clone(args[:end]), func(arg string) bool {
+		return arg == "--"+name || strings.HasPrefix(arg, "--"+name+"=")
+	})
+	return append(own, args[end:]...)
+}
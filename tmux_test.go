@@ -0,0 +1,57 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestTmuxFlagEnv checks that a wrapper run with --tmux from the
+// environment doesn't pass the variable on to the wrapper it starts inside
+// tmux, which would run --tmux again, but does pass on the other flags set
+// that way.
+func TestTmuxFlagEnv(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping the built wrapper in short mode")
+	}
+	home := t.TempDir()
+	bin := filepath.Join(home, "bin")
+	log := filepath.Join(home, "tmux.log")
+	// A tmux with no sessions that logs how it is run
+	script := "#!/bin/sh\n" +
+		`if [ "$1" = new-session ]; then echo "args: $*" >>"$TMUX_LOG"; env | grep ^CLAUDE_UNFOCUSED_ >>"$TMUX_LOG"; fi` + "\n" +
+		`[ "$1" != has-session ]` + "\n"
+	if err := os.MkdirAll(bin, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(bin, "tmux"), []byte(script), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := wrapperCommand(t, home, "--no-archive", "--", "true")
+	cmd.Env = append(cmd.Env, "PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"), "TMUX_LOG="+log,
+		flagEnv("tmux")+"=1", flagEnv("esc-timeout")+"=20ms")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	got, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(got)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("tmux was started with the environment %q, want no %s variables", lines[1:], flagEnvPrefix)
+	}
+	args := strings.Fields(lines[0])
+	for _, want := range []string{"--esc-timeout=20ms", "--no-archive", "--", "true"} {
+		if !strings.Contains(lines[0], " "+want) {
+			t.Errorf("tmux ran %q, want %s among its arguments", args, want)
+		}
+	}
+	if strings.Contains(lines[0], "--tmux") {
+		t.Errorf("tmux ran %q, with --tmux", args)
+	}
+}
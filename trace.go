@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/samuelstevens/claude-unfocused/internal/ansiparse"
+	"github.com/samuelstevens/claude-unfocused/pkg/escfilter"
+)
+
+// tracer logs escape sequences crossing the wrapper, one line each with a
+// timestamp, direction ("in" from the terminal, "out" from the child), what
+// the wrapper did with it, its kind and its quoted bytes. Plain text is not
+// logged. A nil *tracer discards everything.
+type tracer struct {
+	mu     sync.Mutex
+	f      io.WriteCloser
+	parser ansiparse.Parser // for the output stream
+}
+
+// newTracer creates the trace at path, with secrets masked by redact if
+// it is set.
+func newTracer(path string, redact *redactor) (*tracer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &tracer{f: redactTo(f, redact)}, nil
+}
+
+// seq logs seq unless it is plain text.
+func (t *tracer) seq(dir, action string, seq ansiparse.Sequence) {
+	if t == nil || seq.Kind == ansiparse.Text {
+		return
+	}
+	t.raw(dir, action, seq.Kind.String(), seq.Raw)
+}
+
+// raw logs bytes that are not a single parsed sequence, described by what.
+func (t *tracer) raw(dir, action, what string, b []byte) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.f == nil {
+		return
+	}
+	_, _ = fmt.Fprintf(t.f, "%s %-3s %-8s %-8s %q\n", time.Now().Format("15:04:05.000000"), dir, action, what, b)
+}
+
+// filterEvent logs an input filter decision.
+func (t *tracer) filterEvent(e escfilter.Event) {
+	t.raw("in", e.Action, e.Kind, e.Raw)
+}
+
+// Write logs the sequences in child output, so a tracer can sit in an
+// io.MultiWriter on the output path.
+func (t *tracer) Write(b []byte) (int, error) {
+	t.parser.Feed(b, func(seq ansiparse.Sequence) {
+		t.seq("out", "pass", seq)
+	})
+	return len(b), nil
+}
+
+func (t *tracer) Close() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	err := t.f.Close()
+	t.f = nil
+	return err
+}
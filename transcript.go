@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/samuelstevens/claude-unfocused/internal/ansiparse"
+)
+
+// transcript writes the child's output as plain text, with escape sequences
+// stripped, for grepping what was said. Cursor positioning starts a new line
+// so that full-screen redraws stay readable, and runs of blank lines are
+// squeezed to one. A nil *transcript discards everything.
+type transcript struct {
+	mu     sync.Mutex
+	f      io.WriteCloser
+	w      *bufio.Writer
+	parser ansiparse.Parser
+	col    int // bytes written on the current line
+	blank  int // newlines written since the last text
+}
+
+// newTranscript creates the transcript at path, with secrets masked by
+// redact if it is set.
+func newTranscript(path string, redact *redactor) (*transcript, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return transcriptTo(redactTo(file, redact)), nil
+}
+
+// transcriptTo returns a transcript written to f.
+func transcriptTo(f io.WriteCloser) *transcript {
+	return &transcript{f: f, w: bufio.NewWriter(f), blank: 2}
+}
+
+// Write logs child output, so a transcript can sit in an io.MultiWriter.
+func (t *transcript) Write(b []byte) (int, error) {
+	if t == nil {
+		return len(b), nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.f == nil {
+		return len(b), nil
+	}
+	t.parser.Feed(b, func(seq ansiparse.Sequence) {
+		switch seq.Kind {
+		case ansiparse.Text:
+			t.text(seq.Raw)
+		case ansiparse.Control:
+			switch seq.Raw[0] {
+			case '\n':
+				t.newline()
+			case '\t':
+				t.text(seq.Raw)
+			}
+		case ansiparse.CSI:
+			switch seq.Final {
+			case 'H', 'f', 'd', 'E', 'F': // cursor moved to another line
+				if seq.Private() == 0 && t.col > 0 {
+					t.newline()
+				}
+			}
+		}
+	})
+	return len(b), t.w.Flush()
+}
+
+func (t *transcript) text(b []byte) {
+	_, _ = t.w.Write(b)
+	t.col += len(b)
+	t.blank = 0
+}
+
+func (t *transcript) newline() {
+	if t.blank >= 2 {
+		return
+	}
+	_ = t.w.WriteByte('\n')
+	t.col = 0
+	t.blank++
+}
+
+func (t *transcript) Close() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.f == nil {
+		return nil
+	}
+	if t.col > 0 {
+		_ = t.w.WriteByte('\n')
+	}
+	err := t.w.Flush()
+	if cerr := t.f.Close(); err == nil {
+		err = cerr
+	}
+	t.f = nil
+	return err
+}
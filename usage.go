@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// usagePoll is how often the usage tracker reads what claude has added to
+// its session logs.
+const usagePoll = 2 * time.Second
+
+// price is what a model charges, in dollars per million tokens.
+type price struct {
+	Input      float64 `toml:"input"`
+	Output     float64 `toml:"output"`
+	CacheWrite float64 `toml:"cache_write"`
+	CacheRead  float64 `toml:"cache_read"`
+}
+
+// modelPrices are the published prices of claude's models, by the start of
+// their names; the longest match wins. usage.prices adds to them or
+// overrides them as prices change.
+var modelPrices = map[string]price{
+	"claude-opus-4-5":   {5, 25, 6.25, 0.5},
+	"claude-opus-4":     {15, 75, 18.75, 1.5},
+	"claude-sonnet-4":   {3, 15, 3.75, 0.3},
+	"claude-3-7-sonnet": {3, 15, 3.75, 0.3},
+	"claude-3-5-sonnet": {3, 15, 3.75, 0.3},
+	"claude-haiku-4-5":  {1, 5, 1.25, 0.1},
+	"claude-3-5-haiku":  {0.8, 4, 1, 0.08},
+}
+
+// claudeProjectDir returns the directory claude keeps the session logs of
+// a project in dir: under its config directory, named after dir with
+// everything but letters and digits made a dash.
+func claudeProjectDir(dir string) (string, error) {
+	config := os.Getenv("CLAUDE_CONFIG_DIR")
+	if config == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		config = filepath.Join(home, ".claude")
+	}
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '-'
+	}, dir)
+	return filepath.Join(config, "projects", name), nil
+}
+
+// usageTotals is what the session has used: tokens of each kind, and what
+// they cost as far as the models' prices are known.
+type usageTotals struct {
+	Input, Output, CacheWrite, CacheRead int64
+	Cost                                 float64
+	Unpriced                             bool // some tokens went to a model of unknown price
+}
+
+// usageTracker adds up the tokens claude uses, and their cost, from the
+// session logs it writes as it goes: the JSONL files of the project's
+// directory, read from where they ended when the wrapper started, so a
+// resumed session only counts what it adds. Each reply is counted once,
+// however many lines it is logged over. A nil *usageTracker tracks
+// nothing.
+type usageTracker struct {
+	dir    string
+	prices map[string]price
+
+	mu      sync.Mutex
+	offsets map[string]int64
+	seen    map[string]bool // replies counted, by message and request
+	totals  usageTotals
+	stop    chan struct{}
+}
+
+func newUsageTracker(dir string, prices map[string]price) *usageTracker {
+	u := &usageTracker{dir: dir, prices: map[string]price{}, offsets: map[string]int64{}, seen: map[string]bool{}, stop: make(chan struct{})}
+	for name, p := range modelPrices {
+		u.prices[name] = p
+	}
+	for name, p := range prices {
+		u.prices[name] = p
+	}
+	logs, _ := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	for _, path := range logs {
+		if info, err := os.Stat(path); err == nil {
+			u.offsets[path] = info.Size()
+		}
+	}
+	go func() {
+		tick := time.NewTicker(usagePoll)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				u.poll()
+			case <-u.stop:
+				return
+			}
+		}
+	}()
+	return u
+}
+
+// poll reads the complete lines added to each log since the last poll.
+func (u *usageTracker) poll() {
+	logs, _ := filepath.Glob(filepath.Join(u.dir, "*.jsonl"))
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, path := range logs {
+		u.read(path)
+	}
+}
+
+// read reads path from its offset. The caller holds mu.
+func (u *usageTracker) read(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer func() { _ = f.Close() }()
+	off := u.offsets[path]
+	if info, err := f.Stat(); err != nil || info.Size() < off {
+		off = 0 // rewritten
+	}
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		return
+	}
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			break // a partial line is read again once it is whole
+		}
+		off += int64(len(line))
+		u.count(line)
+	}
+	u.offsets[path] = off
+}
+
+// count adds the usage of the reply logged on line, if it is one. The caller
+// holds mu.
+func (u *usageTracker) count(line []byte) {
+	if !bytes.Contains(line, []byte(`"usage"`)) {
+		return
+	}
+	var entry struct {
+		RequestID string   `json:"requestId"`
+		CostUSD   *float64 `json:"costUSD"`
+		Message   struct {
+			ID    string `json:"id"`
+			Model string `json:"model"`
+			Usage *struct {
+				Input      int64 `json:"input_tokens"`
+				Output     int64 `json:"output_tokens"`
+				CacheWrite int64 `json:"cache_creation_input_tokens"`
+				CacheRead  int64 `json:"cache_read_input_tokens"`
+			} `json:"usage"`
+		} `json:"message"`
+	}
+	if json.Unmarshal(line, &entry) != nil || entry.Message.Usage == nil {
+		return
+	}
+	if key := entry.Message.ID + ":" + entry.RequestID; key != ":" {
+		if u.seen[key] {
+			return
+		}
+		u.seen[key] = true
+	}
+	use := entry.Message.Usage
+	t := &u.totals
+	t.Input += use.Input
+	t.Output += use.Output
+	t.CacheWrite += use.CacheWrite
+	t.CacheRead += use.CacheRead
+	if entry.CostUSD != nil {
+		t.Cost += *entry.CostUSD
+		return
+	}
+	p, ok := u.price(entry.Message.Model)
+	if !ok {
+		t.Unpriced = t.Unpriced || use.Input+use.Output+use.CacheWrite+use.CacheRead > 0
+		return
+	}
+	t.Cost += (float64(use.Input)*p.Input + float64(use.Output)*p.Output +
+		float64(use.CacheWrite)*p.CacheWrite + float64(use.CacheRead)*p.CacheRead) / 1e6
+}
+
+// price returns the price of model, by the longest of the prices' names it
+// starts with.
+func (u *usageTracker) price(model string) (price, bool) {
+	var best string
+	for name := range u.prices {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	p, ok := u.prices[best]
+	return p, ok && best != ""
+}
+
+// current returns the totals so far.
+func (u *usageTracker) current() usageTotals {
+	if u == nil {
+		return usageTotals{}
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.totals
+}
+
+// short returns the totals as the status line shows them.
+func (u *usageTracker) short() string {
+	t := u.current()
+	return fmt.Sprintf("%s tokens | %s", tokenCount(t.Input+t.Output+t.CacheWrite+t.CacheRead), t.cost())
+}
+
+// summary returns the totals as the wrapper reports them on exit.
+func (u *usageTracker) summary() string {
+	t := u.current()
+	return fmt.Sprintf("%s input, %s output, %s cache write and %s cache read tokens, %s",
+		tokenCount(t.Input), tokenCount(t.Output), tokenCount(t.CacheWrite), tokenCount(t.CacheRead), t.cost())
+}
+
+// cost formats the cost, marked as a lower bound if some of it is unknown.
+func (t usageTotals) cost() string {
+	if t.Unpriced {
+		return fmt.Sprintf("at least $%.2f", t.Cost)
+	}
+	return fmt.Sprintf("$%.2f", t.Cost)
+}
+
+// tokenCount formats n tokens in thousands or millions past a thousand.
+func tokenCount(n int64) string {
+	switch {
+	case n >= 1e6:
+		return fmt.Sprintf("%.1fM", float64(n)/1e6)
+	case n >= 1e3:
+		return fmt.Sprintf("%.1fk", float64(n)/1e3)
+	}
+	return fmt.Sprint(n)
+}
+
+// Close reads what the logs gained last and stops the tracker.
+func (u *usageTracker) Close() {
+	if u == nil {
+		return
+	}
+	close(u.stop)
+	u.poll()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build metadata, set by release builds with
+//
+//	go build -ldflags "-X main.version=v1.2.3 -X main.commit=$(git rev-parse HEAD) -X main.date=$(date -u +%FT%TZ)"
+//
+// Anything left empty is filled in from the module and VCS information the
+// Go toolchain embeds, which covers go install and plain go build.
+var (
+	version string
+	commit  string
+	date    string
+)
+
+// versionString describes this build of the wrapper.
+func versionString() string {
+	v, c, d, dirty := version, commit, date, false
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if v == "" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			v = info.Main.Version
+		}
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if c == "" {
+					c = s.Value
+				}
+			case "vcs.time":
+				if d == "" {
+					d = s.Value
+				}
+			case "vcs.modified":
+				dirty = s.Value == "true" && commit == ""
+			}
+		}
+	}
+	if v == "" {
+		v = "dev"
+	}
+	if c == "" {
+		c = "unknown"
+	} else if dirty {
+		c += " (modified)"
+	}
+	if d == "" {
+		d = "unknown"
+	}
+	return fmt.Sprintf("claude-unfocused %s\ncommit: %s\ndate:   %s\ngo:     %s %s/%s\n",
+		v, c, d, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// webhookFormats are the accepted values of webhook.format, the shape of
+// the JSON posted:
+//
+//	generic  {"event": ..., "message": ..., "session": ..., "dir": ..., "time": ...}
+//	slack    {"text": message}, for a Slack incoming webhook
+//	discord  {"content": message}, for a Discord webhook
+var webhookFormats = map[string]bool{"generic": true, "slack": true, "discord": true}
+
+// webhookEvents are the events a webhook can be sent: the child waiting for
+// input, asking for permission, exiting, and crashing.
+var webhookEvents = []string{"idle", "permission", "exit", "crash"}
+
+// webhookTimeout bounds each post, and how long the wrapper waits on exit
+// for posts still in flight.
+const webhookTimeout = 10 * time.Second
+
+// webhook posts session events to a URL. Posts are made in the background;
+// failures are logged. A nil *webhook sends nothing.
+type webhook struct {
+	cfg     webhookConfig
+	session string
+	dir     string
+	client  *http.Client
+	wg      sync.WaitGroup
+}
+
+func newWebhook(cfg webhookConfig, session string) *webhook {
+	dir, _ := os.Getwd()
+	return &webhook{cfg: cfg, session: session, dir: dir, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+// wants reports whether event is to be sent.
+func (h *webhook) wants(event string) bool {
+	return h != nil && slices.Contains(h.cfg.Events, event)
+}
+
+// send posts event, described by msg, if it is wanted.
+func (h *webhook) send(event, msg string) {
+	if !h.wants(event) {
+		return
+	}
+	text := fmt.Sprintf("%s (%s)", msg, filepath.Base(h.dir))
+	if h.session != "" {
+		text = fmt.Sprintf("%s (session %s, %s)", msg, h.session, filepath.Base(h.dir))
+	}
+	var payload any
+	switch h.cfg.Format {
+	case "slack":
+		payload = map[string]string{"text": text}
+	case "discord":
+		payload = map[string]string{"content": text}
+	default:
+		payload = map[string]string{
+			"event":   event,
+			"message": msg,
+			"session": h.session,
+			"dir":     h.dir,
+			"time":    time.Now().Format(time.RFC3339),
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		resp, err := h.client.Post(h.cfg.URL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("warning: webhook: %v", err)
+			return
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			log.Printf("warning: webhook: %s", resp.Status)
+		}
+	}()
+}
+
+// exited sends the exit or crash event for a child that exited with code.
+func (h *webhook) exited(name string, code int) {
+	if code == 0 {
+		h.send("exit", name+" exited")
+	} else {
+		h.send("crash", fmt.Sprintf("%s crashed (exit code %d)", name, code))
+	}
+}
+
+// Close waits for posts in flight.
+func (h *webhook) Close() {
+	if h == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		h.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(webhookTimeout):
+	}
+}
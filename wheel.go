@@ -0,0 +1,102 @@
+package main
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/samuelstevens/claude-unfocused/internal/ansiparse"
+	"github.com/samuelstevens/claude-unfocused/pkg/escfilter"
+)
+
+// Sequences turning the terminal's mouse reporting on and off for the
+// wrapper: button events, in SGR encoding.
+const (
+	wheelOn  = "\x1b[?1000h\x1b[?1006h"
+	wheelOff = "\x1b[?1000l"
+	sgrOff   = "\x1b[?1006l"
+)
+
+// wheelScroller turns mouse reporting on while the child is on the
+// alternate screen without having turned it on itself, where the terminal
+// has no scrollback of its own and would send the wheel as arrow keys, so
+// that the filter can hand the wheel to the scrollback view instead. A nil
+// *wheelScroller does nothing.
+type wheelScroller struct {
+	filter *escfilter.Filter
+
+	mu    sync.Mutex
+	alt   bool // the child is on the alternate screen
+	mouse bool // the child has mouse reporting on
+	sgr   bool // the child has SGR encoding on
+	on    bool // the wrapper has mouse reporting on
+}
+
+// rule follows the child's screen and mouse modes, switching the wrapper's
+// mouse reporting after the sequence that calls for it.
+func (w *wheelScroller) rule() outputRule {
+	return func(seq ansiparse.Sequence) ([]byte, bool) {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if seq.Kind == ansiparse.Esc && len(seq.Intermediates) == 0 && seq.Final == 'c' {
+			// A full reset turns reporting off too
+			w.alt, w.mouse, w.sgr, w.on = false, false, false, false
+			w.filter.SetWheel(false)
+			return nil, false
+		}
+		if seq.Kind != ansiparse.CSI || seq.Private() != '?' || len(seq.Intermediates) > 0 ||
+			(seq.Final != 'h' && seq.Final != 'l') {
+			return nil, false
+		}
+		set := seq.Final == 'h'
+		for _, mode := range seq.Ints() {
+			switch mode {
+			case 47, 1047, 1049:
+				w.alt = set
+			case 1000, 1002, 1003:
+				w.mouse = set
+			case 1006:
+				w.sgr = set
+			}
+		}
+		var out []byte
+		switch want := w.alt && !w.mouse; {
+		case w.mouse:
+			w.on = false // the child's reporting takes over
+		case want && !w.on:
+			out = []byte(wheelOn)
+		case !want && w.on:
+			out = []byte(w.off())
+		}
+		w.on = w.alt && !w.mouse
+		w.filter.SetWheel(w.on)
+		if out == nil {
+			return nil, false
+		}
+		return slices.Concat(seq.Raw, out), true
+	}
+}
+
+// off returns the sequences turning the wrapper's mouse reporting off,
+// leaving the child's SGR encoding be. The caller holds mu.
+func (w *wheelScroller) off() string {
+	if w.sgr {
+		return wheelOff
+	}
+	return wheelOff + sgrOff
+}
+
+// restore returns the sequences turning the wrapper's mouse reporting off
+// if it is on, for when the child is gone.
+func (w *wheelScroller) restore() []byte {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.on {
+		return nil
+	}
+	w.on = false
+	w.filter.SetWheel(false)
+	return []byte(w.off())
+}